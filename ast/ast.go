@@ -4,142 +4,285 @@
 package ast
 
 import (
-	"path"
+	"fmt"
+	"slices"
 	"strings"
 )
 
-// Lookup returns the [Property] associated with keyPath, where keyPath has the
-// format
-// "section/key". For example:
+// Lookup returns the [Property] associated with keyPath. For example:
 //   - "foo"     will look for key "foo" in the global section
 //   - "bar/foo" will look for key "foo" in section "bar"
 //
-// If keyPath doesn't exist, Lookup returns nil.
-func (tree *AST) Lookup(keyPath string) *Property {
-	section, key := path.Split(keyPath)
-	section = strings.TrimSuffix(section, "/")
-	// Search in the global section.
-	if section == "" {
-		if i := index(tree.Properties, key); i != -1 {
-			return tree.Properties[i]
-		}
+// See [Path] for the complete syntax.
+//
+// If keyPath doesn't exist, Lookup returns nil. If the section of keyPath
+// exists but doesn't have the key, Lookup returns the key of the first of its
+// parents that has it, recursively (see [WithInheritance]), or else of the
+// defaults section (see [AST.SetDefaultsSection]).
+func (tree *AST) Lookup(keyPath Path) *Property {
+	section, subsection, key := keyPath.split()
+	sec, ok := tree.findSection(section, subsection)
+	if !ok {
 		return nil
 	}
-	// Search in the named sections.
-	for _, sec := range tree.Sections {
-		if sec.Name == section {
-			if i := index(sec.Properties, key); i != -1 {
-				return sec.Properties[i]
-			}
-			return nil
-		}
-	}
-	return nil
+	return tree.lookupIn(sec, key)
 }
 
-// LookupSection returns the [Section] secName.
+// LookupSection returns the [Section] secName, where secName has the syntax
+// described in [SectionName].
 // If the section doesn't exist, LookupSection returns nil.
 func (tree *AST) LookupSection(secName string) *Section {
-	if i := index(tree.Sections, secName); i != -1 {
-		return tree.Sections[i]
-	}
-	return nil
+	sec, _ := tree.findSection(splitSectionName(secName))
+	return sec
 }
 
-// Remove deletes keyPath, where keyPath has the format "section/key".
+// SetCaseInsensitive sets whether the lookups and edits of tree match section
+// names and keys without regard to case, using Unicode case folding as
+// [strings.EqualFold] does. For example, with case-insensitive matching
+// Lookup("Server/Port") finds key "port" in section [SERVER]. Subsections
+// are matched with regard to case, as git does: [remote "Origin"] and
+// [remote "origin"] are different sections.
 //
-// If keyPath does not exist, Remove does nothing.
-func (tree *AST) Remove(keyPath string) {
-	section, key := path.Split(keyPath)
-	section = strings.TrimSuffix(section, "/")
-	// Search in the global section.
-	if section == "" {
-		if i := index(tree.Properties, key); i != -1 {
-			tree.Properties = removeFromSlice(tree.Properties, i)
-		}
+// Matching never changes the names stored in the tree: an edit of an existing
+// property or section keeps its original spelling.
+func (tree *AST) SetCaseInsensitive(on bool) {
+	if tree.foldCase == on {
 		return
 	}
-	// Search in the named sections.
-	for _, sec := range tree.Sections {
-		if sec.Name == section {
-			if i := index(sec.Properties, key); i != -1 {
-				sec.Properties = removeFromSlice(sec.Properties, i)
-			}
-			return
-		}
+	tree.foldCase = on
+	tree.Reindex()
+}
+
+// CaseInsensitive reports whether tree matches names without regard to case.
+// See [AST.SetCaseInsensitive].
+func (tree *AST) CaseInsensitive() bool {
+	return tree.foldCase
+}
+
+// Outcome reports the effect of an edit method such as [AST.Add].
+type Outcome int
+
+const (
+	// NotFound means that nothing was changed because the target was missing.
+	NotFound Outcome = iota
+	// Unchanged means that the target already had the requested value.
+	Unchanged
+	// Created means that the target was added.
+	Created
+	// Updated means that the value of the target was replaced.
+	Updated
+	// Removed means that the target was deleted.
+	Removed
+)
+
+func (outcome Outcome) String() string {
+	switch outcome {
+	case NotFound:
+		return "not found"
+	case Unchanged:
+		return "unchanged"
+	case Created:
+		return "created"
+	case Updated:
+		return "updated"
+	case Removed:
+		return "removed"
+	default:
+		return fmt.Sprintf("Outcome(%d)", int(outcome))
 	}
 }
 
-// RemoveSection deletes secName and all its properties.
+// Changed reports whether the edit modified the AST.
+func (outcome Outcome) Changed() bool {
+	return outcome == Created || outcome == Updated || outcome == Removed
+}
+
+// Remove deletes keyPath and returns the removed property and [Removed].
 //
-// If secName does not exist, RemoveSection does nothing.
-func (tree *AST) RemoveSection(secName string) {
-	if i := index(tree.Sections, secName); i != -1 {
-		tree.Sections = removeFromSlice(tree.Sections, i)
+// If keyPath does not exist, Remove does nothing and returns nil and
+// [NotFound].
+func (tree *AST) Remove(keyPath Path) (*Property, Outcome) {
+	section, subsection, key := keyPath.split()
+	sec, ok := tree.findSection(section, subsection)
+	if !ok {
+		return nil, NotFound
+	}
+	prop, ok := remove(tree.propsIndex(sec), tree.properties(sec), key, tree.foldCase)
+	if !ok {
+		return nil, NotFound
 	}
+	return prop, Removed
 }
 
-// Add replaces the value of keyPath with newVal, where keyPath has the format
-// "section/key".
+// RemoveSection deletes secName and all its properties, and returns the
+// removed section and [Removed].
 //
-// If keyPath does not exist, Add appends the key pair at the end of the
-// section. Note that the type of newVal can be different from the previous
-// type.
-//
-// Use [Lookup] beforehand if you need to ensure the presence of keyPath.
-func (tree *AST) Add(keyPath string, newVal Value) {
-	section, key := path.Split(keyPath)
-	section = strings.TrimSuffix(section, "/")
-
-	// Add in the global section.
-	if section == "" {
-		add(&tree.Properties, key, newVal)
-		return
+// If secName does not exist, RemoveSection does nothing and returns nil and
+// [NotFound]. The sections that inherit from secName (see [WithInheritance])
+// keep it among their parents; [AST.CheckInheritance] reports it as missing.
+func (tree *AST) RemoveSection(secName string) (*Section, Outcome) {
+	sec, ok := remove(tree.sectionsIndex(), &tree.Sections, sectionKey(secName),
+		tree.foldCase)
+	if !ok {
+		return nil, NotFound
 	}
+	tree.forgetSection(sec)
+	return sec, Removed
+}
 
-	// Add in the named sections.
-	for _, sect := range tree.Sections {
-		if sect.Name == section {
-			add(&sect.Properties, key, newVal)
-			return
-		}
-	}
+// Add replaces the value of keyPath with newVal and returns the property.
+// The returned [Outcome] is [Updated], or [Unchanged] if the value was already
+// newVal.
+//
+// If keyPath does not exist, Add appends the key pair at the end of the
+// section, creating the section if needed, and returns [Created]. Note that
+// the type of newVal can be different from the previous type.
+func (tree *AST) Add(keyPath Path, newVal Value) (*Property, Outcome) {
+	section, subsection, key := keyPath.split()
 
-	// The section doesn't exist. Create it and add the pair there.
-	tree.Sections = append(tree.Sections, &Section{
-		Name: section,
-		Properties: []*Property{{
-			Key:   key,
-			Value: newVal,
-		}},
-	})
-}
+	sec, ok := tree.findSection(section, subsection)
+	if !ok {
+		// The section doesn't exist. Create it and add the pair there.
+		sec = &Section{Name: section, Subsection: subsection}
+		push(tree.sectionsIndex(), &tree.Sections, sec)
+	}
 
-func add(properties *[]*Property, key string, newVal Value) {
-	if i := index(*properties, key); i != -1 {
+	ix := tree.propsIndex(sec)
+	props := tree.properties(sec)
+	if prop, ok := find(ix, *props, key, tree.foldCase); ok {
+		if prop.Value == newVal {
+			return prop, Unchanged
+		}
 		// replace
-		(*properties)[i].Value = newVal
-		return
+		prop.Value = newVal
+		return prop, Updated
 	}
 	// append
-	*properties = append(*properties, &Property{
+	prop := &Property{
 		Key:   key,
 		Value: newVal,
-	})
-	return
+	}
+	push(ix, props, prop)
+	return prop, Created
+}
+
+// findSection returns the section named name and subsection. The global
+// section, named "", is represented by a nil *Section and is always found.
+func (tree *AST) findSection(name, subsection string) (*Section, bool) {
+	if name == "" && subsection == "" {
+		return nil, true
+	}
+	return find(tree.sectionsIndex(), tree.Sections, SectionName(name, subsection),
+		tree.foldCase)
+}
+
+// properties returns the properties of sec (nil for the global section).
+func (tree *AST) properties(sec *Section) *[]*Property {
+	if sec == nil {
+		return &tree.Properties
+	}
+	return &sec.Properties
+}
+
+// find returns the first element of a that matches name, using ix if not nil.
+// If fold is true, the match is case-insensitive.
+func find[E namer](ix *nameIndex[E], a []E, name string, fold bool) (E, bool) {
+	if ix != nil {
+		return ix.lookup(a, name)
+	}
+	if i := index(a, name, fold); i != -1 {
+		return a[i], true
+	}
+	var zero E
+	return zero, false
+}
+
+// remove deletes the first element of *a that matches name, keeping ix (if
+// not nil) up to date, and returns the deleted element.
+func remove[E namer](ix *nameIndex[E], a *[]E, name string, fold bool) (E, bool) {
+	e, ok := find(ix, *a, name, fold)
+	if !ok {
+		return e, false
+	}
+	removeAt(ix, a, position(*a, e))
+	return e, true
+}
+
+// removeAt removes the element at position i of a, keeping ix (if not nil) in
+// sync.
+func removeAt[E namer](ix *nameIndex[E], a *[]E, i int) E {
+	e := (*a)[i]
+	*a = removeFromSlice(*a, i)
+	if ix != nil {
+		ix.removed(*a, e)
+	}
+	return e
+}
+
+// push appends e to *a, keeping ix (if not nil) up to date.
+func push[E namer](ix *nameIndex[E], a *[]E, e E) {
+	*a = append(*a, e)
+	if ix != nil {
+		ix.added(*a, e)
+	}
+}
+
+// insert inserts e at position i of *a, keeping ix (if not nil) up to date.
+// The name of e must not be already in *a.
+func insert[E namer](ix *nameIndex[E], a *[]E, i int, e E) {
+	*a = slices.Insert(*a, i, e)
+	if ix != nil {
+		ix.added(*a, e)
+	}
+}
+
+// position returns the position of e in a, or -1.
+func position[E comparable](a []E, e E) int {
+	for i := range a {
+		if a[i] == e {
+			return i
+		}
+	}
+	return -1
 }
 
 // index returns the first element of a that matches name.
+// If fold is true, the match is case-insensitive.
 // If no match, index returns -1.
-func index[S ~[]E, E namer](a S, name string) int {
+func index[S ~[]E, E namer](a S, name string, fold bool) int {
 	for i := range a {
-		if a[i].name() == name {
+		if sameName[E](a[i].name(), name, fold) {
 			return i
 		}
 	}
 	return -1
 }
 
+// sameName reports whether the names a and b of elements of type E match.
+// If fold is true, the match is case-insensitive, except for the subsection of
+// a section name, which is case-sensitive as in git.
+func sameName[E namer](a, b string, fold bool) bool {
+	if !fold {
+		return a == b
+	}
+	if _, ok := any(*new(E)).(*Section); ok {
+		aSec, aSub := cutSectionName(a)
+		bSec, bSub := cutSectionName(b)
+		return aSub == bSub && strings.EqualFold(aSec, bSec)
+	}
+	return strings.EqualFold(a, b)
+}
+
+// foldName returns the name of an element of type E in a form that is the
+// same for all the names that match it according to [sameName] with fold.
+func foldName[E namer](name string) string {
+	if _, ok := any(*new(E)).(*Section); ok {
+		sec, sub := cutSectionName(name)
+		return foldKey(sec) + sub
+	}
+	return foldKey(name)
+}
+
 // Remove the element at index i from slice a. No bounds checks.
 // Use it like append:
 //
@@ -6,6 +6,7 @@
 package ast_test
 
 import (
+	"fmt"
 	"strings"
 	"testing"
 
@@ -117,7 +118,7 @@ city = "Venezia"`
 
 	testCases := []struct {
 		name           string
-		keyPath        string
+		keyPath        ast.Path
 		wantComments   []string
 		wantBlankLines []string
 	}{
@@ -360,6 +361,36 @@ func TestRoundTripCornerCases(t *testing.T) {
 			input: "\n" + "[s1]\n",
 			want:  "[s1]\n",
 		},
+		{
+			name:  "comments at end of input are kept",
+			input: "a = 1\n[s1]\nb = 2\n# c1\n; c2\n",
+			want:  "a = 1\n[s1]\nb = 2\n# c1\n; c2\n",
+		},
+		{
+			name:  "comment at end of input, missing trailing newline is added to output",
+			input: "# c",
+			want:  "# c" + "\n",
+		},
+		{
+			name:  "dangling comment at end of input is kept",
+			input: "a = 1\n# c\n",
+			want:  "a = 1\n# c\n",
+		},
+		{
+			name:  "comment at end of input, spurious trailing newlines are removed",
+			input: "a = 1\n# c\n\n\n",
+			want:  "a = 1\n# c\n",
+		},
+		{
+			name:  "blank line between comments at end of input is kept",
+			input: "a = 1\n# c1\n\n# c2\n",
+			want:  "a = 1\n# c1\n\n# c2\n",
+		},
+		{
+			name:  "blank lines between comments and node are kept",
+			input: "# c1\n\n# c2\n\n\n[s]\n",
+			want:  "# c1\n\n# c2\n\n\n[s]\n",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -402,18 +433,20 @@ b = 2`,
 
 func TestAdd(t *testing.T) {
 	testCases := []struct {
-		name  string
-		input string
-		want  string
-		key   string
-		value ast.Value
+		name    string
+		input   string
+		want    string
+		key     ast.Path
+		value   ast.Value
+		outcome ast.Outcome
 	}{
 		{
-			name:  "update property, global section",
-			input: `foo = "bar"`,
-			want:  `foo = "zoo"`,
-			key:   "foo",
-			value: ast.String{Value: "zoo"},
+			name:    "update property, global section",
+			input:   `foo = "bar"`,
+			want:    `foo = "zoo"`,
+			key:     "foo",
+			value:   ast.String{Value: "zoo"},
+			outcome: ast.Updated,
 		},
 		{
 			name: "update property, named section",
@@ -425,8 +458,9 @@ foo = "bar"`,
 hello = 2
 [fruits]
 foo = "zoo"`,
-			key:   "fruits/foo",
-			value: ast.String{Value: "zoo"},
+			key:     "fruits/foo",
+			value:   ast.String{Value: "zoo"},
+			outcome: ast.Updated,
 		},
 		{
 			name: "append property, global section",
@@ -439,8 +473,9 @@ foo = "bar"
 new = "yes"
 [section1]
 k = "v"`,
-			key:   "new",
-			value: ast.String{Value: "yes"},
+			key:     "new",
+			value:   ast.String{Value: "yes"},
+			outcome: ast.Created,
 		},
 		{
 			name: "append property, named section",
@@ -453,8 +488,17 @@ g1 = 1
 [section1]
 s1 = 2
 new = "yes"`,
-			key:   "section1/new",
-			value: ast.String{Value: "yes"},
+			key:     "section1/new",
+			value:   ast.String{Value: "yes"},
+			outcome: ast.Created,
+		},
+		{
+			name:    "same value is unchanged",
+			input:   `foo = "bar"`,
+			want:    `foo = "bar"`,
+			key:     "foo",
+			value:   ast.String{Value: "bar"},
+			outcome: ast.Unchanged,
 		},
 		{
 			name: "create new section and append key there",
@@ -466,8 +510,9 @@ a = 1`,
 a = 1
 [s2]
 b = 2`,
-			key:   "s2/b",
-			value: ast.Number{Value: 2},
+			key:     "s2/b",
+			value:   ast.Number{Value: 2},
+			outcome: ast.Created,
 		},
 	}
 
@@ -476,10 +521,12 @@ b = 2`,
 			tree := parse(t, tc.input)
 			tc.want = normalizeEnds(tc.want)
 
-			tree.Add(tc.key, tc.value)
+			prop, outcome := tree.Add(tc.key, tc.value)
 			have := tree.String()
 
 			qt.Assert(t, qt.Equals(have, tc.want))
+			qt.Assert(t, qt.Equals(outcome, tc.outcome))
+			qt.Assert(t, qt.Equals(prop, tree.Lookup(tc.key)))
 		})
 	}
 
@@ -487,16 +534,18 @@ b = 2`,
 
 func TestRemove(t *testing.T) {
 	testCases := []struct {
-		name   string
-		input  string
-		want   string
-		remove string
+		name    string
+		input   string
+		want    string
+		remove  ast.Path
+		outcome ast.Outcome
 	}{
 		{
-			name:   "non-existing is not an error",
-			input:  `hello = 1`,
-			want:   `hello = 1`,
-			remove: "foo",
+			name:    "non-existing is not an error",
+			input:   `hello = 1`,
+			want:    `hello = 1`,
+			remove:  "foo",
+			outcome: ast.NotFound,
 		},
 		{
 			name: "does not remove a section with the same name of the key",
@@ -506,7 +555,8 @@ a = 1`,
 			want: `
 [s1]
 a = 1`,
-			remove: "s1",
+			remove:  "s1",
+			outcome: ast.NotFound,
 		},
 		{
 			name: "remove from global section",
@@ -517,7 +567,8 @@ c = 3`,
 			want: `
 a = 1
 c = 3`,
-			remove: "b",
+			remove:  "b",
+			outcome: ast.Removed,
 		},
 		{
 			name: "remove from global section with comments",
@@ -533,7 +584,8 @@ c = 3`,
 a = 1
 # comment for c
 c = 3`,
-			remove: "b",
+			remove:  "b",
+			outcome: ast.Removed,
 		},
 		{
 			name: "remove from global section with comments and newlines",
@@ -548,7 +600,8 @@ c = 3`,
 a = 1
 
 c = 3`,
-			remove: "b",
+			remove:  "b",
+			outcome: ast.Removed,
 		},
 		{
 			name: "remove from named section",
@@ -566,7 +619,8 @@ a = 1
 c = 3
 
 [s2]`,
-			remove: "s1/b",
+			remove:  "s1/b",
+			outcome: ast.Removed,
 		},
 		{
 			name: "leaves section alone also if last element",
@@ -575,7 +629,8 @@ c = 3
 a = 1`,
 			want: `
 [s1]`,
-			remove: "s1/a",
+			remove:  "s1/a",
+			outcome: ast.Removed,
 		},
 	}
 
@@ -584,20 +639,22 @@ a = 1`,
 			tree := parse(t, tc.input)
 			tc.want = normalizeEnds(tc.want)
 
-			tree.Remove(tc.remove)
+			_, outcome := tree.Remove(tc.remove)
 			have := tree.String()
 
 			qt.Assert(t, qt.Equals(have, tc.want))
+			qt.Assert(t, qt.Equals(outcome, tc.outcome))
 		})
 	}
 }
 
 func TestRemoveSection(t *testing.T) {
 	testCases := []struct {
-		name   string
-		input  string
-		want   string
-		remove string
+		name    string
+		input   string
+		want    string
+		remove  string
+		outcome ast.Outcome
 	}{
 		{
 			name: "non-existing section is not an error",
@@ -609,7 +666,8 @@ b = 2`,
 a = 1
 [s1]
 b = 2`,
-			remove: "s2",
+			remove:  "s2",
+			outcome: ast.NotFound,
 		},
 		{
 			name: "does not remove a key with the same Name of the section",
@@ -621,7 +679,8 @@ b = 2`,
 a = 1
 [s1]
 b = 2`,
-			remove: "a",
+			remove:  "a",
+			outcome: ast.NotFound,
 		},
 		{
 			name: "remove empty section",
@@ -634,7 +693,8 @@ b = 2`,
 a = 1
 [s2]
 b = 2`,
-			remove: "s1",
+			remove:  "s1",
+			outcome: ast.Removed,
 		},
 		{
 			name: "remove non empty section",
@@ -651,7 +711,8 @@ a = 1
 # comment for s2
 [s2]
 c = 3`,
-			remove: "s1",
+			remove:  "s1",
+			outcome: ast.Removed,
 		},
 	}
 
@@ -660,10 +721,11 @@ c = 3`,
 			tree := parse(t, tc.input)
 			tc.want = normalizeEnds(tc.want)
 
-			tree.RemoveSection(tc.remove)
+			_, outcome := tree.RemoveSection(tc.remove)
 			have := tree.String()
 
 			qt.Assert(t, qt.Equals(have, tc.want))
+			qt.Assert(t, qt.Equals(outcome, tc.outcome))
 		})
 	}
 }
@@ -673,7 +735,7 @@ func TestAddCommentViaLookup(t *testing.T) {
 		name     string
 		input    string
 		want     string
-		path     string
+		path     ast.Path
 		value    ast.Value
 		comments []string
 	}
@@ -684,9 +746,7 @@ func TestAddCommentViaLookup(t *testing.T) {
 
 		prop := tree.Lookup(tc.path)
 		if prop == nil {
-			// FIXME Add should return the prop ?
-			tree.Add(tc.path, tc.value)
-			prop = tree.Lookup(tc.path)
+			prop, _ = tree.Add(tc.path, tc.value)
 		}
 		qt.Assert(t, qt.IsNotNil(prop))
 		prop.Comments = tc.comments
@@ -728,6 +788,113 @@ b = 2`,
 	}
 }
 
+func TestCaseInsensitive(t *testing.T) {
+	input := `
+Name = "x"
+keep = 1
+[Server]
+Port = 80
+[ΟΔΟΣ]
+Straße = 1`
+
+	testCases := []struct {
+		name    string
+		keyPath ast.Path
+		want    string // key found, or "" if not found
+	}{
+		{name: "ASCII", keyPath: "server/PORT", want: "Port"},
+		{name: "global section", keyPath: "NAME", want: "Name"},
+		{name: "Unicode", keyPath: "οδοσ/STRAßE", want: "Straße"},
+		{name: "final sigma", keyPath: "ΟΔΟς/straße", want: "Straße"},
+		{name: "Kelvin sign", keyPath: "\u212Aeep", want: "keep"},
+		{name: "long s", keyPath: "ſerver/port", want: "Port"},
+		{name: "no full case folding", keyPath: "ΟΔΟΣ/STRASSE"},
+	}
+
+	for _, tc := range testCases {
+		for _, indexed := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s, indexed %v", tc.name, indexed), func(t *testing.T) {
+				tree, err := ast.NewParser(ast.WithStrict(false)).ParseString("", input)
+				qt.Assert(t, qt.IsNil(err))
+				tree.SetCaseInsensitive(true)
+				if indexed {
+					tree.EnableIndex()
+				}
+
+				prop := tree.Lookup(tc.keyPath)
+
+				if tc.want == "" {
+					qt.Assert(t, qt.IsNil(prop))
+				} else {
+					qt.Assert(t, qt.IsNotNil(prop))
+					qt.Assert(t, qt.Equals(prop.Key, tc.want))
+				}
+			})
+		}
+	}
+}
+
+func TestCaseInsensitiveSubsection(t *testing.T) {
+	input := `
+[Remote "Origin"]
+url = "a"
+[remote "origin"]
+url = "b"`
+
+	for _, indexed := range []bool{false, true} {
+		t.Run(fmt.Sprintf("indexed %v", indexed), func(t *testing.T) {
+			tree := parse(t, input)
+			tree.SetCaseInsensitive(true)
+			if indexed {
+				tree.EnableIndex()
+			}
+
+			qt.Assert(t, qt.Equals(tree.Lookup("REMOTE/Origin/URL").Value, ast.Value(ast.String{Value: "a"})))
+			qt.Assert(t, qt.Equals(tree.Lookup("Remote/origin/url").Value, ast.Value(ast.String{Value: "b"})))
+			qt.Assert(t, qt.IsNil(tree.Lookup("remote/ORIGIN/url")))
+			p, err := ast.CompileGlob("REMOTE/origin/*")
+			qt.Assert(t, qt.IsNil(err))
+			matches := tree.Query(p)
+			qt.Assert(t, qt.HasLen(matches, 1))
+			qt.Assert(t, qt.Equals(matches[0].Path, ast.Path("remote/origin/url")))
+		})
+	}
+}
+
+func TestCaseInsensitiveEditsPreserveSpelling(t *testing.T) {
+	input := `
+[Server]
+Port = 80
+Host = "a"
+[Client]
+Retries = 3`
+	tree := parse(t, input)
+	tree.EnableIndex()
+	qt.Assert(t, qt.IsNil(tree.Lookup("server/port")))
+
+	tree.SetCaseInsensitive(true)
+	qt.Assert(t, qt.IsTrue(tree.CaseInsensitive()))
+
+	_, outcome := tree.Add("SERVER/PORT", ast.Number{Value: 81})
+	qt.Assert(t, qt.Equals(outcome, ast.Updated))
+	_, outcome = tree.Add("server/timeout", ast.Number{Value: 5})
+	qt.Assert(t, qt.Equals(outcome, ast.Created))
+	_, outcome = tree.Remove("server/HOST")
+	qt.Assert(t, qt.Equals(outcome, ast.Removed))
+	_, outcome = tree.RemoveSection("CLIENT")
+	qt.Assert(t, qt.Equals(outcome, ast.Removed))
+
+	want := `
+[Server]
+Port = 81
+timeout = 5`
+	qt.Assert(t, qt.Equals(tree.String(), normalizeEnds(want)))
+
+	tree.SetCaseInsensitive(false)
+	qt.Assert(t, qt.IsNil(tree.Lookup("server/port")))
+	qt.Assert(t, qt.IsNotNil(tree.Lookup("Server/Port")))
+}
+
 //
 // Helpers.
 //
@@ -0,0 +1,125 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast
+
+import "slices"
+
+// Clone returns a deep copy of tree: editing the copy doesn't change tree
+// and vice versa. The copy has the same case sensitivity, defaults section
+// and comment marker as tree and, if the index of tree is enabled, its own
+// index.
+func (tree *AST) Clone() *AST {
+	clone := &AST{
+		Pos:           tree.Pos,
+		BlankLines:    slices.Clone(tree.BlankLines),
+		Properties:    cloneProperties(tree.Properties),
+		Comments:      slices.Clone(tree.Comments),
+		foldCase:      tree.foldCase,
+		hasDefaults:   tree.hasDefaults,
+		defaults:      tree.defaults,
+		commentMarker: tree.commentMarker,
+		lenient:       tree.lenient,
+		reserved:      tree.reserved,
+	}
+	if tree.Sections != nil {
+		clone.Sections = make([]*Section, len(tree.Sections))
+		for i, sec := range tree.Sections {
+			clone.Sections[i] = sec.Clone()
+		}
+	}
+	if tree.idx != nil {
+		clone.EnableIndex()
+	}
+	return clone
+}
+
+// Clone returns a deep copy of sec.
+func (sec *Section) Clone() *Section {
+	clone := *sec
+	clone.Comments = slices.Clone(sec.Comments)
+	clone.Parents = slices.Clone(sec.Parents)
+	clone.BlankLines = slices.Clone(sec.BlankLines)
+	clone.Properties = cloneProperties(sec.Properties)
+	return &clone
+}
+
+// Clone returns a deep copy of prop.
+func (prop *Property) Clone() *Property {
+	clone := *prop
+	clone.Comments = slices.Clone(prop.Comments)
+	clone.BlankLines = slices.Clone(prop.BlankLines)
+	return &clone
+}
+
+func cloneProperties(props []*Property) []*Property {
+	if props == nil {
+		return nil
+	}
+	clone := make([]*Property, len(props))
+	for i, prop := range props {
+		clone[i] = prop.Clone()
+	}
+	return clone
+}
+
+// Equal reports whether a and b have the same structure and content: the same
+// sections and properties in the same order, with the same names, values,
+// comments and blank lines. In other words, whether a and b encode to the
+// same text. Positions, case sensitivity, defaults sections and indexes are
+// not compared, and a nil slice is equal to an empty one.
+func Equal(a, b *AST) bool {
+	return slices.Equal(a.BlankLines, b.BlankLines) &&
+		slices.EqualFunc(a.Properties, b.Properties, equalProperty) &&
+		slices.EqualFunc(a.Sections, b.Sections, equalSection) &&
+		slices.Equal(a.Comments, b.Comments)
+}
+
+func equalSection(a, b *Section) bool {
+	return a.Name == b.Name &&
+		a.Subsection == b.Subsection &&
+		slices.Equal(a.Parents, b.Parents) &&
+		a.Template == b.Template &&
+		a.Inheritance == b.Inheritance &&
+		a.inheritanceClause() == b.inheritanceClause() &&
+		slices.Equal(a.Comments, b.Comments) &&
+		slices.Equal(a.BlankLines, b.BlankLines) &&
+		slices.EqualFunc(a.Properties, b.Properties, equalProperty)
+}
+
+func equalProperty(a, b *Property) bool {
+	return a.Key == b.Key &&
+		a.Value == b.Value &&
+		slices.Equal(a.Comments, b.Comments) &&
+		slices.Equal(a.BlankLines, b.BlankLines)
+}
+
+// SemanticEqual reports whether a and b define the same properties, ignoring
+// comments, blank lines, positions and the order of sections and properties.
+// Two trees are semantically equal if every key path has the same value in
+// both, as returned by [AST.Lookup]: since Lookup returns the first of
+// duplicate sections or keys, the later duplicates are ignored, and so are the
+// sections without properties. Names are matched according to the case
+// sensitivity of each tree.
+//
+// SemanticEqual calls Lookup for each property of a and b; enable the index of
+// both trees (see [AST.EnableIndex]) to take linear instead of quadratic time
+// on large trees.
+func SemanticEqual(a, b *AST) bool {
+	return semanticSubset(a, b) && semanticSubset(b, a)
+}
+
+// semanticSubset reports whether the value of each key path of a is the same
+// in b.
+func semanticSubset(a, b *AST) bool {
+	for path, prop := range a.AllProperties() {
+		if a.Lookup(path) != prop {
+			continue // shadowed by a duplicate
+		}
+		other := b.Lookup(path)
+		if other == nil || other.Value != prop.Value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,173 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast_test
+
+import (
+	"testing"
+
+	"github.com/go-quicktest/qt"
+
+	"github.com/marco-m/roundtrip_ini/ast"
+)
+
+const cloneInput = `
+# comment for a
+a = 1
+
+[s1]
+# comment for b
+b = "x"
+[remote "origin"]
+url = "a"`
+
+func TestClone(t *testing.T) {
+	tree := parse(t, cloneInput)
+	tree.EnableIndex()
+	tree.SetCaseInsensitive(true)
+
+	clone := tree.Clone()
+
+	qt.Assert(t, qt.IsTrue(ast.Equal(tree, clone)))
+	qt.Assert(t, qt.Equals(clone.String(), tree.String()))
+	qt.Assert(t, qt.IsTrue(clone.CaseInsensitive()))
+	checkValue(t, clone, "S1/B", ast.String{Value: "x"})
+
+	clone.Lookup("a").Comments[0] = "# changed"
+	clone.Sections[0].Properties[0].Value = ast.Number{Value: 2}
+	_, outcome := clone.Add("remote/origin/fetch", ast.String{Value: "+refs"})
+	qt.Assert(t, qt.Equals(outcome, ast.Created))
+	_, outcome = clone.RemoveSection("s1")
+	qt.Assert(t, qt.Equals(outcome, ast.Removed))
+	checkLookups(t, clone)
+
+	qt.Assert(t, qt.Equals(tree.String(), normalizeEnds(cloneInput)))
+	qt.Assert(t, qt.IsFalse(ast.Equal(tree, clone)))
+	checkLookups(t, tree)
+}
+
+func TestEqual(t *testing.T) {
+	testCases := []struct {
+		name         string
+		a, b         string
+		want         bool
+		wantSemantic bool
+	}{
+		{
+			name: "same", a: cloneInput, b: cloneInput,
+			want: true, wantSemantic: true,
+		},
+		{
+			name: "positions",
+			a:    "a = 1\n[s1]\nb = 2",
+			b:    "  a = 1\n[ s1 ]\n\tb=2",
+			want: true, wantSemantic: true,
+		},
+		{
+			name: "comment",
+			a:    "# one\na = 1",
+			b:    "# two\na = 1",
+			want: false, wantSemantic: true,
+		},
+		{
+			name: "comment at end of input",
+			a:    "a = 1\n# end",
+			b:    "a = 1",
+			want: false, wantSemantic: true,
+		},
+		{
+			name: "blank lines",
+			a:    "a = 1\n[s1]\nb = 2",
+			b:    "a = 1\n\n[s1]\nb = 2",
+			want: false, wantSemantic: true,
+		},
+		{
+			name: "number formatting",
+			a:    "a = 1.50",
+			b:    "a = 1.5",
+			want: true, wantSemantic: true,
+		},
+		{
+			name: "order of properties",
+			a:    "a = 1\nb = 2",
+			b:    "b = 2\na = 1",
+			want: false, wantSemantic: true,
+		},
+		{
+			name: "order of sections",
+			a:    "[s1]\na = 1\n[s2]\na = 2",
+			b:    "[s2]\na = 2\n[s1]\na = 1",
+			want: false, wantSemantic: true,
+		},
+		{
+			name: "shadowed duplicate",
+			a:    "a = 1\na = 2",
+			b:    "a = 1",
+			want: false, wantSemantic: true,
+		},
+		{
+			name: "empty section",
+			a:    "a = 1\n[s1]",
+			b:    "a = 1",
+			want: false, wantSemantic: true,
+		},
+		{
+			name: "value",
+			a:    "a = 1",
+			b:    "a = 2",
+			want: false, wantSemantic: false,
+		},
+		{
+			name: "value type",
+			a:    "a = 1",
+			b:    `a = "1"`,
+			want: false, wantSemantic: false,
+		},
+		{
+			name: "missing key",
+			a:    "a = 1\nb = 2",
+			b:    "a = 1",
+			want: false, wantSemantic: false,
+		},
+		{
+			name: "key in other section",
+			a:    "a = 1",
+			b:    "[s1]\na = 1",
+			want: false, wantSemantic: false,
+		},
+		{
+			name: "subsection",
+			a:    "[remote \"a\"]\nurl = 1",
+			b:    "[remote \"b\"]\nurl = 1",
+			want: false, wantSemantic: false,
+		},
+		{
+			name: "case",
+			a:    "[S1]\nA = 1",
+			b:    "[s1]\na = 1",
+			want: false, wantSemantic: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := parse(t, tc.a)
+			b := parse(t, tc.b)
+
+			qt.Check(t, qt.Equals(ast.Equal(a, b), tc.want))
+			qt.Check(t, qt.Equals(ast.Equal(b, a), tc.want))
+			qt.Check(t, qt.Equals(ast.SemanticEqual(a, b), tc.wantSemantic))
+			qt.Check(t, qt.Equals(ast.SemanticEqual(b, a), tc.wantSemantic))
+		})
+	}
+}
+
+func TestSemanticEqualCaseInsensitive(t *testing.T) {
+	a := parse(t, "[S1]\nA = 1")
+	b := parse(t, "[s1]\na = 1")
+	a.SetCaseInsensitive(true)
+	b.SetCaseInsensitive(true)
+
+	qt.Assert(t, qt.IsTrue(ast.SemanticEqual(a, b)))
+	qt.Assert(t, qt.IsFalse(ast.Equal(a, b)))
+}
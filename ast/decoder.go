@@ -0,0 +1,154 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// EventKind is the kind of an [Event].
+type EventKind int
+
+const (
+	// EventSection is the start of a section. Event.Section and
+	// Event.Subsection are the section name and subsection.
+	EventSection EventKind = iota + 1
+	// EventProperty is a key/value pair. Event.Key and Event.Value are set.
+	EventProperty
+	// EventComment is a comment. Event.Text is the comment, including the
+	// comment marker.
+	EventComment
+	// EventBlankLine is a blank line.
+	EventBlankLine
+)
+
+func (kind EventKind) String() string {
+	switch kind {
+	case EventSection:
+		return "section"
+	case EventProperty:
+		return "property"
+	case EventComment:
+		return "comment"
+	case EventBlankLine:
+		return "blank line"
+	default:
+		return fmt.Sprintf("EventKind(%d)", int(kind))
+	}
+}
+
+// Event is an element of the INI format, as returned by [Decoder.Next].
+type Event struct {
+	Kind EventKind
+	Pos  Position
+	// Section and Subsection identify the section the event belongs to.
+	// Section is "" for the global section.
+	Section    string
+	Subsection string
+	Key        string
+	Value      Value
+	// Text is the text of a comment, or the inheritance clause of a section
+	// header, such as ": parent" (see [WithInheritance]).
+	Text string
+}
+
+// Decoder reads the INI format one element at a time, without building an
+// [AST]. Memory usage does not depend on the size of the input, so Decoder is
+// suited to scan very large files. The caller can stop at any time, and
+// Decoder will read no more input.
+//
+// Decoder accepts the same grammar as [Parser].
+type Decoder struct {
+	ps         parser
+	started    bool
+	section    string // current section
+	subsection string // current subsection
+	lineStart  bool   // no token seen yet on the current line
+	err        error  // sticky error
+}
+
+// NewDecoder returns a decoder that reads from r. Filename is used only to
+// report positions.
+func (p *Parser) NewDecoder(filename string, r io.Reader) *Decoder {
+	return &Decoder{
+		ps:        parser{lex: newLexer(filename, r, &p.cfg)},
+		lineStart: true,
+		err:       p.err,
+	}
+}
+
+// Next returns the next event. At the end of the input, Next returns io.EOF.
+// After an error, Next keeps returning the same error.
+func (dec *Decoder) Next() (Event, error) {
+	if dec.err != nil {
+		return Event{}, dec.err
+	}
+	ev, err := dec.next()
+	if err != nil {
+		dec.err = err
+	}
+	return ev, err
+}
+
+func (dec *Decoder) next() (Event, error) {
+	ps := &dec.ps
+	if !dec.started {
+		dec.started = true
+		if err := ps.next(); err != nil {
+			return Event{}, err
+		}
+	}
+
+	for {
+		tok := ps.tok
+		switch tok.kind {
+		case tokEOF:
+			return Event{}, io.EOF
+		case tokNewLine:
+			if err := ps.next(); err != nil {
+				return Event{}, err
+			}
+			if dec.lineStart {
+				return dec.event(EventBlankLine, tok.pos), nil
+			}
+			dec.lineStart = true
+		case tokComment:
+			dec.lineStart = false
+			if err := ps.next(); err != nil {
+				return Event{}, err
+			}
+			ev := dec.event(EventComment, tok.pos)
+			ev.Text = tok.text
+			return ev, nil
+		case tokIdent:
+			dec.lineStart = false
+			key, val, err := ps.keyValue()
+			if err != nil {
+				return Event{}, err
+			}
+			ev := dec.event(EventProperty, key.pos)
+			ev.Key, ev.Value = key.text, val
+			return ev, nil
+		case tokLBracket:
+			dec.lineStart = false
+			open, name, subsection, clause, err := ps.sectionHeader()
+			if err != nil {
+				return Event{}, err
+			}
+			dec.section, dec.subsection = name.text, subsection.text
+			ev := dec.event(EventSection, open.pos)
+			ev.Text = strings.TrimSpace(clause.text)
+			return ev, nil
+		default:
+			return Event{}, ps.unexpected("key or section")
+		}
+	}
+}
+
+// event returns an event of the current section.
+func (dec *Decoder) event(kind EventKind, pos Position) Event {
+	return Event{Kind: kind, Pos: pos, Section: dec.section, Subsection: dec.subsection}
+}
@@ -0,0 +1,128 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+
+	"github.com/marco-m/roundtrip_ini/ast"
+)
+
+func TestDecoderEvents(t *testing.T) {
+	input := `
+# comment for a
+a = 1
+
+[s1]
+; comment for b
+b = "x"
+# dangling comment
+
+[s2 "sub"]
+c = 2
+`
+	dec := ast.NewParser().NewDecoder("f.ini", strings.NewReader(input))
+
+	pos := func(offset, line, column int) ast.Position {
+		return ast.Position{Filename: "f.ini", Offset: offset, Line: line, Column: column}
+	}
+	want := []ast.Event{
+		{Kind: ast.EventBlankLine, Pos: pos(0, 1, 1)},
+		{Kind: ast.EventComment, Pos: pos(1, 2, 1), Text: "# comment for a"},
+		{Kind: ast.EventProperty, Pos: pos(17, 3, 1), Key: "a", Value: ast.Number{Value: 1}},
+		{Kind: ast.EventBlankLine, Pos: pos(23, 4, 1)},
+		{Kind: ast.EventSection, Pos: pos(24, 5, 1), Section: "s1"},
+		{Kind: ast.EventComment, Pos: pos(29, 6, 1), Section: "s1", Text: "; comment for b"},
+		{Kind: ast.EventProperty, Pos: pos(45, 7, 1), Section: "s1", Key: "b",
+			Value: ast.String{Value: "x"}},
+		{Kind: ast.EventComment, Pos: pos(53, 8, 1), Section: "s1", Text: "# dangling comment"},
+		{Kind: ast.EventBlankLine, Pos: pos(72, 9, 1), Section: "s1"},
+		{Kind: ast.EventSection, Pos: pos(73, 10, 1), Section: "s2", Subsection: "sub"},
+		{Kind: ast.EventProperty, Pos: pos(84, 11, 1), Section: "s2", Subsection: "sub",
+			Key: "c", Value: ast.Number{Value: 2}},
+	}
+
+	var have []ast.Event
+	for {
+		ev, err := dec.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		qt.Assert(t, qt.IsNil(err))
+		have = append(have, ev)
+	}
+
+	qt.Assert(t, qt.DeepEquals(have, want))
+}
+
+func TestDecoderStickyError(t *testing.T) {
+	dec := ast.NewParser().NewDecoder("", strings.NewReader("a = 1\n= 2\nb = 3\n"))
+
+	ev, err := dec.Next()
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(ev.Key, "a"))
+
+	_, err = dec.Next()
+	qt.Assert(t, qt.ErrorMatches(err, `2:1: unexpected token "=" .*`))
+
+	_, err2 := dec.Next()
+	qt.Assert(t, qt.Equals(err2, err))
+}
+
+// endlessReader returns an endless sequence of properties.
+type endlessReader struct {
+	nRead int
+}
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	const line = "k = 1\n"
+	n := 0
+	for n+len(line) <= len(p) {
+		n += copy(p[n:], line)
+	}
+	r.nRead += n
+	return n, nil
+}
+
+func TestDecoderStopEarly(t *testing.T) {
+	endless := &endlessReader{}
+	r := io.MultiReader(strings.NewReader("[s1]\nfound = 42\n[s2]\n"), endless)
+	dec := ast.NewParser().NewDecoder("", r)
+
+	var found ast.Value
+	for found == nil {
+		ev, err := dec.Next()
+		qt.Assert(t, qt.IsNil(err))
+		if ev.Kind == ast.EventProperty && ev.Section == "s1" && ev.Key == "found" {
+			found = ev.Value
+		}
+	}
+
+	qt.Assert(t, qt.Equals(found, ast.Value(ast.Number{Value: 42})))
+	qt.Assert(t, qt.Equals(endless.nRead, 0))
+}
+
+func BenchmarkDecoder(b *testing.B) {
+	input := genINI(100, 100)
+	parser := ast.NewParser()
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		dec := parser.NewDecoder("", strings.NewReader(input))
+		for {
+			_, err := dec.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
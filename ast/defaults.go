@@ -0,0 +1,100 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast
+
+// SetDefaultsSection makes the lookups of tree fall back to section secName,
+// which has the syntax described in [SectionName]: a section inherits the keys
+// of the defaults section that it doesn't have, as with the [DEFAULT] section
+// of Python configparser. If secName is "", the defaults section is the global
+// section. By default there is no defaults section.
+//
+// For example, with defaults section "DEFAULT", Lookup("s1/timeout") returns
+// key timeout of section [DEFAULT] if section [s1] exists and doesn't have
+// key timeout. The section must exist: the keys of the defaults section are
+// not inherited by missing sections.
+//
+// Inheritance affects [AST.Lookup] and [Interpolator]. Use [AST.LookupOwn] to
+// ignore the inherited keys and [AST.Inherited] to tell them apart. The edits
+// act on the keys of each section, so that [AST.Add] of an inherited key adds
+// the key to its section, overriding the default for that section only; use
+// [AST.AddDefault] to change the default instead. The iterators such as
+// [AST.AllProperties] visit the keys of each section, without the inherited
+// ones.
+func (tree *AST) SetDefaultsSection(secName string) {
+	tree.hasDefaults = true
+	tree.defaults = secName
+}
+
+// ClearDefaultsSection removes the defaults section set by
+// [AST.SetDefaultsSection], so that sections don't inherit keys any more. It
+// doesn't remove the section itself.
+func (tree *AST) ClearDefaultsSection() {
+	tree.hasDefaults = false
+	tree.defaults = ""
+}
+
+// DefaultsSection returns the name of the defaults section of tree and true,
+// or "" and false if tree has no defaults section. See
+// [AST.SetDefaultsSection].
+func (tree *AST) DefaultsSection() (string, bool) {
+	return tree.defaults, tree.hasDefaults
+}
+
+// LookupOwn is like [AST.Lookup], but ignores the keys inherited from the
+// parents of the section (see [WithInheritance]) and from the defaults
+// section: it returns nil if the section of keyPath doesn't have the key.
+func (tree *AST) LookupOwn(keyPath Path) *Property {
+	section, subsection, key := keyPath.split()
+	sec, ok := tree.findSection(section, subsection)
+	if !ok {
+		return nil
+	}
+	prop, _ := find(tree.propsIndex(sec), *tree.properties(sec), key, tree.foldCase)
+	return prop
+}
+
+// Inherited reports whether the value of keyPath, as returned by
+// [AST.Lookup], is inherited from a parent of the section (see
+// [WithInheritance]) or from the defaults section rather than set by the
+// section of keyPath.
+func (tree *AST) Inherited(keyPath Path) bool {
+	prop := tree.Lookup(keyPath)
+	return prop != nil && prop != tree.LookupOwn(keyPath)
+}
+
+// AddDefault is like [AST.Add] for the key of keyPath in the defaults
+// section: it changes the value inherited by all the sections that don't
+// have the key, instead of overriding it in the section of keyPath. If the
+// defaults section doesn't exist, AddDefault creates it.
+//
+// If tree has no defaults section, AddDefault does nothing and returns nil
+// and [NotFound].
+func (tree *AST) AddDefault(keyPath Path, newVal Value) (*Property, Outcome) {
+	if !tree.hasDefaults {
+		return nil, NotFound
+	}
+	key := escapeName(keyPath.Key())
+	if tree.defaults == "" {
+		return tree.Add(Path(key), newVal)
+	}
+	return tree.Add(Path(tree.defaults+"/"+key), newVal)
+}
+
+// lookupIn returns key of section sec (nil for the global section) or, if sec
+// doesn't have it, of its parents or of the defaults section. It returns nil
+// if there is no such key.
+func (tree *AST) lookupIn(sec *Section, key string) *Property {
+	if prop := tree.lookupInherited(sec, key, nil); prop != nil {
+		return prop
+	}
+	if !tree.hasDefaults {
+		return nil
+	}
+	defSec, ok := tree.findSection(splitSectionName(tree.defaults))
+	if !ok {
+		return nil
+	}
+	prop, _ := find(tree.propsIndex(defSec), *tree.properties(defSec), key, tree.foldCase)
+	return prop
+}
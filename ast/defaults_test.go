@@ -0,0 +1,126 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast_test
+
+import (
+	"testing"
+
+	"github.com/go-quicktest/qt"
+
+	"github.com/marco-m/roundtrip_ini/ast"
+)
+
+const defaultsInput = `
+[DEFAULT]
+timeout = 10
+user = "nobody"
+[s1]
+timeout = 30
+[s2]
+`
+
+func TestDefaultsSectionLookup(t *testing.T) {
+	type testCase struct {
+		path          ast.Path
+		wantValue     ast.Value // nil if not found
+		wantInherited bool
+	}
+
+	tree := parse(t, defaultsInput)
+	tree.SetDefaultsSection("DEFAULT")
+
+	run := func(t *testing.T, tc testCase) {
+		prop := tree.Lookup(tc.path)
+		if tc.wantValue == nil {
+			qt.Assert(t, qt.IsNil(prop))
+			qt.Assert(t, qt.IsFalse(tree.Inherited(tc.path)))
+			return
+		}
+		qt.Assert(t, qt.Equals(prop.Value, tc.wantValue))
+		qt.Assert(t, qt.Equals(tree.Inherited(tc.path), tc.wantInherited))
+		if tc.wantInherited {
+			qt.Assert(t, qt.IsNil(tree.LookupOwn(tc.path)))
+		} else {
+			qt.Assert(t, qt.Equals(tree.LookupOwn(tc.path), prop))
+		}
+	}
+
+	testCases := []testCase{
+		{path: "s1/timeout", wantValue: ast.Number{Value: 30}},
+		{path: "s1/user", wantValue: ast.String{Value: "nobody"}, wantInherited: true},
+		{path: "s2/timeout", wantValue: ast.Number{Value: 10}, wantInherited: true},
+		{path: "DEFAULT/user", wantValue: ast.String{Value: "nobody"}},
+		{path: "timeout", wantValue: ast.Number{Value: 10}, wantInherited: true},
+		{path: "s2/missing"},
+		{path: "s3/timeout"}, // missing section
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.path), func(t *testing.T) { run(t, tc) })
+	}
+}
+
+func TestDefaultsSectionEdit(t *testing.T) {
+	tree := parse(t, defaultsInput)
+	tree.SetDefaultsSection("DEFAULT")
+
+	// Override locally.
+	_, outcome := tree.Add("s2/timeout", ast.Number{Value: 20})
+	qt.Assert(t, qt.Equals(outcome, ast.Created))
+	qt.Assert(t, qt.IsFalse(tree.Inherited("s2/timeout")))
+
+	// Change the default.
+	prop, outcome := tree.AddDefault("s1/user", ast.String{Value: "www"})
+	qt.Assert(t, qt.Equals(outcome, ast.Updated))
+	qt.Assert(t, qt.Equals(tree.Lookup("s2/user"), prop))
+	_, outcome = tree.AddDefault("s1/retries", ast.Number{Value: 3})
+	qt.Assert(t, qt.Equals(outcome, ast.Created))
+
+	want := `
+[DEFAULT]
+timeout = 10
+user = "www"
+retries = 3
+[s1]
+timeout = 30
+[s2]
+timeout = 20`
+	qt.Assert(t, qt.Equals(tree.String(), normalizeEnds(want)))
+	qt.Assert(t, qt.Equals(tree.Lookup("s1/retries").Value, ast.Value(ast.Number{Value: 3})))
+
+	tree.ClearDefaultsSection()
+	qt.Assert(t, qt.IsNil(tree.Lookup("s1/retries")))
+	_, outcome = tree.AddDefault("s1/user", ast.String{Value: "x"})
+	qt.Assert(t, qt.Equals(outcome, ast.NotFound))
+}
+
+func TestDefaultsSectionGlobal(t *testing.T) {
+	parser := ast.NewParser(ast.WithDefaultsSection(""), ast.WithCaseInsensitive())
+	tree, err := parser.ParseString("", "Level = 1\n[Remote \"origin\"]\nurl = \"x\"")
+	qt.Assert(t, qt.IsNil(err))
+
+	name, ok := tree.DefaultsSection()
+	qt.Assert(t, qt.Equals(name, ""))
+	qt.Assert(t, qt.IsTrue(ok))
+	qt.Assert(t, qt.Equals(tree.Lookup("remote/origin/level").Value, ast.Value(ast.Number{Value: 1})))
+
+	clone := tree.Clone()
+	qt.Assert(t, qt.IsTrue(clone.Inherited("remote/origin/LEVEL")))
+	clone.AddDefault("remote/origin/level", ast.Number{Value: 2})
+	qt.Assert(t, qt.Equals(clone.String(), "Level = 2\n[Remote \"origin\"]\nurl = \"x\"\n"))
+}
+
+func TestDefaultsSectionMerge3(t *testing.T) {
+	base := parse(t, "[DEFAULT]\na = 1\n[s1]\nb = 1")
+	ours := parse(t, "[DEFAULT]\na = 2\n[s1]\nb = 1")
+	theirs := parse(t, "[DEFAULT]\na = 1\n[s1]\na = 3\nb = 1")
+	for _, tree := range []*ast.AST{base, ours, theirs} {
+		tree.SetDefaultsSection("DEFAULT")
+	}
+
+	merged, conflicts := ast.Merge3(base, ours, theirs)
+
+	qt.Assert(t, qt.HasLen(conflicts, 0))
+	qt.Assert(t, qt.Equals(merged.String(), "[DEFAULT]\na = 2\n[s1]\na = 3\nb = 1\n"))
+}
@@ -0,0 +1,316 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ChangeKind is the kind of a [Change].
+type ChangeKind int
+
+const (
+	// SectionAdded is a section present only in the new AST.
+	SectionAdded ChangeKind = iota + 1
+	// SectionRemoved is a section present only in the old AST.
+	SectionRemoved
+	// KeyAdded is a property present only in the new AST.
+	KeyAdded
+	// KeyRemoved is a property present only in the old AST.
+	KeyRemoved
+	// ValueChanged is a change of the value of a property.
+	ValueChanged
+	// CommentChanged is a change of the comments of a section or property.
+	CommentChanged
+	// HeaderChanged is a change of the parents or the template marker of a
+	// section (see [WithInheritance]).
+	HeaderChanged
+)
+
+func (kind ChangeKind) String() string {
+	switch kind {
+	case SectionAdded:
+		return "section added"
+	case SectionRemoved:
+		return "section removed"
+	case KeyAdded:
+		return "key added"
+	case KeyRemoved:
+		return "key removed"
+	case ValueChanged:
+		return "value changed"
+	case CommentChanged:
+		return "comment changed"
+	case HeaderChanged:
+		return "header changed"
+	default:
+		return fmt.Sprintf("ChangeKind(%d)", int(kind))
+	}
+}
+
+// Change is a difference between two ASTs, as returned by [Diff].
+//
+// The fields prefixed by Old describe the section or property in the old
+// AST, the ones prefixed by New in the new AST. They are the zero value on
+// the side where the section or property doesn't exist: the new side of
+// SectionRemoved and KeyRemoved and the old side of SectionAdded and
+// KeyAdded.
+type Change struct {
+	Kind ChangeKind
+	// Path is the path of the property or, for the changes of a section, the
+	// path of the section, with an empty key.
+	Path Path
+	// OldPos and NewPos are the positions of the section or property.
+	OldPos, NewPos Position
+	// OldValue and NewValue are the values of the property; they are nil for
+	// a section.
+	OldValue, NewValue Value
+	// OldComments and NewComments are the comments of the section or
+	// property.
+	OldComments, NewComments []string
+	// OldHeader and NewHeader are the headers of the section, with their
+	// inheritance clauses, such as "[dev : staging]". They are set only for
+	// HeaderChanged.
+	OldHeader, NewHeader string
+}
+
+// String returns a one-line description of the change, such as
+// `key s1/a changed: 1 -> 2`.
+func (c Change) String() string {
+	what := "key " + string(c.Path)
+	if c.Path.Key() == "" {
+		what = "section " + string(appendSectionHeader(nil, c.Path.Section(), c.Path.Subsection()))
+	}
+	switch c.Kind {
+	case SectionAdded, SectionRemoved:
+		return fmt.Sprintf("%s %s", what, strings.TrimPrefix(c.Kind.String(), "section "))
+	case KeyAdded:
+		return fmt.Sprintf("%s added: %s", what, appendValue(nil, c.NewValue))
+	case KeyRemoved:
+		return fmt.Sprintf("%s removed: %s", what, appendValue(nil, c.OldValue))
+	case ValueChanged:
+		return fmt.Sprintf("%s changed: %s -> %s", what,
+			appendValue(nil, c.OldValue), appendValue(nil, c.NewValue))
+	case CommentChanged:
+		return fmt.Sprintf("comments of %s changed", what)
+	case HeaderChanged:
+		return fmt.Sprintf("header of %s changed: %s -> %s", what, c.OldHeader, c.NewHeader)
+	default:
+		return fmt.Sprintf("%s: %s", what, c.Kind)
+	}
+}
+
+// MarshalJSON encodes the change as a JSON object such as
+//
+//	{
+//	  "kind": "value changed",
+//	  "path": "s1/a",
+//	  "old": {"pos": {"offset": 9, "line": 2, "column": 1}, "type": "number", "value": 1},
+//	  "new": {"pos": {"offset": 9, "line": 2, "column": 1}, "type": "number", "value": 2}
+//	}
+//
+// The "old" or "new" member is omitted on the side where the section or
+// property doesn't exist, "type" and "value" are omitted for a section and
+// "comments" is omitted if there are no comments. For HeaderChanged, each side
+// has a "header" member.
+func (c Change) MarshalJSON() ([]byte, error) {
+	type position struct {
+		Filename string `json:"filename,omitempty"`
+		Offset   int    `json:"offset"`
+		Line     int    `json:"line"`
+		Column   int    `json:"column"`
+	}
+	type side struct {
+		Pos position `json:"pos"`
+		*jsonValue
+		Comments []string `json:"comments,omitempty"`
+		Header   string   `json:"header,omitempty"`
+	}
+	makeSide := func(pos Position, val Value, comments []string, header string) *side {
+		return &side{Pos: position(pos), jsonValue: toJSONValue(val), Comments: comments, Header: header}
+	}
+
+	out := struct {
+		Kind string `json:"kind"`
+		Path Path   `json:"path"`
+		Old  *side  `json:"old,omitempty"`
+		New  *side  `json:"new,omitempty"`
+	}{Kind: c.Kind.String(), Path: c.Path}
+	if c.Kind != SectionAdded && c.Kind != KeyAdded {
+		out.Old = makeSide(c.OldPos, c.OldValue, c.OldComments, c.OldHeader)
+	}
+	if c.Kind != SectionRemoved && c.Kind != KeyRemoved {
+		out.New = makeSide(c.NewPos, c.NewValue, c.NewComments, c.NewHeader)
+	}
+	return json.Marshal(out)
+}
+
+// Changes is a list of changes, as returned by [Diff].
+type Changes []Change
+
+// String returns a human-readable summary of the changes, one per line.
+func (changes Changes) String() string {
+	var bld strings.Builder
+	for _, c := range changes {
+		bld.WriteString(c.String())
+		bld.WriteByte('\n')
+	}
+	return bld.String()
+}
+
+// Diff returns the changes that turn old into new, comparing sections and
+// properties by name instead of by position, so that moving a section or a
+// property is not a change. Blank lines are ignored. If both trees are
+// case-insensitive (see [AST.SetCaseInsensitive]), names are compared without
+// regard to case. The syntax of inheritance clauses is ignored: only a change
+// of the parents or the template marker of a section is reported.
+//
+// Duplicates are paired in order: the second section [s1] of old is compared
+// with the second section [s1] of new, and the same for keys.
+//
+// The changes of the global section come first, then the removed sections,
+// in the order of old, and then the changes of the sections of new, in order.
+// For each section, the removed keys come first, in the order of old, and then
+// the changes of the keys of new, in order. An added or removed section is a
+// single change, which doesn't list its keys.
+func Diff(old, new *AST) Changes {
+	d := differ{fold: old.foldCase && new.foldCase}
+	d.properties(nil, nil, old.Properties, new.Properties)
+
+	newToOld, oldPaired := pairUp(old.Sections, new.Sections, d.fold)
+	for i, sec := range old.Sections {
+		if !oldPaired[i] {
+			d.changes = append(d.changes, Change{
+				Kind:        SectionRemoved,
+				Path:        sectionPath(sec),
+				OldPos:      sec.Pos,
+				OldComments: sec.Comments,
+			})
+		}
+	}
+	for j, sec := range new.Sections {
+		if newToOld[j] == -1 {
+			d.changes = append(d.changes, Change{
+				Kind:        SectionAdded,
+				Path:        sectionPath(sec),
+				NewPos:      sec.Pos,
+				NewComments: sec.Comments,
+			})
+			continue
+		}
+		oldSec := old.Sections[newToOld[j]]
+		if !slices.Equal(oldSec.Parents, sec.Parents) || oldSec.Template != sec.Template {
+			d.changes = append(d.changes, Change{
+				Kind:      HeaderChanged,
+				Path:      sectionPath(sec),
+				OldPos:    oldSec.Pos,
+				NewPos:    sec.Pos,
+				OldHeader: header(oldSec),
+				NewHeader: header(sec),
+			})
+		}
+		if !slices.Equal(oldSec.Comments, sec.Comments) {
+			d.changes = append(d.changes, Change{
+				Kind:        CommentChanged,
+				Path:        sectionPath(sec),
+				OldPos:      oldSec.Pos,
+				NewPos:      sec.Pos,
+				OldComments: oldSec.Comments,
+				NewComments: sec.Comments,
+			})
+		}
+		d.properties(oldSec, sec, oldSec.Properties, sec.Properties)
+	}
+	return d.changes
+}
+
+type differ struct {
+	fold    bool
+	changes Changes
+}
+
+// properties appends the changes between the properties of oldSec and newSec
+// (nil for the global section).
+func (d *differ) properties(oldSec, newSec *Section, old, new []*Property) {
+	newToOld, oldPaired := pairUp(old, new, d.fold)
+	for i, prop := range old {
+		if !oldPaired[i] {
+			d.changes = append(d.changes, Change{
+				Kind:        KeyRemoved,
+				Path:        pathOf(oldSec, prop),
+				OldPos:      prop.Pos,
+				OldValue:    prop.Value,
+				OldComments: prop.Comments,
+			})
+		}
+	}
+	for j, prop := range new {
+		c := Change{
+			Path:        pathOf(newSec, prop),
+			NewPos:      prop.Pos,
+			NewValue:    prop.Value,
+			NewComments: prop.Comments,
+		}
+		if newToOld[j] == -1 {
+			c.Kind = KeyAdded
+			d.changes = append(d.changes, c)
+			continue
+		}
+		oldProp := old[newToOld[j]]
+		c.OldPos, c.OldValue, c.OldComments = oldProp.Pos, oldProp.Value, oldProp.Comments
+		if oldProp.Value != prop.Value {
+			c.Kind = ValueChanged
+			d.changes = append(d.changes, c)
+		}
+		if !slices.Equal(oldProp.Comments, prop.Comments) {
+			c.Kind = CommentChanged
+			d.changes = append(d.changes, c)
+		}
+	}
+}
+
+// pairUp pairs the elements of old and new with the same name, the k-th
+// element named x of old with the k-th element named x of new. For each
+// element of new, newToOld is the index of its pair in old, or -1. For each
+// element of old, oldPaired reports whether it has a pair.
+func pairUp[E namer](old, new []E, fold bool) (newToOld []int, oldPaired []bool) {
+	key := func(e E) string {
+		if fold {
+			return foldName[E](e.name())
+		}
+		return e.name()
+	}
+	pending := make(map[string][]int, len(old))
+	for i, e := range old {
+		k := key(e)
+		pending[k] = append(pending[k], i)
+	}
+
+	newToOld = make([]int, len(new))
+	oldPaired = make([]bool, len(old))
+	for j, e := range new {
+		k := key(e)
+		queue := pending[k]
+		if len(queue) == 0 {
+			newToOld[j] = -1
+			continue
+		}
+		newToOld[j], oldPaired[queue[0]] = queue[0], true
+		pending[k] = queue[1:]
+	}
+	return newToOld, oldPaired
+}
+
+// header returns the header of sec, with its inheritance clause.
+func header(sec *Section) string {
+	return string(appendInheritance(appendSectionHeader(nil, sec.Name, sec.Subsection), sec))
+}
+
+// sectionPath returns the path of sec, with an empty key.
+func sectionPath(sec *Section) Path {
+	return NewSubsectionPath(sec.Name, sec.Subsection, "")
+}
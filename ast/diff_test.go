@@ -0,0 +1,185 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+
+	"github.com/marco-m/roundtrip_ini/ast"
+)
+
+func TestDiff(t *testing.T) {
+	type testCase struct {
+		name string
+		old  string
+		new  string
+		want string
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		old := parse(t, tc.old)
+		new := parse(t, tc.new)
+
+		changes := ast.Diff(old, new)
+
+		qt.Assert(t, qt.Equals(changes.String(), tc.want))
+	}
+
+	testCases := []testCase{
+		{
+			name: "same",
+			old:  "a = 1\n[s1]\nb = 2",
+			new:  "a = 1\n\n[s1]\nb = 2",
+			want: "",
+		},
+		{
+			name: "moved sections and keys",
+			old:  "[s1]\na = 1\nb = 2\n[s2]\nc = 3",
+			new:  "[s2]\nc = 3\n[s1]\nb = 2\na = 1",
+			want: "",
+		},
+		{
+			name: "global keys",
+			old:  "a = 1\nb = 2\nc = 3",
+			new:  "c = 4\na = 1\nd = \"x\"",
+			want: `key b removed: 2
+key c changed: 3 -> 4
+key d added: "x"
+`,
+		},
+		{
+			name: "sections",
+			old:  "[s1]\na = 1\n[s2]\nb = 2\n[remote \"origin\"]\nurl = \"x\"",
+			new:  "[s3]\nc = 3\n[s1]\na = 1\n[remote \"upstream\"]\nurl = \"x\"",
+			want: `section [s2] removed
+section [remote "origin"] removed
+section [s3] added
+section [remote "upstream"] added
+`,
+		},
+		{
+			name: "keys in sections",
+			old:  "[s1]\na = 1\nb = 2\n[remote \"origin\"]\nurl = \"x\"",
+			new:  "[s1]\nb = 3\n[remote \"origin\"]\nurl = \"y\"\nfetch = \"+refs\"",
+			want: `key s1/a removed: 1
+key s1/b changed: 2 -> 3
+key remote/origin/url changed: "x" -> "y"
+key remote/origin/fetch added: "+refs"
+`,
+		},
+		{
+			name: "comments",
+			old:  "# a\na = 1\n# s1\n[s1]\n# b\nb = 2",
+			new:  "# a\na = 1\n[s1]\n# new b\nb = 3",
+			want: `comments of section [s1] changed
+key s1/b changed: 2 -> 3
+comments of key s1/b changed
+`,
+		},
+		{
+			name: "duplicates are paired in order",
+			old:  "[s1]\na = 1\na = 2\n[s1]\nb = 1",
+			new:  "[s1]\na = 1\n[s1]\nb = 2\n[s1]\nc = 3",
+			want: `key s1/a removed: 2
+key s1/b changed: 1 -> 2
+section [s1] added
+`,
+		},
+		{
+			name: "value type",
+			old:  "a = 1",
+			new:  `a = "1"`,
+			want: "key a changed: 1 -> \"1\"\n",
+		},
+		{
+			name: "case-sensitive",
+			old:  "[S1]\na = 1",
+			new:  "[s1]\na = 1",
+			want: "section [S1] removed\nsection [s1] added\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) { run(t, tc) })
+	}
+}
+
+func TestDiffCaseInsensitive(t *testing.T) {
+	old := parse(t, "[S1]\nA = 1")
+	new := parse(t, "[s1]\na = 2")
+	old.SetCaseInsensitive(true)
+	new.SetCaseInsensitive(true)
+
+	changes := ast.Diff(old, new)
+
+	qt.Assert(t, qt.Equals(changes.String(), "key s1/a changed: 1 -> 2\n"))
+}
+
+func TestDiffPositions(t *testing.T) {
+	old := parse(t, "a = 1\n[s1]\nb = 2")
+	new := parse(t, "[s1]\n\nb = 3")
+
+	changes := ast.Diff(old, new)
+
+	qt.Assert(t, qt.HasLen(changes, 2))
+	removed, changed := changes[0], changes[1]
+	qt.Assert(t, qt.Equals(removed.Kind, ast.KeyRemoved))
+	qt.Assert(t, qt.Equals(removed.Path, ast.Path("a")))
+	qt.Assert(t, qt.Equals(removed.OldPos.String(), "1:1"))
+	qt.Assert(t, qt.Equals(removed.NewPos, ast.Position{}))
+	qt.Assert(t, qt.Equals(changed.Kind, ast.ValueChanged))
+	qt.Assert(t, qt.Equals(changed.Path, ast.Path("s1/b")))
+	qt.Assert(t, qt.Equals(changed.OldPos.String(), "3:1"))
+	qt.Assert(t, qt.Equals(changed.NewPos.String(), "3:1"))
+	qt.Assert(t, qt.Equals(changed.OldValue, ast.Value(ast.Number{Value: 2})))
+	qt.Assert(t, qt.Equals(changed.NewValue, ast.Value(ast.Number{Value: 3})))
+}
+
+func TestDiffInheritance(t *testing.T) {
+	parser := ast.NewParser(ast.WithInheritance(ast.ParenInheritance))
+	old, err := parser.ParseString("", "[a](!)\n[b](a)\n[c](a)\n[d](!, a)")
+	qt.Assert(t, qt.IsNil(err))
+	new, err := parser.ParseString("", "[a]\n[b](a)\n[c](b,a)\n[d](!,a)")
+	qt.Assert(t, qt.IsNil(err))
+
+	changes := ast.Diff(old, new)
+
+	qt.Assert(t, qt.Equals(changes.String(), `header of section [a] changed: [a](!) -> [a]
+header of section [c] changed: [c](a) -> [c](b,a)
+`))
+	data, err := json.Marshal(changes[0])
+	qt.Assert(t, qt.IsNil(err))
+	want := `{"kind":"header changed","path":"a/",
+ "old":{"pos":{"offset":0,"line":1,"column":1},"header":"[a](!)"},
+ "new":{"pos":{"offset":0,"line":1,"column":1},"header":"[a]"}}`
+	qt.Assert(t, qt.JSONEquals(data, json.RawMessage(want)))
+}
+
+func TestDiffJSON(t *testing.T) {
+	parser := ast.NewParser(ast.WithStrict(false))
+	old, err := parser.ParseString("old.ini", "# a\na = 1\n[s1]\nb = \"x\"")
+	qt.Assert(t, qt.IsNil(err))
+	new, err := parser.ParseString("new.ini", "a = 2\n[s2]")
+	qt.Assert(t, qt.IsNil(err))
+
+	data, err := json.Marshal(ast.Diff(old, new))
+	qt.Assert(t, qt.IsNil(err))
+
+	want := `[
+{"kind":"value changed","path":"a",
+ "old":{"pos":{"filename":"old.ini","offset":4,"line":2,"column":1},"type":"number","value":1,"comments":["# a"]},
+ "new":{"pos":{"filename":"new.ini","offset":0,"line":1,"column":1},"type":"number","value":2}},
+{"kind":"comment changed","path":"a",
+ "old":{"pos":{"filename":"old.ini","offset":4,"line":2,"column":1},"type":"number","value":1,"comments":["# a"]},
+ "new":{"pos":{"filename":"new.ini","offset":0,"line":1,"column":1},"type":"number","value":2}},
+{"kind":"section removed","path":"s1/",
+ "old":{"pos":{"filename":"old.ini","offset":10,"line":3,"column":1}}},
+{"kind":"section added","path":"s2/",
+ "new":{"pos":{"filename":"new.ini","offset":6,"line":2,"column":1}}}
+]`
+	qt.Assert(t, qt.JSONEquals(data, json.RawMessage(want)))
+}
@@ -0,0 +1,300 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned when the key or section to edit doesn't exist.
+	ErrNotFound = errors.New("not found")
+	// ErrExists is returned when an edit would create a duplicate key or
+	// section.
+	ErrExists = errors.New("already exists")
+	// ErrInvalidName is returned when an edit would create a key or section
+	// whose name the parser cannot read back. In a tree parsed with
+	// WithStrict(false), a name must not be empty, start or end with blanks,
+	// or contain the separators or comment markers of the parser, brackets,
+	// quotes or newlines. In the other trees, a name must be an identifier,
+	// as the default strict parser requires.
+	ErrInvalidName = errors.New("invalid name")
+)
+
+// InsertBefore inserts the key pair key = value just before keyPath, in the
+// same section. The comments of keyPath stay attached to keyPath.
+//
+// InsertBefore returns an error wrapping [ErrNotFound] if keyPath doesn't
+// exist, wrapping [ErrExists] if key already exists in the section, or
+// wrapping [ErrInvalidName] if key is not a valid name.
+func (tree *AST) InsertBefore(keyPath Path, key string, value Value) (*Property, error) {
+	if err := tree.checkName("key", key); err != nil {
+		return nil, err
+	}
+	sec, i, err := tree.locate(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return tree.insertProperty(sec, i, key, value, false)
+}
+
+// InsertAfter inserts the key pair key = value just after keyPath, in the
+// same section. The blank lines below keyPath move below the new property,
+// so that the new property stays in the same group of lines as keyPath.
+//
+// InsertAfter returns an error wrapping [ErrNotFound] if keyPath doesn't
+// exist, wrapping [ErrExists] if key already exists in the section, or
+// wrapping [ErrInvalidName] if key is not a valid name.
+func (tree *AST) InsertAfter(keyPath Path, key string, value Value) (*Property, error) {
+	if err := tree.checkName("key", key); err != nil {
+		return nil, err
+	}
+	sec, i, err := tree.locate(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return tree.insertProperty(sec, i+1, key, value, true)
+}
+
+// InsertAt inserts the key pair key = value in section secName ("" for the
+// global section), so that it becomes the property at position index.
+// Index 0 inserts before the first property and index equal to the number of
+// properties appends after the last one. Blank lines are handled as in
+// [AST.InsertAfter].
+//
+// InsertAt returns an error wrapping [ErrNotFound] if secName doesn't exist,
+// wrapping [ErrExists] if key already exists in the section, or wrapping
+// [ErrInvalidName] if key is not a valid name.
+func (tree *AST) InsertAt(secName string, index int, key string, value Value) (*Property, error) {
+	if err := tree.checkName("key", key); err != nil {
+		return nil, err
+	}
+	sec, ok := tree.findSection(splitSectionName(secName))
+	if !ok {
+		return nil, fmt.Errorf("ast: section %q: %w", secName, ErrNotFound)
+	}
+	if n := len(*tree.properties(sec)); index < 0 || index > n {
+		return nil, fmt.Errorf("ast: index %d out of range [0, %d]", index, n)
+	}
+	return tree.insertProperty(sec, index, key, value, true)
+}
+
+// InsertSectionBefore inserts an empty section newName just before section
+// secName. The comments of secName stay attached to secName.
+//
+// InsertSectionBefore returns an error wrapping [ErrNotFound] if secName
+// doesn't exist, wrapping [ErrExists] if newName already exists, or wrapping
+// [ErrInvalidName] if the section part of newName is not a valid name.
+func (tree *AST) InsertSectionBefore(secName, newName string) (*Section, error) {
+	return tree.insertSection(secName, newName, 0)
+}
+
+// InsertSectionAfter inserts an empty section newName just after section
+// secName and its properties.
+//
+// InsertSectionAfter returns an error wrapping [ErrNotFound] if secName
+// doesn't exist, wrapping [ErrExists] if newName already exists, or wrapping
+// [ErrInvalidName] if the section part of newName is not a valid name.
+func (tree *AST) InsertSectionAfter(secName, newName string) (*Section, error) {
+	return tree.insertSection(secName, newName, 1)
+}
+
+// locate returns the section (nil for the global section) and the position
+// of keyPath.
+func (tree *AST) locate(keyPath Path) (*Section, int, error) {
+	section, subsection, key := keyPath.split()
+	sec, ok := tree.findSection(section, subsection)
+	if ok {
+		props := *tree.properties(sec)
+		if prop, ok := find(tree.propsIndex(sec), props, key, tree.foldCase); ok {
+			return sec, position(props, prop), nil
+		}
+	}
+	return nil, 0, fmt.Errorf("ast: key %q: %w", keyPath, ErrNotFound)
+}
+
+// insertProperty inserts a new property at position i of the properties of
+// sec (nil for the global section). If takeBlanks is true, the new property
+// takes over the blank lines of the previous property.
+func (tree *AST) insertProperty(sec *Section, i int, key string, value Value,
+	takeBlanks bool,
+) (*Property, error) {
+	ix := tree.propsIndex(sec)
+	props := tree.properties(sec)
+	if _, ok := find(ix, *props, key, tree.foldCase); ok {
+		return nil, fmt.Errorf("ast: key %q: %w", key, ErrExists)
+	}
+
+	prop := &Property{Key: key, Value: value}
+	if takeBlanks && i > 0 {
+		// Stay in the same group of lines of the previous property.
+		prev := (*props)[i-1]
+		prop.BlankLines, prev.BlankLines = prev.BlankLines, nil
+	}
+	insert(ix, props, i, prop)
+	return prop, nil
+}
+
+// insertSection inserts a new section newName at the position of secName plus
+// offset.
+func (tree *AST) insertSection(secName, newName string, offset int) (*Section, error) {
+	name, subsection := splitSectionName(newName)
+	if err := tree.checkName("section", name); err != nil {
+		return nil, err
+	}
+	ix := tree.sectionsIndex()
+	anchor, ok := find(ix, tree.Sections, sectionKey(secName), tree.foldCase)
+	if !ok {
+		return nil, fmt.Errorf("ast: section %q: %w", secName, ErrNotFound)
+	}
+	if _, ok := find(ix, tree.Sections, SectionName(name, subsection), tree.foldCase); ok {
+		return nil, fmt.Errorf("ast: section %q: %w", newName, ErrExists)
+	}
+
+	sec := &Section{Name: name, Subsection: subsection}
+	insert(ix, &tree.Sections, position(tree.Sections, anchor)+offset, sec)
+	return sec, nil
+}
+
+// checkName returns an error wrapping [ErrInvalidName] if name, the name of a
+// new key or section (what), cannot be parsed back.
+func (tree *AST) checkName(what, name string) error {
+	valid := name != ""
+	if tree.lenient {
+		valid = valid && strings.TrimSpace(name) == name &&
+			!strings.ContainsAny(name, tree.reserved+"[]\"\r\n")
+	} else {
+		for i := 0; i < len(name) && valid; i++ {
+			c := name[i]
+			valid = isLetter(c) || i > 0 && (isDigit(c) || c == '_')
+		}
+	}
+	if !valid {
+		return fmt.Errorf("ast: %s %q: %w", what, name, ErrInvalidName)
+	}
+	return nil
+}
+
+// RenameKey renames the key of property oldPath to newKey, in place. The
+// property keeps its value, comments, blank lines and position.
+//
+// RenameKey returns an error wrapping [ErrNotFound] if oldPath doesn't exist,
+// wrapping [ErrExists] if newKey already exists in the section, or wrapping
+// [ErrInvalidName] if newKey is not a valid name.
+func (tree *AST) RenameKey(oldPath Path, newKey string) error {
+	if err := tree.checkName("key", newKey); err != nil {
+		return err
+	}
+	sec, i, err := tree.locate(oldPath)
+	if err != nil {
+		return err
+	}
+	ix := tree.propsIndex(sec)
+	props := *tree.properties(sec)
+	prop := props[i]
+	if other, ok := find(ix, props, newKey, tree.foldCase); ok && other != prop {
+		return fmt.Errorf("ast: key %q: %w", newKey, ErrExists)
+	}
+
+	oldKey := prop.Key
+	prop.Key = newKey
+	if ix != nil {
+		ix.renamed(props, prop, oldKey)
+	}
+	return nil
+}
+
+// RenameSection renames section oldName to newName, in place. The section
+// keeps its properties, comments, blank lines and position. The sections that
+// inherit from oldName (see [WithInheritance]) inherit from newName instead,
+// unless newName has a subsection, which a parent cannot have: then they keep
+// the old parent, which no longer exists.
+//
+// RenameSection returns an error wrapping [ErrNotFound] if oldName doesn't
+// exist, wrapping [ErrExists] if newName already exists, or wrapping
+// [ErrInvalidName] if the section part of newName is not a valid name.
+func (tree *AST) RenameSection(oldName, newName string) error {
+	name, subsection := splitSectionName(newName)
+	if err := tree.checkName("section", name); err != nil {
+		return err
+	}
+	ix := tree.sectionsIndex()
+	sec, ok := find(ix, tree.Sections, sectionKey(oldName), tree.foldCase)
+	if !ok {
+		return fmt.Errorf("ast: section %q: %w", oldName, ErrNotFound)
+	}
+	other, ok := find(ix, tree.Sections, SectionName(name, subsection), tree.foldCase)
+	if ok && other != sec {
+		return fmt.Errorf("ast: section %q: %w", newName, ErrExists)
+	}
+
+	if subsection == "" {
+		tree.renameParent(sec, name)
+	}
+	old := sec.name()
+	sec.Name, sec.Subsection = name, subsection
+	if ix != nil {
+		ix.renamed(tree.Sections, sec, old)
+	}
+	return nil
+}
+
+// MoveProperty moves property keyPath to section toSection ("" for the global
+// section), so that it becomes the property at position index. The property
+// takes its comments and blank lines with it. Index 0 moves the property
+// before the first property and index equal to the number of the other
+// properties of toSection moves it after the last one.
+//
+// MoveProperty returns an error wrapping [ErrNotFound] if keyPath or toSection
+// don't exist, or wrapping [ErrExists] if toSection is another section
+// already containing the key.
+func (tree *AST) MoveProperty(keyPath Path, toSection string, index int) error {
+	from, i, err := tree.locate(keyPath)
+	if err != nil {
+		return err
+	}
+	to, ok := tree.findSection(splitSectionName(toSection))
+	if !ok {
+		return fmt.Errorf("ast: section %q: %w", toSection, ErrNotFound)
+	}
+	fromProps, toProps := tree.properties(from), tree.properties(to)
+	prop := (*fromProps)[i]
+	n := len(*toProps)
+	if to != from {
+		if _, ok := find(tree.propsIndex(to), *toProps, prop.Key, tree.foldCase); ok {
+			return fmt.Errorf("ast: key %q: %w", prop.Key, ErrExists)
+		}
+	} else {
+		n--
+	}
+	if index < 0 || index > n {
+		return fmt.Errorf("ast: index %d out of range [0, %d]", index, n)
+	}
+
+	removeAt(tree.propsIndex(from), fromProps, i)
+	insert(tree.propsIndex(to), toProps, index, prop)
+	return nil
+}
+
+// MoveSection moves section secName, with its properties, comments and blank
+// lines, so that it becomes the section at position index.
+//
+// MoveSection returns an error wrapping [ErrNotFound] if secName doesn't
+// exist.
+func (tree *AST) MoveSection(secName string, index int) error {
+	ix := tree.sectionsIndex()
+	sec, ok := find(ix, tree.Sections, sectionKey(secName), tree.foldCase)
+	if !ok {
+		return fmt.Errorf("ast: section %q: %w", secName, ErrNotFound)
+	}
+	if n := len(tree.Sections) - 1; index < 0 || index > n {
+		return fmt.Errorf("ast: index %d out of range [0, %d]", index, n)
+	}
+
+	removeAt(ix, &tree.Sections, position(tree.Sections, sec))
+	insert(ix, &tree.Sections, index, sec)
+	return nil
+}
@@ -0,0 +1,773 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+
+	"github.com/marco-m/roundtrip_ini/ast"
+)
+
+func TestInsertProperty(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		want   string
+		insert func(tree *ast.AST) (*ast.Property, error)
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		tree := parse(t, tc.input)
+		tc.want = normalizeEnds(tc.want)
+
+		prop, err := tc.insert(tree)
+
+		qt.Assert(t, qt.IsNil(err))
+		qt.Assert(t, qt.Equals(tree.String(), tc.want))
+		qt.Assert(t, qt.Equals(tree.Lookup(pathOf(tree, prop)), prop))
+	}
+
+	testCases := []testCase{
+		{
+			name: "before, global section",
+			input: `
+a = 1
+# comment for b
+b = 2`,
+			want: `
+a = 1
+x = 9
+# comment for b
+b = 2`,
+			insert: func(tree *ast.AST) (*ast.Property, error) {
+				return tree.InsertBefore("b", "x", ast.Number{Value: 9})
+			},
+		},
+		{
+			name: "before, does not take over the blank lines of the previous property",
+			input: `
+[s1]
+a = 1
+
+b = 2`,
+			want: `
+[s1]
+a = 1
+
+x = 9
+b = 2`,
+			insert: func(tree *ast.AST) (*ast.Property, error) {
+				return tree.InsertBefore("s1/b", "x", ast.Number{Value: 9})
+			},
+		},
+		{
+			name: "after, takes over the blank lines",
+			input: `
+[s1]
+# comment for a
+a = 1
+
+# comment for b
+b = 2`,
+			want: `
+[s1]
+# comment for a
+a = 1
+x = 9
+
+# comment for b
+b = 2`,
+			insert: func(tree *ast.AST) (*ast.Property, error) {
+				return tree.InsertAfter("s1/a", "x", ast.Number{Value: 9})
+			},
+		},
+		{
+			name: "after last property",
+			input: `
+a = 1
+[s1]`,
+			want: `
+a = 1
+x = 9
+[s1]`,
+			insert: func(tree *ast.AST) (*ast.Property, error) {
+				return tree.InsertAfter("a", "x", ast.Number{Value: 9})
+			},
+		},
+		{
+			name: "at beginning of section",
+			input: `
+[s1]
+
+a = 1`,
+			want: `
+[s1]
+
+x = 9
+a = 1`,
+			insert: func(tree *ast.AST) (*ast.Property, error) {
+				return tree.InsertAt("s1", 0, "x", ast.Number{Value: 9})
+			},
+		},
+		{
+			name: "at middle of section",
+			input: `
+[s1]
+a = 1
+
+b = 2`,
+			want: `
+[s1]
+a = 1
+x = 9
+
+b = 2`,
+			insert: func(tree *ast.AST) (*ast.Property, error) {
+				return tree.InsertAt("s1", 1, "x", ast.Number{Value: 9})
+			},
+		},
+		{
+			name: "at end of global section",
+			input: `
+a = 1
+[s1]`,
+			want: `
+a = 1
+x = 9
+[s1]`,
+			insert: func(tree *ast.AST) (*ast.Property, error) {
+				return tree.InsertAt("", 1, "x", ast.Number{Value: 9})
+			},
+		},
+		{
+			name:  "at empty section",
+			input: `[s1]`,
+			want: `
+[s1]
+x = 9`,
+			insert: func(tree *ast.AST) (*ast.Property, error) {
+				return tree.InsertAt("s1", 0, "x", ast.Number{Value: 9})
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) { run(t, tc) })
+	}
+}
+
+func TestInsertSection(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		want   string
+		insert func(tree *ast.AST) (*ast.Section, error)
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		tree := parse(t, tc.input)
+		tc.want = normalizeEnds(tc.want)
+
+		sec, err := tc.insert(tree)
+
+		qt.Assert(t, qt.IsNil(err))
+		qt.Assert(t, qt.Equals(tree.String(), tc.want))
+		qt.Assert(t, qt.Equals(tree.LookupSection(sec.Name), sec))
+	}
+
+	testCases := []testCase{
+		{
+			name: "before, comments stay with the anchor",
+			input: `
+a = 1
+[s1]
+b = 2
+# comment for s2
+[s2]
+c = 3`,
+			want: `
+a = 1
+[s1]
+b = 2
+[new]
+# comment for s2
+[s2]
+c = 3`,
+			insert: func(tree *ast.AST) (*ast.Section, error) {
+				return tree.InsertSectionBefore("s2", "new")
+			},
+		},
+		{
+			name: "after, goes after the properties",
+			input: `
+[s1]
+b = 2
+[s2]
+c = 3`,
+			want: `
+[s1]
+b = 2
+[new]
+[s2]
+c = 3`,
+			insert: func(tree *ast.AST) (*ast.Section, error) {
+				return tree.InsertSectionAfter("s1", "new")
+			},
+		},
+		{
+			name: "after last section",
+			input: `
+[s1]
+b = 2`,
+			want: `
+[s1]
+b = 2
+[new]`,
+			insert: func(tree *ast.AST) (*ast.Section, error) {
+				return tree.InsertSectionAfter("s1", "new")
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) { run(t, tc) })
+	}
+}
+
+func TestInsertErrors(t *testing.T) {
+	input := `
+a = 1
+[s1]
+b = 2`
+
+	testCases := []struct {
+		name    string
+		insert  func(tree *ast.AST) error
+		wantErr error
+	}{
+		{
+			name: "before non-existing key",
+			insert: func(tree *ast.AST) error {
+				_, err := tree.InsertBefore("s1/x", "y", ast.Number{Value: 1})
+				return err
+			},
+			wantErr: ast.ErrNotFound,
+		},
+		{
+			name: "after, key exists",
+			insert: func(tree *ast.AST) error {
+				_, err := tree.InsertAfter("a", "a", ast.Number{Value: 1})
+				return err
+			},
+			wantErr: ast.ErrExists,
+		},
+		{
+			name: "at non-existing section",
+			insert: func(tree *ast.AST) error {
+				_, err := tree.InsertAt("s2", 0, "y", ast.Number{Value: 1})
+				return err
+			},
+			wantErr: ast.ErrNotFound,
+		},
+		{
+			name: "section before non-existing section",
+			insert: func(tree *ast.AST) error {
+				_, err := tree.InsertSectionBefore("s2", "s3")
+				return err
+			},
+			wantErr: ast.ErrNotFound,
+		},
+		{
+			name: "section after, section exists",
+			insert: func(tree *ast.AST) error {
+				_, err := tree.InsertSectionAfter("s1", "s1")
+				return err
+			},
+			wantErr: ast.ErrExists,
+		},
+		{
+			name: "before, empty key",
+			insert: func(tree *ast.AST) error {
+				_, err := tree.InsertBefore("a", "", ast.Number{Value: 1})
+				return err
+			},
+			wantErr: ast.ErrInvalidName,
+		},
+		{
+			name: "after, key with blank",
+			insert: func(tree *ast.AST) error {
+				_, err := tree.InsertAfter("a", "x y", ast.Number{Value: 1})
+				return err
+			},
+			wantErr: ast.ErrInvalidName,
+		},
+		{
+			name: "at, key with separator",
+			insert: func(tree *ast.AST) error {
+				_, err := tree.InsertAt("s1", 0, "x=y", ast.Number{Value: 1})
+				return err
+			},
+			wantErr: ast.ErrInvalidName,
+		},
+		{
+			name: "section with bracket",
+			insert: func(tree *ast.AST) error {
+				_, err := tree.InsertSectionBefore("s1", "s]")
+				return err
+			},
+			wantErr: ast.ErrInvalidName,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tree := parse(t, input)
+			before := tree.String()
+
+			err := tc.insert(tree)
+
+			qt.Assert(t, qt.ErrorIs(err, tc.wantErr))
+			qt.Assert(t, qt.Equals(tree.String(), before))
+		})
+	}
+}
+
+func TestInsertNonStrictNames(t *testing.T) {
+	parser := ast.NewParser(ast.WithStrict(false), ast.WithSeparators("=:"))
+	tree, err := parser.ParseString("", "[global]\nworkgroup = \"HOME\"")
+	qt.Assert(t, qt.IsNil(err))
+
+	_, err = tree.InsertAfter("global/workgroup", "read only", ast.Number{Value: 1})
+	qt.Assert(t, qt.IsNil(err))
+	_, err = tree.InsertSectionAfter("global", "my share")
+	qt.Assert(t, qt.IsNil(err))
+	reparsed, err := parser.ParseString("", tree.String())
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.IsTrue(ast.Equal(reparsed, tree)))
+
+	for _, key := range []string{"", " x", "x:y", "x;y", "[x", "x\ny"} {
+		_, err = tree.InsertAt("global", 0, key, ast.Number{Value: 1})
+		qt.Assert(t, qt.ErrorIs(err, ast.ErrInvalidName), qt.Commentf("key %q", key))
+	}
+}
+
+func TestInsertAtOutOfRange(t *testing.T) {
+	tree := parse(t, `a = 1`)
+
+	_, err := tree.InsertAt("", 2, "x", ast.Number{Value: 1})
+
+	qt.Assert(t, qt.ErrorMatches(err, `ast: index 2 out of range \[0, 1\]`))
+}
+
+func TestInsertKeepsIndexConsistent(t *testing.T) {
+	tree := parse(t, `
+[s1]
+a = 1
+b = 2
+[s2]`)
+	tree.EnableIndex()
+	qt.Assert(t, qt.IsNotNil(tree.Lookup("s1/a")))
+
+	_, err := tree.InsertBefore("s1/b", "x", ast.Number{Value: 9})
+	qt.Assert(t, qt.IsNil(err))
+	_, err = tree.InsertSectionBefore("s2", "s3")
+	qt.Assert(t, qt.IsNil(err))
+
+	qt.Assert(t, qt.IsNotNil(tree.Lookup("s1/x")))
+	qt.Assert(t, qt.IsNotNil(tree.Lookup("s1/b")))
+	qt.Assert(t, qt.IsNotNil(tree.LookupSection("s3")))
+	qt.Assert(t, qt.IsNotNil(tree.LookupSection("s2")))
+}
+
+func TestRename(t *testing.T) {
+	input := `
+# comment for a
+a = 1
+
+b = 2
+# comment for s1
+[s1]
+
+c = 3
+d = 4
+[s2]`
+
+	testCases := []struct {
+		name   string
+		rename func(tree *ast.AST) error
+		want   string
+	}{
+		{
+			name:   "key in global section",
+			rename: func(tree *ast.AST) error { return tree.RenameKey("a", "x") },
+			want: `
+# comment for a
+x = 1
+
+b = 2
+# comment for s1
+[s1]
+
+c = 3
+d = 4
+[s2]`,
+		},
+		{
+			name:   "key in section",
+			rename: func(tree *ast.AST) error { return tree.RenameKey("s1/c", "x") },
+			want: `
+# comment for a
+a = 1
+
+b = 2
+# comment for s1
+[s1]
+
+x = 3
+d = 4
+[s2]`,
+		},
+		{
+			name:   "section",
+			rename: func(tree *ast.AST) error { return tree.RenameSection("s1", "x") },
+			want: `
+# comment for a
+a = 1
+
+b = 2
+# comment for s1
+[x]
+
+c = 3
+d = 4
+[s2]`,
+		},
+		{
+			name:   "to the same name",
+			rename: func(tree *ast.AST) error { return tree.RenameKey("s1/d", "d") },
+			want:   input,
+		},
+	}
+
+	for _, tc := range testCases {
+		for _, indexed := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s, indexed %v", tc.name, indexed), func(t *testing.T) {
+				tree := parse(t, input)
+				if indexed {
+					tree.EnableIndex()
+					qt.Assert(t, qt.IsNotNil(tree.Lookup("s1/c")))
+				}
+
+				err := tc.rename(tree)
+
+				qt.Assert(t, qt.IsNil(err))
+				qt.Assert(t, qt.Equals(tree.String(), normalizeEnds(tc.want)))
+			})
+		}
+	}
+}
+
+func TestRenameKeepsIndexConsistent(t *testing.T) {
+	tree := parse(t, `
+[s1]
+a = 1
+[s2]`)
+	tree.EnableIndex()
+	qt.Assert(t, qt.IsNotNil(tree.Lookup("s1/a")))
+
+	qt.Assert(t, qt.IsNil(tree.RenameKey("s1/a", "b")))
+	qt.Assert(t, qt.IsNil(tree.RenameSection("s1", "s3")))
+
+	qt.Assert(t, qt.IsNil(tree.Lookup("s3/a")))
+	qt.Assert(t, qt.IsNil(tree.LookupSection("s1")))
+	qt.Assert(t, qt.Equals(tree.Lookup("s3/b").Value, ast.Value(ast.Number{Value: 1})))
+	qt.Assert(t, qt.IsNotNil(tree.LookupSection("s2")))
+}
+
+func TestRenameErrors(t *testing.T) {
+	input := `
+a = 1
+b = 2
+[s1]
+[s2]`
+
+	testCases := []struct {
+		name    string
+		rename  func(tree *ast.AST) error
+		wantErr error
+	}{
+		{
+			name:    "non-existing key",
+			rename:  func(tree *ast.AST) error { return tree.RenameKey("s1/a", "x") },
+			wantErr: ast.ErrNotFound,
+		},
+		{
+			name:    "key exists",
+			rename:  func(tree *ast.AST) error { return tree.RenameKey("a", "b") },
+			wantErr: ast.ErrExists,
+		},
+		{
+			name:    "non-existing section",
+			rename:  func(tree *ast.AST) error { return tree.RenameSection("s3", "x") },
+			wantErr: ast.ErrNotFound,
+		},
+		{
+			name:    "section exists",
+			rename:  func(tree *ast.AST) error { return tree.RenameSection("s1", "s2") },
+			wantErr: ast.ErrExists,
+		},
+		{
+			name:    "empty key",
+			rename:  func(tree *ast.AST) error { return tree.RenameKey("a", "") },
+			wantErr: ast.ErrInvalidName,
+		},
+		{
+			name:    "key with comment marker",
+			rename:  func(tree *ast.AST) error { return tree.RenameKey("a", "x#y") },
+			wantErr: ast.ErrInvalidName,
+		},
+		{
+			name:    "key with newline",
+			rename:  func(tree *ast.AST) error { return tree.RenameKey("a", "x\ny") },
+			wantErr: ast.ErrInvalidName,
+		},
+		{
+			name:    "empty section",
+			rename:  func(tree *ast.AST) error { return tree.RenameSection("s1", "") },
+			wantErr: ast.ErrInvalidName,
+		},
+		{
+			name:    "section with bracket",
+			rename:  func(tree *ast.AST) error { return tree.RenameSection("s1", "[x]") },
+			wantErr: ast.ErrInvalidName,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tree := parse(t, input)
+			before := tree.String()
+
+			err := tc.rename(tree)
+
+			qt.Assert(t, qt.ErrorIs(err, tc.wantErr))
+			qt.Assert(t, qt.Equals(tree.String(), before))
+		})
+	}
+}
+
+func TestMove(t *testing.T) {
+	input := `
+# comment for a
+a = 1
+
+b = 2
+[s1]
+c = 3
+# comment for d
+d = 4
+[s2]
+e = 5`
+
+	testCases := []struct {
+		name string
+		move func(tree *ast.AST) error
+		want string
+	}{
+		{
+			name: "property from global section to section",
+			move: func(tree *ast.AST) error { return tree.MoveProperty("a", "s2", 0) },
+			want: `
+b = 2
+[s1]
+c = 3
+# comment for d
+d = 4
+[s2]
+# comment for a
+a = 1
+
+e = 5`,
+		},
+		{
+			name: "property from section to global section",
+			move: func(tree *ast.AST) error { return tree.MoveProperty("s1/d", "", 2) },
+			want: `
+# comment for a
+a = 1
+
+b = 2
+# comment for d
+d = 4
+[s1]
+c = 3
+[s2]
+e = 5`,
+		},
+		{
+			name: "property within the same section",
+			move: func(tree *ast.AST) error { return tree.MoveProperty("s1/c", "s1", 1) },
+			want: `
+# comment for a
+a = 1
+
+b = 2
+[s1]
+# comment for d
+d = 4
+c = 3
+[s2]
+e = 5`,
+		},
+		{
+			name: "property to the same place",
+			move: func(tree *ast.AST) error { return tree.MoveProperty("s1/d", "s1", 1) },
+			want: input,
+		},
+		{
+			name: "section to the beginning",
+			move: func(tree *ast.AST) error { return tree.MoveSection("s2", 0) },
+			want: `
+# comment for a
+a = 1
+
+b = 2
+[s2]
+e = 5
+[s1]
+c = 3
+# comment for d
+d = 4
+`,
+		},
+		{
+			name: "section to the end",
+			move: func(tree *ast.AST) error { return tree.MoveSection("s1", 1) },
+			want: `
+# comment for a
+a = 1
+
+b = 2
+[s2]
+e = 5
+[s1]
+c = 3
+# comment for d
+d = 4
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		for _, indexed := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s, indexed %v", tc.name, indexed), func(t *testing.T) {
+				tree := parse(t, input)
+				if indexed {
+					tree.EnableIndex()
+					qt.Assert(t, qt.IsNotNil(tree.Lookup("s1/c")))
+					qt.Assert(t, qt.IsNotNil(tree.Lookup("a")))
+				}
+
+				err := tc.move(tree)
+
+				qt.Assert(t, qt.IsNil(err))
+				qt.Assert(t, qt.Equals(tree.String(), normalizeEnds(tc.want)))
+				checkLookups(t, tree)
+			})
+		}
+	}
+}
+
+func TestMoveErrors(t *testing.T) {
+	input := `
+a = 1
+[s1]
+a = 2
+b = 3`
+
+	testCases := []struct {
+		name    string
+		move    func(tree *ast.AST) error
+		wantErr string
+	}{
+		{
+			name:    "non-existing key",
+			move:    func(tree *ast.AST) error { return tree.MoveProperty("x", "s1", 0) },
+			wantErr: `ast: key "x": not found`,
+		},
+		{
+			name:    "non-existing destination section",
+			move:    func(tree *ast.AST) error { return tree.MoveProperty("a", "s2", 0) },
+			wantErr: `ast: section "s2": not found`,
+		},
+		{
+			name:    "key exists in destination section",
+			move:    func(tree *ast.AST) error { return tree.MoveProperty("a", "s1", 0) },
+			wantErr: `ast: key "a": already exists`,
+		},
+		{
+			name:    "property index out of range",
+			move:    func(tree *ast.AST) error { return tree.MoveProperty("s1/b", "s1", 2) },
+			wantErr: `ast: index 2 out of range [0, 1]`,
+		},
+		{
+			name:    "non-existing section",
+			move:    func(tree *ast.AST) error { return tree.MoveSection("s2", 0) },
+			wantErr: `ast: section "s2": not found`,
+		},
+		{
+			name:    "section index out of range",
+			move:    func(tree *ast.AST) error { return tree.MoveSection("s1", 1) },
+			wantErr: `ast: index 1 out of range [0, 0]`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tree := parse(t, input)
+			before := tree.String()
+
+			err := tc.move(tree)
+
+			qt.Assert(t, qt.IsNotNil(err))
+			qt.Assert(t, qt.Equals(err.Error(), tc.wantErr))
+			qt.Assert(t, qt.Equals(tree.String(), before))
+		})
+	}
+}
+
+// pathOf returns the path of prop in tree, or "" if not found.
+func pathOf(tree *ast.AST, prop *ast.Property) ast.Path {
+	for _, p := range tree.Properties {
+		if p == prop {
+			return ast.NewPath("", p.Key)
+		}
+	}
+	for _, sec := range tree.Sections {
+		for _, p := range sec.Properties {
+			if p == prop {
+				return ast.NewSubsectionPath(sec.Name, sec.Subsection, p.Key)
+			}
+		}
+	}
+	return ""
+}
+
+// checkLookups checks that every property of tree can be looked up.
+func checkLookups(t *testing.T, tree *ast.AST) {
+	t.Helper()
+	for _, prop := range tree.Properties {
+		qt.Assert(t, qt.Equals(tree.Lookup(ast.NewPath("", prop.Key)), prop))
+	}
+	for _, sec := range tree.Sections {
+		qt.Assert(t, qt.Equals(tree.LookupSection(ast.SectionName(sec.Name, sec.Subsection)), sec))
+		for _, prop := range sec.Properties {
+			keyPath := ast.NewSubsectionPath(sec.Name, sec.Subsection, prop.Key)
+			qt.Assert(t, qt.Equals(tree.Lookup(keyPath), prop))
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast
+
+import (
+	"io"
+	"strconv"
+)
+
+// Encoder writes the INI format to an [io.Writer].
+//
+// Encoder buffers its output internally and writes it to the underlying
+// writer in large chunks, so there is no need to wrap w in a [bufio.Writer].
+type Encoder struct {
+	w         io.Writer
+	eol       string
+	separator string
+	buf       []byte
+	n         int64 // bytes written to w
+	err       error // sticky write error
+}
+
+// EncoderOption configures an [Encoder].
+type EncoderOption func(*Encoder)
+
+// WithLineEnding sets the line ending. The default is "\n"; use "\r\n" for
+// files meant to be read on Windows.
+func WithLineEnding(eol string) EncoderOption {
+	return func(enc *Encoder) {
+		enc.eol = eol
+	}
+}
+
+// WithKeyValueSeparator sets the text written between a key and its value.
+// The default is " = ".
+func WithKeyValueSeparator(sep string) EncoderOption {
+	return func(enc *Encoder) {
+		enc.separator = sep
+	}
+}
+
+const encoderBufSize = 4096
+
+// NewEncoder returns an encoder that writes to w.
+func NewEncoder(w io.Writer, opts ...EncoderOption) *Encoder {
+	enc := &Encoder{
+		w:         w,
+		eol:       "\n",
+		separator: " = ",
+	}
+	for _, opt := range opts {
+		opt(enc)
+	}
+	return enc
+}
+
+// Encode writes tree to the underlying writer. It returns the first error
+// returned by the writer; once the writer has failed, Encode writes nothing
+// and returns that error.
+func (enc *Encoder) Encode(tree *AST) error {
+	if enc.err != nil {
+		return enc.err
+	}
+	enc.tree(tree)
+	return enc.flush()
+}
+
+// WriteTo writes the AST in the INI format to w. It implements [io.WriterTo].
+func (tree *AST) WriteTo(w io.Writer) (int64, error) {
+	enc := NewEncoder(w)
+	err := enc.Encode(tree)
+	return enc.n, err
+}
+
+// WriteTo writes the Section, including its properties, in the INI format to
+// w. It implements [io.WriterTo].
+func (sec *Section) WriteTo(w io.Writer) (int64, error) {
+	enc := NewEncoder(w)
+	enc.section(sec)
+	err := enc.flush()
+	return enc.n, err
+}
+
+// WriteTo writes the Property in the INI format to w. It implements
+// [io.WriterTo].
+func (prop *Property) WriteTo(w io.Writer) (int64, error) {
+	enc := NewEncoder(w)
+	enc.property(prop)
+	err := enc.flush()
+	return enc.n, err
+}
+
+func (enc *Encoder) tree(tree *AST) {
+	for _, prop := range tree.Properties {
+		enc.property(prop)
+	}
+	for _, sec := range tree.Sections {
+		enc.section(sec)
+	}
+	enc.comments(tree.Comments)
+}
+
+func (enc *Encoder) section(sec *Section) {
+	enc.comments(sec.Comments)
+
+	enc.buf = appendSectionHeader(enc.buf, sec.Name, sec.Subsection)
+	enc.buf = appendInheritance(enc.buf, sec)
+	enc.buf = append(enc.buf, enc.eol...)
+
+	enc.blankLines(sec.BlankLines)
+
+	for _, prop := range sec.Properties {
+		enc.property(prop)
+	}
+}
+
+func (enc *Encoder) property(prop *Property) {
+	enc.comments(prop.Comments)
+
+	enc.buf = append(enc.buf, prop.Key...)
+	enc.buf = append(enc.buf, enc.separator...)
+	enc.buf = appendValue(enc.buf, prop.Value)
+	enc.buf = append(enc.buf, enc.eol...)
+
+	enc.blankLines(prop.BlankLines)
+}
+
+func (enc *Encoder) comments(comments []string) {
+	for _, cmt := range comments {
+		enc.buf = append(enc.buf, cmt...)
+		enc.buf = append(enc.buf, enc.eol...)
+	}
+	enc.maybeFlush()
+}
+
+func (enc *Encoder) blankLines(blanks []string) {
+	for range blanks {
+		enc.buf = append(enc.buf, enc.eol...)
+	}
+	enc.maybeFlush()
+}
+
+// maybeFlush flushes the buffer if it is full enough.
+func (enc *Encoder) maybeFlush() {
+	if len(enc.buf) >= encoderBufSize {
+		enc.flush()
+	}
+}
+
+// flush writes the buffer to the underlying writer. After a write error, it
+// discards the buffer, so that an encoder on a failed writer doesn't keep the
+// rest of the output in memory.
+func (enc *Encoder) flush() error {
+	if enc.err != nil {
+		enc.buf = enc.buf[:0]
+		return enc.err
+	}
+	if len(enc.buf) == 0 {
+		return nil
+	}
+	n, err := enc.w.Write(enc.buf)
+	enc.n += int64(n)
+	if err == nil && n < len(enc.buf) {
+		err = io.ErrShortWrite
+	}
+	enc.err = err
+	enc.buf = enc.buf[:0]
+	return err
+}
+
+// appendSectionHeader appends the header of the section named name and
+// subsection to buf, as in `[remote "origin"]`.
+func appendSectionHeader(buf []byte, name, subsection string) []byte {
+	buf = append(buf, '[')
+	buf = append(buf, name...)
+	if subsection != "" {
+		buf = append(buf, ' ')
+		buf = strconv.AppendQuote(buf, subsection)
+	}
+	return append(buf, ']')
+}
+
+// appendValue appends the INI encoding of val to buf.
+func appendValue(buf []byte, val Value) []byte {
+	switch val := val.(type) {
+	case String:
+		return strconv.AppendQuote(buf, val.Value)
+	case Number:
+		return strconv.AppendFloat(buf, val.Value, 'f', -1, 64)
+	case Raw:
+		return append(buf, val.Value...)
+	default:
+		// Value is sealed, so this can happen only with a nil Value.
+		return append(buf, "<nil>"...)
+	}
+}
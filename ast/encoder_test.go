@@ -0,0 +1,183 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+
+	"github.com/marco-m/roundtrip_ini/ast"
+)
+
+func TestWriteTo(t *testing.T) {
+	input := normalizeEnds(`
+# comment for a
+a = 1
+
+[s1]
+
+b = "x"`)
+	tree := parse(t, input)
+
+	testCases := []struct {
+		name string
+		node io.WriterTo
+		want string
+	}{
+		{
+			name: "AST",
+			node: tree,
+			want: input,
+		},
+		{
+			name: "Section",
+			node: tree.Sections[0],
+			want: "[s1]\n\nb = \"x\"\n",
+		},
+		{
+			name: "Property",
+			node: tree.Properties[0],
+			want: "# comment for a\na = 1\n\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var bld strings.Builder
+
+			n, err := tc.node.WriteTo(&bld)
+
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.Equals(bld.String(), tc.want))
+			qt.Assert(t, qt.Equals(n, int64(len(tc.want))))
+		})
+	}
+}
+
+func TestEncoderOptions(t *testing.T) {
+	input := `
+# comment for a
+a = 1
+
+[s1]
+b = "x"`
+	tree := parse(t, input)
+
+	testCases := []struct {
+		name string
+		opts []ast.EncoderOption
+		want string
+	}{
+		{
+			name: "default",
+			want: "# comment for a\na = 1\n\n[s1]\nb = \"x\"\n",
+		},
+		{
+			name: "line ending",
+			opts: []ast.EncoderOption{ast.WithLineEnding("\r\n")},
+			want: "# comment for a\r\na = 1\r\n\r\n[s1]\r\nb = \"x\"\r\n",
+		},
+		{
+			name: "separator",
+			opts: []ast.EncoderOption{ast.WithKeyValueSeparator("=")},
+			want: "# comment for a\na=1\n\n[s1]\nb=\"x\"\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var bld strings.Builder
+			enc := ast.NewEncoder(&bld, tc.opts...)
+
+			err := enc.Encode(tree)
+
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.Equals(bld.String(), tc.want))
+		})
+	}
+}
+
+// failingWriter accepts limit bytes, then fails.
+type failingWriter struct {
+	limit  int
+	err    error
+	writes int // calls of Write
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if len(p) > w.limit {
+		n := w.limit
+		w.limit = 0
+		return n, w.err
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+func TestWriteToReportsWriterError(t *testing.T) {
+	tree := parse(t, genINI(10, 100))
+	errWrite := errors.New("disk full")
+	w := &failingWriter{limit: 5000, err: errWrite}
+
+	n, err := tree.WriteTo(w)
+
+	qt.Assert(t, qt.ErrorIs(err, errWrite))
+	qt.Assert(t, qt.Equals(n, int64(5000)))
+}
+
+func TestEncoderStopsAfterWriterError(t *testing.T) {
+	tree := parse(t, genINI(10, 100))
+	errWrite := errors.New("disk full")
+	w := &failingWriter{limit: 5000, err: errWrite}
+	enc := ast.NewEncoder(w)
+
+	err := enc.Encode(tree)
+	qt.Assert(t, qt.ErrorIs(err, errWrite))
+	writes := w.writes
+
+	err = enc.Encode(tree)
+	qt.Assert(t, qt.ErrorIs(err, errWrite))
+	qt.Assert(t, qt.Equals(w.writes, writes))
+}
+
+func TestEncoderLargeDocument(t *testing.T) {
+	input := genINI(10, 1000)
+	tree := parse(t, input)
+	var bld strings.Builder
+
+	err := ast.NewEncoder(&bld).Encode(tree)
+
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(bld.String(), input))
+}
+
+func BenchmarkEncode(b *testing.B) {
+	tree, err := ast.NewParser().ParseString("", genINI(100, 100))
+	if err != nil {
+		b.Fatal(err)
+	}
+	size, _ := tree.WriteTo(io.Discard)
+
+	b.Run("String", func(b *testing.B) {
+		b.SetBytes(size)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = tree.String()
+		}
+	})
+	b.Run("WriteTo", func(b *testing.B) {
+		b.SetBytes(size)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := tree.WriteTo(io.Discard); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
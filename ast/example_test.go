@@ -4,8 +4,11 @@
 package ast_test
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 
 	"github.com/marco-m/roundtrip_ini/ast"
 )
@@ -126,3 +129,82 @@ b = 2
 	fmt.Println(tree)
 	return nil
 }
+
+func Example_decoder() {
+	if err := exampleDecoder(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// Output:
+	// 4:1: s1/b = 2
+}
+
+func exampleDecoder() error {
+	input := `
+a = 1
+[s1]
+b = 2
+[s2]
+b = 3`
+
+	dec := ast.NewParser().NewDecoder("", strings.NewReader(input))
+
+	// Scan until we find the key we are interested in.
+	for {
+		ev, err := dec.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if ev.Kind == ast.EventProperty && ev.Section == "s1" && ev.Key == "b" {
+			fmt.Printf("%s: %s/%s = %s\n", ev.Pos, ev.Section, ev.Key, ev.Value)
+			return nil
+		}
+	}
+}
+
+func Example_apply() {
+	if err := exampleApply(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// Output:
+	// a = 1
+	// [s1]
+	// b = 2
+}
+
+func exampleApply() error {
+	input := `
+# TODO remove
+a = 1
+[s1]
+# TODO remove
+b = 2
+c = 3`
+
+	tree, err := ast.NewParser().ParseString("", input)
+	if err != nil {
+		return err
+	}
+
+	// Delete all the TODO comments and the properties greater than 2.
+	ast.Apply(tree, func(c *ast.Cursor) bool {
+		switch n := c.Node().(type) {
+		case *ast.Comment:
+			if strings.Contains(n.Text, "TODO") {
+				c.Delete()
+			}
+		case *ast.Property:
+			if num, ok := n.Value.(ast.Number); ok && num.Value > 2 {
+				c.Delete()
+			}
+		}
+		return true
+	}, nil)
+
+	fmt.Print(tree)
+	return nil
+}
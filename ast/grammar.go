@@ -4,7 +4,33 @@
 // Package ast performs low-level decoding, editing and encoding of the INI
 // format, preserving comments and blank lines.
 //
-// The decoding is based on the participle [INI example].
+// The decoder is a hand-written lexer and recursive descent parser for the
+// following grammar (whitespace between tokens is ignored):
+//
+//	AST      = NewLine* Property* Section* Trailer? .
+//	Section  = (Comment NewLine)* "[" Ident String? Colon? "]" Paren? NewLine? NewLine* Property* .
+//	Property = (Comment NewLine)* Ident "=" Value NewLine? NewLine* .
+//	Value    = String | Number .
+//	Trailer  = Comment (NewLine Comment)* NewLine* .
+//
+//	Ident    = `[a-zA-Z][a-zA-Z_\d]*` .
+//	String   = `"(\\.|[^"\n])*"` .
+//	Number   = `\d+(\.\d+)?` .
+//	Comment  = `[#;][^\n]*` .
+//	Colon    = `:[^\]\n]*` .
+//	Paren    = `\([^)\n]*\)` .
+//	NewLine  = `\n` .
+//
+// The optional String of a section header is the subsection, as in the
+// [remote "origin"] sections of git config files. See [Path] for how to
+// address it. The optional Colon and Paren of a section header are its
+// inheritance clause, recognized only with [WithInheritance].
+//
+// The options of [NewParser] change the comment markers, the separators and
+// the value types (adding Raw, the text up to the end of the line), and relax
+// the syntax of Ident.
+//
+// The grammar was originally based on the participle [INI example].
 //
 // [INI example]: https://github.com/alecthomas/participle/tree/master/_examples/ini
 package ast
@@ -13,87 +39,59 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-
-	"github.com/alecthomas/participle/v2"
-	"github.com/alecthomas/participle/v2/lexer"
 )
 
-// NewParser returns a participle parser for parsing INI files.
-//
-// NewParse can panic, but only in case the roundtrip_ini grammar definition
-// is wrong. This means that the panic is 100% deterministic: if you have only
-// one test that calls NewParser successfully, then newParser will not panic
-// in production.
-func NewParser() *participle.Parser[AST] {
-	rules := []lexer.SimpleRule{
-		{`Ident`, `[a-zA-Z][a-zA-Z_\d]*`},
-		{`String`, `"(?:\\.|[^"])*"`},
-		{`Float`, `\d+(?:\.\d+)?`},
-		{`Punct`, `[][=]`},
-		{"Comment", `[#;][^\n]*`},
-		{"NewLine", `\n`},
-		{"whitespace", `[\t ]+`},
-	}
-	iniLexer := lexer.MustSimple(rules)
-
-	return participle.MustBuild[AST](
-		participle.Lexer(iniLexer),
-		participle.Unquote("String"),
-		participle.Union[Value](String{}, Number{}),
-		participle.UseLookahead(4), // to associate comments with the correct node
-	)
-}
-
 // AST is the root struct created by the parser.
 type AST struct {
-	Pos        lexer.Position
-	BlankLines []string    `parser:"@NewLine*"`
-	Properties []*Property `parser:"@@*"`
-	Sections   []*Section  `parser:"@@*"`
+	Pos        Position
+	BlankLines []string
+	Properties []*Property
+	Sections   []*Section
+	// Comments are the comments at the end of the input, which are not
+	// followed by a property or section. As for the comments of a section
+	// or property, an empty string is a blank line between two comments.
+	Comments []string
+
+	idx         *lookupIndex // optional, see EnableIndex
+	foldCase    bool         // match names without regard to case
+	hasDefaults bool         // see SetDefaultsSection
+	defaults    string       // name of the defaults section
+	// commentMarker starts the comments written by the package, such as the
+	// conflict markers of Merge3; 0 means '#'.
+	commentMarker byte
+	// lenient reports whether the tree was parsed with WithStrict(false), so
+	// that new names need not be identifiers; reserved are the separators
+	// and comment markers of that parser, which new names cannot contain.
+	lenient  bool
+	reserved string
 }
 
 // String encodes the AST to the INI format.
 func (tree *AST) String() string {
 	var bld strings.Builder
-
-	for _, prop := range tree.Properties {
-		fmt.Fprint(&bld, prop.String())
-	}
-
-	for _, sec := range tree.Sections {
-		fmt.Fprint(&bld, sec.String())
-	}
-
+	tree.WriteTo(&bld)
 	return bld.String()
 }
 
 // Property is a key/value pair, with optional metadata for encoding fidelity
 // (comment and blank lines).
 type Property struct {
-	Comments   []string `parser:"(@Comment NewLine)*"`
-	Key        string   `parser:"@Ident '='"`
-	Value      Value    `parser:"@@ NewLine?"`
-	BlankLines []string `parser:"@NewLine*"`
+	Pos        Position // position of the key
+	Comments   []string // comment lines above the key; "" is a blank line
+	Key        string
+	Value      Value
+	BlankLines []string
 }
 
 // String encodes the Property to the INI format.
 func (prop *Property) String() string {
 	var bld strings.Builder
-
-	for _, cmt := range prop.Comments {
-		fmt.Fprintln(&bld, cmt)
-	}
-
-	fmt.Fprintf(&bld, "%s = %s\n", prop.Key, prop.Value)
-
-	for range prop.BlankLines {
-		fmt.Fprintln(&bld)
-	}
-
+	prop.WriteTo(&bld)
 	return bld.String()
 }
 
 type namer interface {
+	comparable
 	name() string
 }
 
@@ -102,16 +100,13 @@ func (prop *Property) name() string {
 }
 
 // Value is the value of a INI key.
-// Note that it is a union type implemented as a [sealed interface].
-//
-// Concrete types are associated with participle.Union() in [NewParser].
-//
-// [sealed interface]: https://github.com/alecthomas/participle#union-types
+// Note that it is a union type implemented as a sealed interface: the only
+// concrete types are [String], [Number] and [Raw].
 type Value interface{ value() }
 
 // String is one of the possible types for a Value.
 type String struct {
-	Value string `parser:"@String"`
+	Value string
 }
 
 func (s String) value() {} // sealed
@@ -122,7 +117,7 @@ func (s String) String() string {
 
 // Number is one of the possible types for a Value.
 type Number struct {
-	Value float64 `parser:"@Float"`
+	Value float64
 }
 
 func (nu Number) value() {} // sealed
@@ -134,33 +129,49 @@ func (nu Number) String() string {
 // Section is a INI file section, with optional metadata for encoding fidelity
 // (comment and blank lines).
 type Section struct {
-	Comments   []string    `parser:"(@Comment NewLine)*"`
-	Name       string      `parser:"'[' @Ident ']' NewLine?"`
-	BlankLines []string    `parser:"@NewLine*"`
-	Properties []*Property `parser:"@@*"`
+	Pos        Position // position of the opening bracket
+	Comments   []string // comment lines above the header; "" is a blank line
+	Name       string
+	Subsection string // optional, as in [Name "Subsection"]
+	// Parents are the names of the sections that the section inherits keys
+	// from, in order of priority, as in [Name : Parent]. See
+	// [WithInheritance].
+	Parents []string
+	// Template reports whether the section is a template, as in the
+	// Asterisk header [Name](!). ColonInheritance has no template marker, so
+	// the encoder writes Template only with the other syntaxes.
+	Template bool
+	// Inheritance is the syntax of the inheritance clause of the header. The
+	// encoder writes the clause as parsed, if Parents and Template still
+	// match it, or else in this syntax, with ParenInheritance for
+	// NoInheritance.
+	Inheritance InheritanceSyntax
+	BlankLines  []string
+	Properties  []*Property
+
+	clause string // inheritance clause as parsed, with its blanks
 }
 
 // String encodes the Section to the INI format.
 func (sec *Section) String() string {
 	var bld strings.Builder
-
-	for _, cmt := range sec.Comments {
-		fmt.Fprintln(&bld, cmt)
-	}
-
-	fmt.Fprintf(&bld, "[%s]\n", sec.Name)
-
-	for range sec.BlankLines {
-		fmt.Fprintln(&bld)
-	}
-
-	for _, prop := range sec.Properties {
-		fmt.Fprint(&bld, prop.String())
-	}
-
+	sec.WriteTo(&bld)
 	return bld.String()
 }
 
 func (sec *Section) name() string {
-	return sec.Name
+	return SectionName(sec.Name, sec.Subsection)
+}
+
+// Raw is one of the possible types for a Value: the unquoted text up to the
+// end of the line, with leading and trailing whitespace removed.
+// The parser produces it only when configured with [RawValues].
+type Raw struct {
+	Value string
+}
+
+func (r Raw) value() {} // sealed
+
+func (r Raw) String() string {
+	return r.Value
 }
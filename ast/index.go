@@ -0,0 +1,300 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// EnableIndex turns on an index of section names and keys. With the index,
+// [AST.Lookup], [AST.LookupSection] and [AST.Add] take constant time instead of
+// time proportional to the number of sections and properties, and
+// [AST.Remove] and [AST.RemoveSection] no longer compare names. This makes bulk
+// edits of large documents linear instead of quadratic.
+//
+// The index is built lazily and is kept consistent by the methods of AST.
+// It also detects changes made directly to the Sections and Properties slices
+// that change their length, their first element's address or their last
+// element, such as append, reslicing, or a delete followed by an append, and
+// rebuilds itself as needed. What it cannot detect is an element other than
+// the last one replaced in place, a delete followed by the append of the
+// element that was already last, or a Key or Name field changed directly
+// instead of with [AST.RenameKey] or [AST.RenameSection]; in that case, call
+// [AST.Reindex].
+//
+// The index costs memory proportional to the number of sections and
+// properties, so it pays off only on large documents.
+func (tree *AST) EnableIndex() {
+	if tree.idx == nil {
+		tree.idx = newLookupIndex(tree.foldCase)
+	}
+}
+
+// DisableIndex turns off and frees the index enabled by [AST.EnableIndex].
+func (tree *AST) DisableIndex() {
+	tree.idx = nil
+}
+
+// Reindex rebuilds the index from scratch. See [AST.EnableIndex] for when it
+// is needed. If the index is not enabled, Reindex does nothing.
+func (tree *AST) Reindex() {
+	if tree.idx != nil {
+		tree.idx = newLookupIndex(tree.foldCase)
+	}
+}
+
+// lookupIndex is the index of an AST.
+type lookupIndex struct {
+	fold     bool
+	sections nameIndex[*Section]
+	global   nameIndex[*Property]
+	props    map[*Section]*nameIndex[*Property]
+}
+
+func newLookupIndex(fold bool) *lookupIndex {
+	return &lookupIndex{
+		fold:     fold,
+		sections: nameIndex[*Section]{fold: fold},
+		global:   nameIndex[*Property]{fold: fold},
+	}
+}
+
+// sectionsIndex returns the index of tree.Sections, or nil if the index is
+// not enabled.
+func (tree *AST) sectionsIndex() *nameIndex[*Section] {
+	if tree.idx == nil {
+		return nil
+	}
+	return &tree.idx.sections
+}
+
+// propsIndex returns the index of the properties of sec (nil for the global
+// section), or nil if the index is not enabled.
+func (tree *AST) propsIndex(sec *Section) *nameIndex[*Property] {
+	if tree.idx == nil {
+		return nil
+	}
+	if sec == nil {
+		return &tree.idx.global
+	}
+	if tree.idx.props == nil {
+		tree.idx.props = make(map[*Section]*nameIndex[*Property])
+	}
+	ix := tree.idx.props[sec]
+	if ix == nil {
+		ix = &nameIndex[*Property]{fold: tree.idx.fold}
+		tree.idx.props[sec] = ix
+	}
+	return ix
+}
+
+// forgetSection drops the index of the properties of sec.
+func (tree *AST) forgetSection(sec *Section) {
+	if tree.idx != nil {
+		delete(tree.idx.props, sec)
+	}
+}
+
+// nameIndex indexes the elements of a slice by name.
+//
+// It detects changes to the slice made behind its back (append, delete,
+// reslice) by remembering the address of the first element, the last element
+// and the length. It cannot detect the other elements replaced or renamed in
+// place.
+type nameIndex[E namer] struct {
+	fold  bool // case-insensitive
+	first *E
+	last  E
+	n     int
+	names map[string]nameEntry[E]
+}
+
+type nameEntry[E namer] struct {
+	first E   // first element with this name, in slice order
+	count int // number of elements with this name
+}
+
+// sync rebuilds the index if a has changed since the last call.
+func (ix *nameIndex[E]) sync(a []E) {
+	if ix.unchanged(a) {
+		return
+	}
+	ix.names = make(map[string]nameEntry[E], len(a))
+	for _, e := range a {
+		key := ix.key(e.name())
+		entry, ok := ix.names[key]
+		if !ok {
+			entry.first = e
+		}
+		entry.count++
+		ix.names[key] = entry
+	}
+	ix.remember(a)
+}
+
+// key returns the map key of name.
+func (ix *nameIndex[E]) key(name string) string {
+	if ix.fold {
+		return foldName[E](name)
+	}
+	return name
+}
+
+func (ix *nameIndex[E]) remember(a []E) {
+	ix.first = firstAddr(a)
+	ix.last = lastElem(a)
+	ix.n = len(a)
+}
+
+// unchanged reports whether the index is built and a has the length, the
+// address of the first element and the last element seen by remember.
+func (ix *nameIndex[E]) unchanged(a []E) bool {
+	return ix.names != nil && ix.n == len(a) && ix.first == firstAddr(a) &&
+		ix.last == lastElem(a)
+}
+
+// lookup returns the first element of a named name.
+func (ix *nameIndex[E]) lookup(a []E, name string) (E, bool) {
+	ix.sync(a)
+	entry, ok := ix.names[ix.key(name)]
+	if ok && !sameName[E](entry.first.name(), name, ix.fold) {
+		// Renamed in place behind our back.
+		ix.names = nil
+		ix.sync(a)
+		entry, ok = ix.names[ix.key(name)]
+	}
+	return entry.first, ok
+}
+
+// added updates the index after the element e has been added to a.
+func (ix *nameIndex[E]) added(a []E, e E) {
+	// The slice may have been reallocated, so instead of the address of the
+	// first element check that the old last element is still last, or just
+	// before e if e was appended.
+	n := len(a)
+	sameLast := a[n-1] == ix.last || a[n-1] == e && lastElem(a[:n-1]) == ix.last
+	if ix.names == nil || ix.n != n-1 || !sameLast {
+		ix.sync(a)
+		return
+	}
+	key := ix.key(e.name())
+	entry, ok := ix.names[key]
+	if !ok {
+		entry.first = e
+	} else if a[len(a)-1] != e {
+		// Duplicate names are rare, so a linear search is fine.
+		entry.first = a[index(a, e.name(), ix.fold)]
+	}
+	entry.count++
+	ix.names[key] = entry
+	ix.remember(a)
+}
+
+// removed updates the index after the element e has been removed from a.
+func (ix *nameIndex[E]) removed(a []E, e E) {
+	if ix.names == nil || ix.n != len(a)+1 || ix.last != e && ix.last != lastElem(a) {
+		ix.sync(a)
+		return
+	}
+	key := ix.key(e.name())
+	entry := ix.names[key]
+	entry.count--
+	switch {
+	case entry.count <= 0:
+		delete(ix.names, key)
+	case entry.first == e:
+		// Duplicate names are rare, so a linear search is fine.
+		entry.first = a[index(a, e.name(), ix.fold)]
+		ix.names[key] = entry
+	default:
+		ix.names[key] = entry
+	}
+	ix.remember(a)
+}
+
+// renamed updates the index after the element e of a has been renamed from
+// old to its current name, which is not the name of any other element of a.
+func (ix *nameIndex[E]) renamed(a []E, e E, old string) {
+	if !ix.unchanged(a) {
+		ix.names = nil
+		ix.sync(a)
+		return
+	}
+	oldKey, key := ix.key(old), ix.key(e.name())
+	if oldKey == key {
+		return
+	}
+	entry := ix.names[oldKey]
+	entry.count--
+	switch {
+	case entry.count <= 0:
+		delete(ix.names, oldKey)
+	case entry.first == e:
+		entry.first = a[index(a, old, ix.fold)]
+		ix.names[oldKey] = entry
+	default:
+		ix.names[oldKey] = entry
+	}
+	ix.names[key] = nameEntry[E]{first: e, count: 1}
+}
+
+// firstAddr returns the address of the first element of a, or nil.
+func firstAddr[E any](a []E) *E {
+	if len(a) == 0 {
+		return nil
+	}
+	return &a[0]
+}
+
+// lastElem returns the last element of a, or the zero value.
+func lastElem[E any](a []E) E {
+	var last E
+	if len(a) > 0 {
+		last = a[len(a)-1]
+	}
+	return last
+}
+
+// foldKey returns a string that is the same for all the strings that match
+// name according to [strings.EqualFold]. It maps each rune to the smallest
+// rune of its case folding orbit.
+//
+// Note that strings.ToLower is not enough: for example, "ſ" (long s) and
+// "ς" (final sigma) are equal under folding to "s" and "σ", but ToLower leaves
+// them unchanged.
+func foldKey(name string) string {
+	ascii := true
+	for i := 0; i < len(name); i++ {
+		if name[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		// For an ASCII letter the smallest rune of the orbit is the upper
+		// case letter: the only non-ASCII runes in these orbits, the Kelvin
+		// sign and the long s, are bigger.
+		return strings.ToUpper(name)
+	}
+	var bld strings.Builder
+	bld.Grow(len(name))
+	for _, r := range name {
+		bld.WriteRune(minFold(r))
+	}
+	return bld.String()
+}
+
+// minFold returns the smallest rune that is equivalent to r under simple
+// Unicode case folding.
+func minFold(r rune) rune {
+	smallest := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < smallest {
+			smallest = f
+		}
+	}
+	return smallest
+}
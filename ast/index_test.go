@@ -0,0 +1,260 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast_test
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+
+	"github.com/marco-m/roundtrip_ini/ast"
+)
+
+// Apply the same random sequence of edits to an indexed and to a non-indexed
+// tree, and check that they stay identical.
+func TestIndexConsistentWithLinearSearch(t *testing.T) {
+	input := `
+a = 1
+a = 2
+[s1]
+b = 1
+[s2]
+c = 1
+[s1]
+d = 1`
+	plain := parse(t, input)
+	indexed := parse(t, input)
+	indexed.EnableIndex()
+
+	rng := rand.New(rand.NewSource(1))
+	sections := []string{"", "s1", "s2", "s3"}
+	keys := []string{"a", "b", "c", "d", "e"}
+	randPath := func() ast.Path {
+		sec := sections[rng.Intn(len(sections))]
+		key := keys[rng.Intn(len(keys))]
+		return ast.NewPath(sec, key)
+	}
+
+	for i := 0; i < 2000; i++ {
+		var op string
+		switch rng.Intn(7) {
+		case 0:
+			keyPath, val := randPath(), ast.Number{Value: float64(i)}
+			op = fmt.Sprintf("Add(%q, %v)", keyPath, val)
+			plain.Add(keyPath, val)
+			indexed.Add(keyPath, val)
+		case 1:
+			keyPath := randPath()
+			op = fmt.Sprintf("Remove(%q)", keyPath)
+			plain.Remove(keyPath)
+			indexed.Remove(keyPath)
+		case 2:
+			secName := sections[1+rng.Intn(len(sections)-1)]
+			op = fmt.Sprintf("RemoveSection(%q)", secName)
+			plain.RemoveSection(secName)
+			indexed.RemoveSection(secName)
+		case 3:
+			keyPath := randPath()
+			op = fmt.Sprintf("Lookup(%q)", keyPath)
+			qt.Assert(t, qt.Equals(propString(indexed.Lookup(keyPath)),
+				propString(plain.Lookup(keyPath))), qt.Commentf("op %d: %s", i, op))
+		case 4:
+			keyPath, secName := randPath(), sections[rng.Intn(len(sections))]
+			index := rng.Intn(3)
+			op = fmt.Sprintf("MoveProperty(%q, %q, %d)", keyPath, secName, index)
+			want := plain.MoveProperty(keyPath, secName, index)
+			got := indexed.MoveProperty(keyPath, secName, index)
+			qt.Assert(t, qt.Equals(fmt.Sprint(got), fmt.Sprint(want)),
+				qt.Commentf("op %d: %s", i, op))
+		case 5:
+			secName, index := sections[1+rng.Intn(len(sections)-1)], rng.Intn(3)
+			op = fmt.Sprintf("MoveSection(%q, %d)", secName, index)
+			want := plain.MoveSection(secName, index)
+			got := indexed.MoveSection(secName, index)
+			qt.Assert(t, qt.Equals(fmt.Sprint(got), fmt.Sprint(want)),
+				qt.Commentf("op %d: %s", i, op))
+		case 6:
+			keyPath, key := randPath(), keys[rng.Intn(len(keys))]
+			op = fmt.Sprintf("RenameKey(%q, %q)", keyPath, key)
+			want := plain.RenameKey(keyPath, key)
+			got := indexed.RenameKey(keyPath, key)
+			qt.Assert(t, qt.Equals(fmt.Sprint(got), fmt.Sprint(want)),
+				qt.Commentf("op %d: %s", i, op))
+		}
+		qt.Assert(t, qt.Equals(indexed.String(), plain.String()),
+			qt.Commentf("op %d: %s", i, op))
+	}
+}
+
+func propString(prop *ast.Property) string {
+	if prop == nil {
+		return "<nil>"
+	}
+	return prop.String()
+}
+
+func TestIndexDetectsDirectSliceManipulation(t *testing.T) {
+	tree := parse(t, `
+a = 1
+b = 2
+[s1]
+c = 3`)
+	tree.EnableIndex()
+	qt.Assert(t, qt.IsNotNil(tree.Lookup("b")))
+	qt.Assert(t, qt.IsNotNil(tree.LookupSection("s1")))
+
+	// Delete "b" directly.
+	tree.Properties = tree.Properties[:1]
+	qt.Assert(t, qt.IsNil(tree.Lookup("b")))
+
+	// Append directly.
+	tree.Properties = append(tree.Properties, &ast.Property{Key: "x"})
+	qt.Assert(t, qt.IsNotNil(tree.Lookup("x")))
+
+	// Add a section directly.
+	tree.Sections = append(tree.Sections, &ast.Section{Name: "s2"})
+	qt.Assert(t, qt.IsNotNil(tree.LookupSection("s2")))
+
+	// Delete a section directly.
+	tree.Sections = tree.Sections[1:]
+	qt.Assert(t, qt.IsNil(tree.LookupSection("s1")))
+}
+
+func TestIndexDetectsDeleteThenAppend(t *testing.T) {
+	tree := parse(t, `
+a = 1
+b = 2
+c = 3`)
+	tree.EnableIndex()
+	qt.Assert(t, qt.IsNotNil(tree.Lookup("b")))
+
+	// Same length and same first element, but a different last element.
+	tree.Properties = slices.Delete(tree.Properties, 1, 2)
+	tree.Properties = append(tree.Properties, &ast.Property{Key: "d", Value: ast.Number{Value: 4}})
+
+	qt.Assert(t, qt.IsNil(tree.Lookup("b")))
+	qt.Assert(t, qt.IsNotNil(tree.Lookup("d")))
+	qt.Assert(t, qt.IsNotNil(tree.Lookup("c")))
+
+	// Same sequence followed by an edit through the AST.
+	tree.Properties = slices.Delete(tree.Properties, 0, 1)
+	tree.Properties = append(tree.Properties, &ast.Property{Key: "e", Value: ast.Number{Value: 5}})
+	tree.Add("f", ast.Number{Value: 6})
+
+	qt.Assert(t, qt.IsNil(tree.Lookup("a")))
+	qt.Assert(t, qt.IsNotNil(tree.Lookup("e")))
+	qt.Assert(t, qt.Equals(tree.String(), "c = 3\nd = 4\ne = 5\nf = 6\n"))
+}
+
+func TestIndexDetectsRenameOfFoundElement(t *testing.T) {
+	tree := parse(t, `
+a = 1
+b = 2`)
+	tree.EnableIndex()
+	qt.Assert(t, qt.IsNotNil(tree.Lookup("a")))
+
+	tree.Properties[0].Key = "z"
+
+	qt.Assert(t, qt.IsNil(tree.Lookup("a")))
+	qt.Assert(t, qt.IsNotNil(tree.Lookup("z")))
+}
+
+func TestReindex(t *testing.T) {
+	tree := parse(t, `
+a = 1
+b = 2`)
+	tree.EnableIndex()
+	qt.Assert(t, qt.IsNil(tree.Lookup("x")))
+
+	// Replace the first element in place: same slice address, length and
+	// last element, not detectable.
+	tree.Properties[0] = &ast.Property{Key: "x", Value: ast.Number{Value: 9}}
+	tree.Reindex()
+
+	qt.Assert(t, qt.IsNotNil(tree.Lookup("x")))
+	qt.Assert(t, qt.IsNil(tree.Lookup("a")))
+}
+
+//
+// Benchmarks.
+//
+
+const benchKeys = 100_000
+
+// genTree returns a tree with a single section holding n keys.
+func genTree(n int) *ast.AST {
+	sec := &ast.Section{Name: "s1"}
+	for i := 0; i < n; i++ {
+		sec.Properties = append(sec.Properties, &ast.Property{
+			Key:   fmt.Sprintf("key%d", i),
+			Value: ast.Number{Value: float64(i)},
+		})
+	}
+	return &ast.AST{Sections: []*ast.Section{sec}}
+}
+
+func BenchmarkLookup100k(b *testing.B) {
+	for _, indexed := range []bool{false, true} {
+		b.Run(fmt.Sprintf("indexed=%v", indexed), func(b *testing.B) {
+			tree := genTree(benchKeys)
+			if indexed {
+				tree.EnableIndex()
+				tree.Lookup("s1/key0") // build the index
+			}
+			paths := make([]ast.Path, 1000)
+			for i := range paths {
+				paths[i] = ast.Path(fmt.Sprintf("s1/key%d", (i*7919)%benchKeys))
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if tree.Lookup(paths[i%len(paths)]) == nil {
+					b.Fatal("not found")
+				}
+			}
+		})
+	}
+}
+
+// Build a document of 100k keys from scratch with Add.
+func BenchmarkAdd100k(b *testing.B) {
+	paths := make([]ast.Path, benchKeys)
+	for i := range paths {
+		paths[i] = ast.Path(fmt.Sprintf("s%d/key%d", i%10, i))
+	}
+	for _, indexed := range []bool{false, true} {
+		b.Run(fmt.Sprintf("indexed=%v", indexed), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tree := &ast.AST{}
+				if indexed {
+					tree.EnableIndex()
+				}
+				for j, keyPath := range paths {
+					tree.Add(keyPath, ast.Number{Value: float64(j)})
+				}
+			}
+		})
+	}
+}
+
+// Remove 1000 keys from a document of 100k keys.
+func BenchmarkRemove100k(b *testing.B) {
+	for _, indexed := range []bool{false, true} {
+		b.Run(fmt.Sprintf("indexed=%v", indexed), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				tree := genTree(benchKeys)
+				if indexed {
+					tree.EnableIndex()
+				}
+				b.StartTimer()
+				for j := 0; j < 1000; j++ {
+					tree.Remove(ast.Path(fmt.Sprintf("s1/key%d", benchKeys-1-j*97)))
+				}
+			}
+		})
+	}
+}
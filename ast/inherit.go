@@ -0,0 +1,199 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// InheritanceSyntax is the syntax of the inheritance clause of a section
+// header, which lists the sections that the section inherits keys from.
+type InheritanceSyntax int
+
+const (
+	// NoInheritance means that section headers have no inheritance clause.
+	NoInheritance InheritanceSyntax = iota
+	// ColonInheritance is the syntax of PHP Zend_Config_Ini, inside the
+	// brackets: [child : parent]. More parents are separated by further
+	// colons, as in [child : parent1 : parent2].
+	ColonInheritance
+	// ParenInheritance is the syntax of Asterisk, after the brackets:
+	// [child](parent1,parent2). A "!" among the parents marks the section as
+	// a template, as in [name](!) or [name](!,parent).
+	ParenInheritance
+)
+
+// WithInheritance makes the parser accept the inheritance clauses of syntax
+// in section headers, stored in Section.Parents. The default is
+// NoInheritance. With ColonInheritance, a non-strict section name cannot
+// contain ":". Lookups follow the parents of a section: see [AST.Lookup] and
+// [AST.CheckInheritance].
+func WithInheritance(syntax InheritanceSyntax) Option {
+	return func(cfg *config) {
+		cfg.inheritance = syntax
+	}
+}
+
+// parseInheritance parses the inheritance clause text of syntax, such as
+// ": parent" or "(!,parent)", into sec.
+func parseInheritance(sec *Section, syntax InheritanceSyntax, text string) error {
+	text = strings.TrimSpace(text)
+	var parents []string
+	switch syntax {
+	case ColonInheritance:
+		parents = strings.Split(strings.TrimPrefix(text, ":"), ":")
+	case ParenInheritance:
+		text = strings.TrimSuffix(strings.TrimPrefix(text, "("), ")")
+		parents = strings.Split(text, ",")
+	}
+	sec.Inheritance = syntax
+	for _, parent := range parents {
+		parent = strings.TrimSpace(parent)
+		switch {
+		case parent == "":
+			return errors.New("empty parent section")
+		case parent == "!" && syntax == ParenInheritance:
+			sec.Template = true
+		default:
+			sec.Parents = append(sec.Parents, parent)
+		}
+	}
+	return nil
+}
+
+// appendInheritance appends the inheritance clause of sec to header, the
+// section header written by appendSectionHeader.
+func appendInheritance(header []byte, sec *Section) []byte {
+	clause := sec.inheritanceClause()
+	switch {
+	case clause == "":
+		return header
+	case sec.Inheritance == ColonInheritance:
+		header = header[:len(header)-1] // reopen the brackets
+		header = append(header, clause...)
+		return append(header, ']')
+	default:
+		return append(header, clause...)
+	}
+}
+
+// inheritanceClause returns the inheritance clause of the header of sec: the
+// clause as parsed if it still matches the parents of sec, or else a clause
+// that the parser accepts, such as " : parent" or "(!,parent)".
+func (sec *Section) inheritanceClause() string {
+	if sec.clause != "" && sec.clauseMatches() {
+		return sec.clause
+	}
+	switch {
+	case sec.Inheritance == ColonInheritance:
+		if len(sec.Parents) == 0 {
+			return ""
+		}
+		return " : " + strings.Join(sec.Parents, " : ")
+	case len(sec.Parents) == 0 && !sec.Template:
+		return ""
+	default:
+		parents := sec.Parents
+		if sec.Template {
+			parents = append([]string{"!"}, parents...)
+		}
+		return "(" + strings.Join(parents, ",") + ")"
+	}
+}
+
+// clauseMatches reports whether the clause of sec, as parsed, has the syntax,
+// parents and template marker of sec.
+func (sec *Section) clauseMatches() bool {
+	syntax := ParenInheritance
+	if strings.HasPrefix(strings.TrimSpace(sec.clause), ":") {
+		syntax = ColonInheritance
+	}
+	var parsed Section
+	return syntax == sec.Inheritance &&
+		parseInheritance(&parsed, syntax, sec.clause) == nil &&
+		slices.Equal(parsed.Parents, sec.Parents) &&
+		parsed.Template == sec.Template
+}
+
+// lookupInherited returns key of section sec (nil for the global section) or,
+// if sec doesn't have it, of its parents, depth-first in the order of
+// sec.Parents. Visited are the sections already visited, to stop at cycles.
+func (tree *AST) lookupInherited(sec *Section, key string, visited []*Section) *Property {
+	if prop, ok := find(tree.propsIndex(sec), *tree.properties(sec), key, tree.foldCase); ok {
+		return prop
+	}
+	if sec == nil || len(sec.Parents) == 0 {
+		return nil
+	}
+	visited = append(visited, sec)
+	for _, name := range sec.Parents {
+		parent, _ := tree.findSection(name, "")
+		if parent == nil || slices.Contains(visited, parent) {
+			continue
+		}
+		if prop := tree.lookupInherited(parent, key, visited); prop != nil {
+			return prop
+		}
+	}
+	return nil
+}
+
+// renameParent replaces the parents of the sections of tree that refer to
+// section sec with name, the new name of sec.
+func (tree *AST) renameParent(sec *Section, name string) {
+	for _, child := range tree.Sections {
+		for i, parent := range child.Parents {
+			if found, _ := tree.findSection(parent, ""); found == sec {
+				child.Parents[i] = name
+			}
+		}
+	}
+}
+
+// CheckInheritance reports whether the parents of the sections of tree, as in
+// the header [child : parent], exist and don't form a cycle. It returns an
+// error wrapping [ErrNotFound] for a missing parent or [ErrCycle] for a
+// section that inherits from itself, through its parents. If there are
+// several problems, CheckInheritance reports the first one, in document
+// order.
+//
+// Lookups don't fail because of these problems: they ignore the missing
+// parents and don't visit a section twice.
+func (tree *AST) CheckInheritance() error {
+	for _, sec := range tree.Sections {
+		if err := tree.checkParents(sec, []*Section{sec}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkParents checks the ancestors of the last section of path, the chain
+// of parents from a section of tree.
+func (tree *AST) checkParents(sec *Section, path []*Section) error {
+	for _, name := range sec.Parents {
+		parent, _ := tree.findSection(name, "")
+		if parent == nil {
+			return fmt.Errorf("%s: section %s: parent %q: %w",
+				sec.Pos, appendSectionHeader(nil, sec.Name, sec.Subsection), name, ErrNotFound)
+		}
+		if i := slices.Index(path, parent); i != -1 {
+			var chain []string
+			for _, s := range path[i:] {
+				chain = append(chain, s.Name)
+			}
+			chain = append(chain, parent.Name)
+			return fmt.Errorf("%s: section %s: %w: %s",
+				path[0].Pos, appendSectionHeader(nil, path[0].Name, path[0].Subsection),
+				ErrCycle, strings.Join(chain, " -> "))
+		}
+		if err := tree.checkParents(parent, append(path, parent)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,371 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+
+	"github.com/marco-m/roundtrip_ini/ast"
+)
+
+func TestInheritanceRoundTrip(t *testing.T) {
+	type testCase struct {
+		name         string
+		opts         []ast.Option
+		input        string
+		wantParents  [][]string
+		wantTemplate []bool
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		tree, err := ast.NewParser(tc.opts...).ParseString("", tc.input)
+		qt.Assert(t, qt.IsNil(err))
+
+		qt.Assert(t, qt.Equals(tree.String(), normalizeEnds(tc.input)))
+		var parents [][]string
+		var template []bool
+		for _, sec := range tree.Sections {
+			parents = append(parents, sec.Parents)
+			template = append(template, sec.Template)
+		}
+		qt.Assert(t, qt.DeepEquals(parents, tc.wantParents))
+		qt.Assert(t, qt.DeepEquals(template, tc.wantTemplate))
+	}
+
+	testCases := []testCase{
+		{
+			name:         "colon",
+			opts:         []ast.Option{ast.WithInheritance(ast.ColonInheritance)},
+			input:        "[production]\na = 1\n[staging : production]\n[dev:staging : production]",
+			wantParents:  [][]string{nil, {"production"}, {"staging", "production"}},
+			wantTemplate: []bool{false, false, false},
+		},
+		{
+			name:         "colon with blanks",
+			opts:         []ast.Option{ast.WithInheritance(ast.ColonInheritance)},
+			input:        "[a]\n[b  :a ]\n[c \"x\"\t: a]",
+			wantParents:  [][]string{nil, {"a"}, {"a"}},
+			wantTemplate: []bool{false, false, false},
+		},
+		{
+			name: "colon non-strict",
+			opts: []ast.Option{ast.WithInheritance(ast.ColonInheritance), ast.WithStrict(false)},
+			input: `
+[web server]
+[web server/eu  :web server]
+[remote "origin" : web server/eu]`,
+			wantParents:  [][]string{nil, {"web server"}, {"web server/eu"}},
+			wantTemplate: []bool{false, false, false},
+		},
+		{
+			name:  "paren",
+			opts:  []ast.Option{ast.WithInheritance(ast.ParenInheritance)},
+			input: "[phone](!)\n[office](!,phone)\n[alice](office, phone)\nsecret = \"x\"\n[bob] ( ! )",
+			wantParents: [][]string{
+				nil, {"phone"}, {"office", "phone"}, nil,
+			},
+			wantTemplate: []bool{true, true, false, true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) { run(t, tc) })
+	}
+}
+
+func TestInheritanceEditRoundTrip(t *testing.T) {
+	type testCase struct {
+		name         string
+		syntax       ast.InheritanceSyntax
+		input        string
+		edit         func(sec *ast.Section)
+		want         string
+		wantParents  []string
+		wantTemplate bool
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		parser := ast.NewParser(ast.WithInheritance(tc.syntax))
+		tree, err := parser.ParseString("", tc.input)
+		qt.Assert(t, qt.IsNil(err))
+		tc.edit(tree.Sections[0])
+
+		qt.Assert(t, qt.Equals(tree.String(), tc.want))
+		reparsed, err := parser.ParseString("", tree.String())
+		qt.Assert(t, qt.IsNil(err))
+		qt.Assert(t, qt.DeepEquals(reparsed.Sections[0].Parents, tc.wantParents))
+		qt.Assert(t, qt.Equals(reparsed.Sections[0].Template, tc.wantTemplate))
+	}
+
+	testCases := []testCase{
+		{
+			name:        "colon unchanged",
+			syntax:      ast.ColonInheritance,
+			input:       "[a:b]\n",
+			edit:        func(sec *ast.Section) {},
+			want:        "[a:b]\n",
+			wantParents: []string{"b"},
+		},
+		{
+			name:        "colon parents",
+			syntax:      ast.ColonInheritance,
+			input:       "[a:b]\n",
+			edit:        func(sec *ast.Section) { sec.Parents = []string{"c", "d"} },
+			want:        "[a : c : d]\n",
+			wantParents: []string{"c", "d"},
+		},
+		{
+			name:   "colon no parents",
+			syntax: ast.ColonInheritance,
+			input:  "[a : b]\n",
+			edit:   func(sec *ast.Section) { sec.Parents = nil },
+			want:   "[a]\n",
+		},
+		{
+			name:        "colon template",
+			syntax:      ast.ColonInheritance,
+			input:       "[a:b]\n",
+			edit:        func(sec *ast.Section) { sec.Template = true },
+			want:        "[a : b]\n",
+			wantParents: []string{"b"},
+		},
+		{
+			name:         "paren template",
+			syntax:       ast.ParenInheritance,
+			input:        "[a](b)\n",
+			edit:         func(sec *ast.Section) { sec.Template = true },
+			want:         "[a](!,b)\n",
+			wantParents:  []string{"b"},
+			wantTemplate: true,
+		},
+		{
+			name:         "paren no parents",
+			syntax:       ast.ParenInheritance,
+			input:        "[a] ( !, b)\n",
+			edit:         func(sec *ast.Section) { sec.Parents = nil },
+			want:         "[a](!)\n",
+			wantTemplate: true,
+		},
+		{
+			name:   "paren nothing",
+			syntax: ast.ParenInheritance,
+			input:  "[a](!)\n",
+			edit:   func(sec *ast.Section) { sec.Template = false },
+			want:   "[a]\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) { run(t, tc) })
+	}
+}
+
+func TestInheritanceParseErrors(t *testing.T) {
+	type testCase struct {
+		name    string
+		syntax  ast.InheritanceSyntax
+		input   string
+		wantErr string
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		parser := ast.NewParser(ast.WithInheritance(tc.syntax))
+
+		_, err := parser.ParseString("f.ini", tc.input)
+
+		qt.Assert(t, qt.ErrorMatches(err, tc.wantErr))
+	}
+
+	testCases := []testCase{
+		{
+			name:    "disabled",
+			syntax:  ast.NoInheritance,
+			input:   "[a : b]",
+			wantErr: `f.ini:1:4: invalid input text ":"`,
+		},
+		{
+			name:    "empty parent",
+			syntax:  ast.ColonInheritance,
+			input:   "[a : ]",
+			wantErr: `f.ini:1:4: empty parent section`,
+		},
+		{
+			name:    "colon without bracket",
+			syntax:  ast.ColonInheritance,
+			input:   "[a : b\nx = 1",
+			wantErr: `f.ini:1:7: unexpected newline \(expected "]"\)`,
+		},
+		{
+			name:    "empty paren",
+			syntax:  ast.ParenInheritance,
+			input:   "[a]()",
+			wantErr: `f.ini:1:4: empty parent section`,
+		},
+		{
+			name:    "unterminated paren",
+			syntax:  ast.ParenInheritance,
+			input:   "[a](b\nx = 1",
+			wantErr: `f.ini:1:4: unterminated inheritance clause`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) { run(t, tc) })
+	}
+}
+
+func TestInheritanceLookup(t *testing.T) {
+	parser := ast.NewParser(ast.WithInheritance(ast.ColonInheritance))
+	tree, err := parser.ParseString("", `
+[DEFAULT]
+level = "info"
+[base]
+host = "localhost"
+port = 80
+[other]
+port = 81
+user = "other"
+[prod : base : other]
+host = "example.com"
+[ghost : missing : base]`)
+	qt.Assert(t, qt.IsNil(err))
+	tree.SetDefaultsSection("DEFAULT")
+	qt.Assert(t, qt.ErrorIs(tree.CheckInheritance(), ast.ErrNotFound))
+
+	qt.Assert(t, qt.Equals(tree.Lookup("prod/host").Value, ast.Value(ast.String{Value: "example.com"})))
+	qt.Assert(t, qt.Equals(tree.Lookup("prod/port").Value, ast.Value(ast.Number{Value: 80})))
+	qt.Assert(t, qt.Equals(tree.Lookup("prod/user").Value, ast.Value(ast.String{Value: "other"})))
+	qt.Assert(t, qt.Equals(tree.Lookup("prod/level").Value, ast.Value(ast.String{Value: "info"})))
+	qt.Assert(t, qt.Equals(tree.Lookup("ghost/port").Value, ast.Value(ast.Number{Value: 80})))
+	qt.Assert(t, qt.IsNil(tree.Lookup("prod/missing")))
+	qt.Assert(t, qt.IsTrue(tree.Inherited("prod/port")))
+	qt.Assert(t, qt.IsFalse(tree.Inherited("prod/host")))
+	qt.Assert(t, qt.IsNil(tree.LookupOwn("prod/port")))
+
+	in := ast.NewInterpolator(tree, ast.WithInterpolationSyntax(ast.DollarSyntax))
+	val, err := in.Expand("prod", "${host}:${port}")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(val, "example.com:80"))
+
+	// Overriding an inherited value changes only the child.
+	tree.Add("prod/port", ast.Number{Value: 443})
+	qt.Assert(t, qt.Equals(tree.Lookup("base/port").Value, ast.Value(ast.Number{Value: 80})))
+	qt.Assert(t, qt.IsTrue(strings.Contains(tree.String(), "[prod : base : other]\nhost = \"example.com\"\nport = 443\n")))
+}
+
+func TestCheckInheritance(t *testing.T) {
+	type testCase struct {
+		name    string
+		input   string
+		wantErr string // empty for no error
+		wantIs  error
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		parser := ast.NewParser(ast.WithInheritance(ast.ColonInheritance))
+		tree, err := parser.ParseString("f.ini", tc.input)
+		qt.Assert(t, qt.IsNil(err))
+
+		err = tree.CheckInheritance()
+
+		if tc.wantErr == "" {
+			qt.Assert(t, qt.IsNil(err))
+			return
+		}
+		qt.Assert(t, qt.ErrorMatches(err, tc.wantErr))
+		qt.Assert(t, qt.IsTrue(errors.Is(err, tc.wantIs)))
+	}
+
+	testCases := []testCase{
+		{
+			name:  "diamond",
+			input: "[a]\n[b : a]\n[c : a]\n[d : b : c]",
+		},
+		{
+			name:    "missing parent",
+			input:   "[a]\n[b : a]\n[c : b : nope]",
+			wantErr: `f.ini:3:1: section \[c\]: parent "nope": not found`,
+			wantIs:  ast.ErrNotFound,
+		},
+		{
+			name:    "cycle",
+			input:   "[x]\n[a : b]\nk = 1\n[b : c]\n[c : a]",
+			wantErr: `f.ini:2:1: section \[a\]: cycle: a -> b -> c -> a`,
+			wantIs:  ast.ErrCycle,
+		},
+		{
+			name:    "self",
+			input:   "[a : a]",
+			wantErr: `f.ini:1:1: section \[a\]: cycle: a -> a`,
+			wantIs:  ast.ErrCycle,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) { run(t, tc) })
+	}
+}
+
+func TestInheritanceLookupCycle(t *testing.T) {
+	parser := ast.NewParser(ast.WithInheritance(ast.ParenInheritance))
+	tree, err := parser.ParseString("", "[a](b)\nx = 1\n[b](c)\n[c](a,b)\ny = 2")
+	qt.Assert(t, qt.IsNil(err))
+
+	qt.Assert(t, qt.Equals(tree.Lookup("b/x").Value, ast.Value(ast.Number{Value: 1})))
+	qt.Assert(t, qt.Equals(tree.Lookup("a/y").Value, ast.Value(ast.Number{Value: 2})))
+	qt.Assert(t, qt.IsNil(tree.Lookup("a/z")))
+}
+
+func TestInheritanceRenameAndRemoveParent(t *testing.T) {
+	parser := ast.NewParser(ast.WithInheritance(ast.ColonInheritance))
+	tree, err := parser.ParseString("", `
+[base]
+a = 1
+[other]
+[child : other : base]
+[grandchild:child]`)
+	qt.Assert(t, qt.IsNil(err))
+
+	qt.Assert(t, qt.IsNil(tree.RenameSection("base", "root")))
+
+	qt.Assert(t, qt.Equals(tree.Lookup("child/a").Value, ast.Value(ast.Number{Value: 1})))
+	qt.Assert(t, qt.Equals(tree.Lookup("grandchild/a").Value, ast.Value(ast.Number{Value: 1})))
+	qt.Assert(t, qt.Equals(tree.String(), `[root]
+a = 1
+[other]
+[child : other : root]
+[grandchild:child]
+`))
+
+	// A parent cannot have a subsection, so the children keep the old name.
+	qt.Assert(t, qt.IsNil(tree.RenameSection("root", "root/sub")))
+	qt.Assert(t, qt.DeepEquals(tree.LookupSection("child").Parents, []string{"other", "root"}))
+	qt.Assert(t, qt.IsNil(tree.Lookup("child/a")))
+
+	_, outcome := tree.RemoveSection("other")
+	qt.Assert(t, qt.Equals(outcome, ast.Removed))
+	qt.Assert(t, qt.DeepEquals(tree.LookupSection("child").Parents, []string{"other", "root"}))
+	qt.Assert(t, qt.ErrorIs(tree.CheckInheritance(), ast.ErrNotFound))
+}
+
+func TestInheritanceDecoder(t *testing.T) {
+	parser := ast.NewParser(ast.WithInheritance(ast.ParenInheritance))
+	dec := parser.NewDecoder("", strings.NewReader("[a](!)\n[b](a)\n"))
+
+	var have []string
+	for {
+		ev, err := dec.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		qt.Assert(t, qt.IsNil(err))
+		have = append(have, ev.Section+" "+ev.Text)
+	}
+	qt.Assert(t, qt.DeepEquals(have, []string{"a (!)", "b (a)"}))
+}
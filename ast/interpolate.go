@@ -0,0 +1,357 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrCycle is returned when a reference, or the chain of parents of a section,
+// leads back to itself.
+var ErrCycle = errors.New("cycle")
+
+// InterpolationSyntax is the syntax of the references to other values in a
+// value, as resolved by an [Interpolator].
+type InterpolationSyntax int
+
+const (
+	// PercentSyntax is the syntax of the BasicInterpolation of Python
+	// configparser: "%(key)s" is the value of key in the same section, and
+	// "%%" is a literal "%". Any other "%" is an error.
+	PercentSyntax InterpolationSyntax = iota
+	// DollarSyntax is the syntax of the ExtendedInterpolation of Python
+	// configparser, also used by PHP: "${key}" is the value of key in the
+	// same section, "${section:key}" is the value of key in section, where
+	// section has the syntax described in [SectionName] and is empty for the
+	// global section, and "$$" is a literal "$". Any other "$" is an error.
+	DollarSyntax
+	// EnvSyntax is the syntax of Mercurial and of the shell: "$NAME" and
+	// "${NAME}" are the value of environment variable NAME. Any other "$" is
+	// literal.
+	EnvSyntax
+)
+
+// InterpolationOption configures an [Interpolator].
+type InterpolationOption func(*interpolationConfig)
+
+type interpolationConfig struct {
+	syntax InterpolationSyntax
+	env    func(name string) (string, bool)
+}
+
+// WithInterpolationSyntax sets the syntax of the references. The default is
+// PercentSyntax.
+func WithInterpolationSyntax(syntax InterpolationSyntax) InterpolationOption {
+	return func(cfg *interpolationConfig) {
+		cfg.syntax = syntax
+	}
+}
+
+// WithEnvironment sets the function that returns the value of an environment
+// variable, such as [os.LookupEnv]. With EnvSyntax, it resolves all the
+// references; with the other syntaxes, it resolves the references to a key,
+// without a section, that doesn't exist in the AST, as PHP does. By default
+// there is no environment.
+func WithEnvironment(lookup func(name string) (string, bool)) InterpolationOption {
+	return func(cfg *interpolationConfig) {
+		cfg.env = lookup
+	}
+}
+
+// InterpolationError is an error in the resolution of a reference.
+type InterpolationError struct {
+	Pos  Position // position of the key whose value contains the reference
+	Path Path     // path of the key whose value contains the reference
+	Ref  string   // the reference, such as "%(name)s"
+	// Err is [ErrNotFound] if the reference doesn't resolve, an error
+	// wrapping [ErrCycle] if it leads back to the key, or a syntax error.
+	Err error
+}
+
+func (e *InterpolationError) Error() string {
+	var bld strings.Builder
+	if e.Pos.Line > 0 {
+		bld.WriteString(e.Pos.String() + ": ")
+	} else {
+		bld.WriteString("ast: ")
+	}
+	if e.Path != "" {
+		fmt.Fprintf(&bld, "key %q: ", e.Path)
+	}
+	fmt.Fprintf(&bld, "reference %q: %v", e.Ref, e.Err)
+	return bld.String()
+}
+
+func (e *InterpolationError) Unwrap() error {
+	return e.Err
+}
+
+// Interpolator resolves the references to other values, and to environment
+// variables, in the values of an AST.
+//
+// Interpolation happens only when reading a value with an Interpolator: the
+// values stored in the AST keep their references, so that encoding the AST
+// writes them back unchanged.
+//
+// Keys and sections are matched as with [AST.Lookup], so that a reference to
+// a key that a section doesn't have falls back to the defaults section, if
+// any (see [AST.SetDefaultsSection]). A reference is resolved recursively, in
+// the context of the section where the interpolation started: if s1/a
+// inherits "%(b)s" from the defaults section, b is looked up in s1 first, as
+// Python configparser does. The values of the environment are not
+// interpolated.
+type Interpolator struct {
+	tree *AST
+	cfg  interpolationConfig
+}
+
+// NewInterpolator returns an Interpolator of the values of tree, configured by
+// opts.
+func NewInterpolator(tree *AST, opts ...InterpolationOption) *Interpolator {
+	in := &Interpolator{tree: tree}
+	for _, opt := range opts {
+		opt(&in.cfg)
+	}
+	return in
+}
+
+// Get returns the value of keyPath, as returned by [AST.Lookup], as text,
+// with its references resolved. If keyPath doesn't exist, Get returns an
+// error wrapping [ErrNotFound]. If a reference cannot be resolved, Get returns
+// an *[InterpolationError].
+func (in *Interpolator) Get(keyPath Path) (string, error) {
+	section, subsection, key := keyPath.split()
+	sec, prop := in.lookup(section, subsection, key)
+	if prop == nil {
+		return "", fmt.Errorf("ast: key %q: %w", keyPath, ErrNotFound)
+	}
+	st := interpolation{cache: make(map[frame]string)}
+	return in.value(&st, frame{sec, prop})
+}
+
+// Expand returns text with its references resolved, in the context of section
+// secName, which has the syntax described in [SectionName] and is "" for the
+// global section.
+func (in *Interpolator) Expand(secName, text string) (string, error) {
+	sec, ok := in.tree.findSection(splitSectionName(secName))
+	if !ok {
+		return "", fmt.Errorf("ast: section %q: %w", secName, ErrNotFound)
+	}
+	st := interpolation{cache: make(map[frame]string)}
+	return in.expand(&st, sec, text, frame{})
+}
+
+// frame is a property read in the context of a section, which is not the
+// section of the property if the property comes from the defaults section.
+type frame struct {
+	sec  *Section // nil for the global section
+	prop *Property
+}
+
+func (f frame) path() Path {
+	return pathOf(f.sec, f.prop)
+}
+
+// interpolation is the state of a call of Get or Expand.
+type interpolation struct {
+	stack []frame
+	cache map[frame]string
+}
+
+// lookup returns section [section "subsection"] (nil for the global section)
+// and its key, as [AST.Lookup] does. It returns a nil section and property if
+// the section doesn't exist.
+func (in *Interpolator) lookup(section, subsection, key string) (*Section, *Property) {
+	sec, ok := in.tree.findSection(section, subsection)
+	if !ok {
+		return nil, nil
+	}
+	return sec, in.tree.lookupIn(sec, key)
+}
+
+// value returns the interpolated value of f.
+func (in *Interpolator) value(st *interpolation, f frame) (string, error) {
+	if val, ok := st.cache[f]; ok {
+		return val, nil
+	}
+	var text string
+	switch val := f.prop.Value.(type) {
+	case String:
+		text = val.Value
+	case Raw:
+		text = val.Value
+	case Number:
+		return val.String(), nil
+	}
+	st.stack = append(st.stack, f)
+	defer func() { st.stack = st.stack[:len(st.stack)-1] }()
+	val, err := in.expand(st, f.sec, text, f)
+	if err != nil {
+		return "", err
+	}
+	st.cache[f] = val
+	return val, nil
+}
+
+// expand returns text with its references resolved in the context of section
+// sec. Text is the value of at, if at.prop is not nil.
+func (in *Interpolator) expand(st *interpolation, sec *Section, text string, at frame) (string, error) {
+	fail := func(ref string, err error) error {
+		e := &InterpolationError{Ref: ref, Err: err}
+		if at.prop != nil {
+			e.Pos = at.prop.Pos
+			e.Path = at.path()
+		}
+		return e
+	}
+
+	marker := byte('$')
+	if in.cfg.syntax == PercentSyntax {
+		marker = '%'
+	}
+	var bld strings.Builder
+	for {
+		i := strings.IndexByte(text, marker)
+		if i < 0 {
+			bld.WriteString(text)
+			return bld.String(), nil
+		}
+		bld.WriteString(text[:i])
+		text = text[i:]
+
+		ref, n := in.parseReference(text)
+		if n == 0 {
+			return "", fail(text, errors.New("invalid syntax"))
+		}
+		text = text[n:]
+		if ref.literal != "" {
+			bld.WriteString(ref.literal)
+			continue
+		}
+
+		val, err := in.resolve(st, sec, ref)
+		if err != nil {
+			var ie *InterpolationError
+			if errors.As(err, &ie) {
+				return "", err
+			}
+			return "", fail(ref.text, err)
+		}
+		bld.WriteString(val)
+	}
+}
+
+// reference is a reference in a value.
+type reference struct {
+	text       string // the reference as written, such as "%(name)s"
+	literal    string // the text of an escape, such as "%" for "%%"
+	name       string // the key or the environment variable
+	section    string // the section of the key, if hasSection
+	hasSection bool
+	env        bool // name is an environment variable
+}
+
+// parseReference parses the reference at the start of text, which starts with
+// the marker of the syntax, and returns it with its length, or 0 if it is
+// invalid.
+func (in *Interpolator) parseReference(text string) (reference, int) {
+	switch in.cfg.syntax {
+	case PercentSyntax:
+		if strings.HasPrefix(text, "%%") {
+			return reference{literal: "%"}, 2
+		}
+		if !strings.HasPrefix(text, "%(") {
+			return reference{}, 0
+		}
+		end := strings.IndexByte(text, ')')
+		if end <= 2 || !strings.HasPrefix(text[end:], ")s") {
+			return reference{}, 0
+		}
+		return reference{text: text[:end+2], name: text[2:end]}, end + 2
+	case DollarSyntax:
+		if strings.HasPrefix(text, "$$") {
+			return reference{literal: "$"}, 2
+		}
+		if !strings.HasPrefix(text, "${") {
+			return reference{}, 0
+		}
+		end := strings.IndexByte(text, '}')
+		if end <= 2 {
+			return reference{}, 0
+		}
+		ref := reference{text: text[:end+1], name: text[2:end]}
+		if section, key, ok := strings.Cut(ref.name, ":"); ok {
+			if key == "" {
+				return reference{}, 0
+			}
+			ref.section, ref.name, ref.hasSection = section, key, true
+		}
+		return ref, end + 1
+	default: // EnvSyntax
+		if strings.HasPrefix(text, "${") {
+			if end := strings.IndexByte(text, '}'); end > 2 {
+				return reference{text: text[:end+1], name: text[2:end], env: true}, end + 1
+			}
+		}
+		n := 1
+		for n < len(text) && isEnvChar(text[n], n == 1) {
+			n++
+		}
+		if n == 1 {
+			return reference{literal: "$"}, 1
+		}
+		return reference{text: text[:n], name: text[1:n], env: true}, n
+	}
+}
+
+// isEnvChar reports whether c can be part of the name of an environment
+// variable in a "$NAME" reference.
+func isEnvChar(c byte, first bool) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' ||
+		!first && '0' <= c && c <= '9'
+}
+
+// resolve returns the value of ref in the context of section sec.
+func (in *Interpolator) resolve(st *interpolation, sec *Section, ref reference) (string, error) {
+	lookupEnv := func() (string, error) {
+		if in.cfg.env != nil {
+			if val, ok := in.cfg.env(ref.name); ok {
+				return val, nil
+			}
+		}
+		return "", ErrNotFound
+	}
+	if ref.env {
+		return lookupEnv()
+	}
+
+	if ref.hasSection {
+		var ok bool
+		if sec, ok = in.tree.findSection(splitSectionName(ref.section)); !ok {
+			return "", ErrNotFound
+		}
+	}
+	prop := in.tree.lookupIn(sec, ref.name)
+	if prop == nil {
+		if ref.hasSection {
+			return "", ErrNotFound
+		}
+		return lookupEnv()
+	}
+
+	target := frame{sec, prop}
+	for i, f := range st.stack {
+		if f == target {
+			var chain []string
+			for _, f := range st.stack[i:] {
+				chain = append(chain, string(f.path()))
+			}
+			chain = append(chain, string(target.path()))
+			return "", fmt.Errorf("%w: %s", ErrCycle, strings.Join(chain, " -> "))
+		}
+	}
+	return in.value(st, target)
+}
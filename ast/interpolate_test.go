@@ -0,0 +1,219 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+
+	"github.com/marco-m/roundtrip_ini/ast"
+)
+
+func TestInterpolatorGet(t *testing.T) {
+	type testCase struct {
+		name     string
+		input    string
+		defaults *string // defaults section, if not nil
+		opts     []ast.InterpolationOption
+		path     ast.Path
+		want     string
+		wantErr  string
+	}
+
+	ptr := func(s string) *string { return &s }
+	env := map[string]string{"HOME": "/home/me", "USER": "me"}
+	lookupEnv := func(name string) (string, bool) {
+		val, ok := env[name]
+		return val, ok
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		tree := parse(t, tc.input)
+		if tc.defaults != nil {
+			tree.SetDefaultsSection(*tc.defaults)
+		}
+		before := tree.String()
+
+		have, err := ast.NewInterpolator(tree, tc.opts...).Get(tc.path)
+
+		if tc.wantErr != "" {
+			qt.Assert(t, qt.ErrorMatches(err, tc.wantErr))
+		} else {
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.Equals(have, tc.want))
+		}
+		qt.Assert(t, qt.Equals(tree.String(), before))
+	}
+
+	testCases := []testCase{
+		{
+			name:  "percent",
+			input: "[s1]\ndir = \"/opt\"\nbin = \"%(dir)s/bin\"\nlib = \"%(bin)s/../lib %% 100%%\"",
+			path:  "s1/lib",
+			want:  "/opt/bin/../lib % 100%",
+		},
+		{
+			name:  "number",
+			input: "[s1]\nport = 8080\nurl = \"http://localhost:%(port)s/\"",
+			path:  "s1/url",
+			want:  "http://localhost:8080/",
+		},
+		{
+			name:  "no references",
+			input: "a = \"${x}\"",
+			path:  "a",
+			want:  "${x}",
+		},
+		{
+			name: "defaults section",
+			input: `
+[DEFAULT]
+home = "/home"
+dir = "%(home)s/%(user)s"
+[s1]
+user = "me"
+[s2]
+user = "you"
+home = "/usr/home"`,
+			defaults: ptr("DEFAULT"),
+			path:     "s2/dir",
+			want:     "/usr/home/you",
+		},
+		{
+			name:     "global defaults",
+			input:    "home = \"/home\"\n[s1]\ndir = \"%(home)s/x\"",
+			defaults: ptr(""),
+			path:     "s1/dir",
+			want:     "/home/x",
+		},
+		{
+			name:  "dollar",
+			input: "root = \"/srv\"\n[s1]\nname = \"app\"\n[remote \"origin\"]\ndir = \"${:root}/${s1:name}/${name} $$5\"\nname = \"x\"",
+			opts:  []ast.InterpolationOption{ast.WithInterpolationSyntax(ast.DollarSyntax)},
+			path:  "remote/origin/dir",
+			want:  "/srv/app/x $5",
+		},
+		{
+			name:  "dollar with subsection",
+			input: "[remote \"origin\"]\nurl = \"u\"\n[s1]\na = \"${remote/origin:url}\"",
+			opts:  []ast.InterpolationOption{ast.WithInterpolationSyntax(ast.DollarSyntax)},
+			path:  "s1/a",
+			want:  "u",
+		},
+		{
+			name:  "dollar falls back to the environment",
+			input: "[s1]\nUSER = \"root\"\ndir = \"${HOME}/${USER}\"",
+			opts: []ast.InterpolationOption{
+				ast.WithInterpolationSyntax(ast.DollarSyntax),
+				ast.WithEnvironment(lookupEnv),
+			},
+			path: "s1/dir",
+			want: "/home/me/root",
+		},
+		{
+			name:  "env",
+			input: "a = \"$HOME/${USER}x $5 $\"",
+			opts: []ast.InterpolationOption{
+				ast.WithInterpolationSyntax(ast.EnvSyntax),
+				ast.WithEnvironment(lookupEnv),
+			},
+			path: "a",
+			want: "/home/me/mex $5 $",
+		},
+		{
+			name:    "missing key",
+			input:   "[s1]\nx = 1",
+			path:    "s1/a",
+			wantErr: `ast: key "s1/a": not found`,
+		},
+		{
+			name:    "unresolved reference",
+			input:   "[s1]\na = \"%(b)s\"\nb = \"x%(c)s\"",
+			path:    "s1/a",
+			wantErr: `3:1: key "s1/b": reference "%\(c\)s": not found`,
+		},
+		{
+			name:    "unresolved section",
+			input:   "[s1]\na = \"${s2:b}\"",
+			opts:    []ast.InterpolationOption{ast.WithInterpolationSyntax(ast.DollarSyntax)},
+			path:    "s1/a",
+			wantErr: `2:1: key "s1/a": reference "\${s2:b}": not found`,
+		},
+		{
+			name:    "unresolved environment variable",
+			input:   "a = \"$NOPE\"",
+			opts:    []ast.InterpolationOption{ast.WithInterpolationSyntax(ast.EnvSyntax)},
+			path:    "a",
+			wantErr: `1:1: key "a": reference "\$NOPE": not found`,
+		},
+		{
+			name:    "cycle",
+			input:   "[s1]\na = \"%(b)s\"\nb = \"%(c)s\"\nc = \"%(a)s\"",
+			path:    "s1/a",
+			wantErr: `4:1: key "s1/c": reference "%\(a\)s": cycle: s1/a -> s1/b -> s1/c -> s1/a`,
+		},
+		{
+			name:    "self reference",
+			input:   "a = \"%(a)s\"",
+			path:    "a",
+			wantErr: `1:1: key "a": reference "%\(a\)s": cycle: a -> a`,
+		},
+		{
+			name:    "invalid percent",
+			input:   "a = \"100%\"",
+			path:    "a",
+			wantErr: `1:1: key "a": reference "%": invalid syntax`,
+		},
+		{
+			name:    "unterminated percent",
+			input:   "a = \"%(b)\"",
+			path:    "a",
+			wantErr: `1:1: key "a": reference "%\(b\)": invalid syntax`,
+		},
+		{
+			name:    "invalid dollar",
+			input:   "a = \"$x ${}\"",
+			opts:    []ast.InterpolationOption{ast.WithInterpolationSyntax(ast.DollarSyntax)},
+			path:    "a",
+			wantErr: `1:1: key "a": reference "\$x \${}": invalid syntax`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) { run(t, tc) })
+	}
+}
+
+func TestInterpolatorErrors(t *testing.T) {
+	tree := parse(t, "[s1]\na = \"%(b)s\"\nb = \"%(a)s\"\nc = \"%(d)s\"")
+	in := ast.NewInterpolator(tree)
+
+	_, err := in.Get("s1/a")
+	var ie *ast.InterpolationError
+	qt.Assert(t, qt.IsTrue(errors.As(err, &ie)))
+	qt.Assert(t, qt.Equals(ie.Pos.Line, 3))
+	qt.Assert(t, qt.Equals(ie.Path, ast.Path("s1/b")))
+	qt.Assert(t, qt.Equals(ie.Ref, "%(a)s"))
+	qt.Assert(t, qt.IsTrue(errors.Is(err, ast.ErrCycle)))
+
+	_, err = in.Get("s1/c")
+	qt.Assert(t, qt.IsTrue(errors.Is(err, ast.ErrNotFound)))
+}
+
+func TestInterpolatorExpand(t *testing.T) {
+	tree := parse(t, "[Server]\nHost = \"example.com\"\nPort = 80")
+	tree.SetCaseInsensitive(true)
+	in := ast.NewInterpolator(tree, ast.WithInterpolationSyntax(ast.DollarSyntax))
+
+	have, err := in.Expand("server", "http://${host}:${SERVER:port}/")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(have, "http://example.com:80/"))
+
+	_, err = in.Expand("server", "${missing}")
+	qt.Assert(t, qt.ErrorMatches(err, `ast: reference "\${missing}": not found`))
+	_, err = in.Expand("nope", "x")
+	qt.Assert(t, qt.ErrorMatches(err, `ast: section "nope": not found`))
+}
@@ -0,0 +1,101 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast
+
+import (
+	"iter"
+	"slices"
+)
+
+// AllSections returns an iterator over the sections of tree, in document
+// order. The global section, which is not a [Section], is not included.
+//
+// See [AST.AllProperties] for what happens if tree is modified during the
+// iteration.
+func (tree *AST) AllSections() iter.Seq[*Section] {
+	return func(yield func(*Section) bool) {
+		for _, sec := range slices.Clone(tree.Sections) {
+			if !yield(sec) {
+				return
+			}
+		}
+	}
+}
+
+// AllProperties returns an iterator over the properties of tree and their
+// paths, in document order: first the properties of the global section, then
+// the properties of each section.
+//
+// The iteration visits the properties present when it starts, in their
+// original order, also if tree is modified during the iteration: a property
+// removed in the meantime is still visited and a property added in the
+// meantime is not. The Path of a property is built from the names at the time
+// the property is visited.
+func (tree *AST) AllProperties() iter.Seq2[Path, *Property] {
+	return func(yield func(Path, *Property) bool) {
+		n := len(tree.Properties)
+		for _, sec := range tree.Sections {
+			n += len(sec.Properties)
+		}
+		type entry struct {
+			sec  *Section // nil for the global section
+			prop *Property
+		}
+		entries := make([]entry, 0, n)
+		for _, prop := range tree.Properties {
+			entries = append(entries, entry{prop: prop})
+		}
+		for _, sec := range tree.Sections {
+			for _, prop := range sec.Properties {
+				entries = append(entries, entry{sec: sec, prop: prop})
+			}
+		}
+
+		for _, e := range entries {
+			if !yield(pathOf(e.sec, e.prop), e.prop) {
+				return
+			}
+		}
+	}
+}
+
+// GlobalProperties returns an iterator over the properties of the global
+// section of tree and their paths, in document order.
+//
+// See [AST.AllProperties] for what happens if tree is modified during the
+// iteration.
+func (tree *AST) GlobalProperties() iter.Seq2[Path, *Property] {
+	return func(yield func(Path, *Property) bool) {
+		yieldProperties(nil, slices.Clone(tree.Properties), yield)
+	}
+}
+
+// AllProperties returns an iterator over the properties of sec and their
+// paths, in document order.
+//
+// See [AST.AllProperties] for what happens if sec is modified during the
+// iteration.
+func (sec *Section) AllProperties() iter.Seq2[Path, *Property] {
+	return func(yield func(Path, *Property) bool) {
+		yieldProperties(sec, slices.Clone(sec.Properties), yield)
+	}
+}
+
+// yieldProperties yields props, the properties of sec (nil for the global
+// section), until yield returns false.
+func yieldProperties(sec *Section, props []*Property, yield func(Path, *Property) bool) {
+	for _, prop := range props {
+		if !yield(pathOf(sec, prop), prop) {
+			return
+		}
+	}
+}
+
+// pathOf returns the path of prop in sec (nil for the global section).
+func pathOf(sec *Section, prop *Property) Path {
+	if sec == nil {
+		return NewPath("", prop.Key)
+	}
+	return NewSubsectionPath(sec.Name, sec.Subsection, prop.Key)
+}
@@ -0,0 +1,115 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast_test
+
+import (
+	"testing"
+
+	"github.com/go-quicktest/qt"
+
+	"github.com/marco-m/roundtrip_ini/ast"
+)
+
+const iterInput = `
+a = 1
+b = 2
+[s1]
+c = 3
+[remote "origin"]
+url = "x"
+[s3]`
+
+func TestAllSections(t *testing.T) {
+	tree := parse(t, iterInput)
+
+	var have []string
+	for sec := range tree.AllSections() {
+		have = append(have, sec.Name)
+	}
+
+	qt.Assert(t, qt.DeepEquals(have, []string{"s1", "remote", "s3"}))
+}
+
+func TestAllProperties(t *testing.T) {
+	tree := parse(t, iterInput)
+
+	var have []ast.Path
+	for path, prop := range tree.AllProperties() {
+		qt.Assert(t, qt.Equals(tree.Lookup(path), prop))
+		have = append(have, path)
+	}
+
+	qt.Assert(t, qt.DeepEquals(have, []ast.Path{"a", "b", "s1/c", "remote/origin/url"}))
+}
+
+func TestSectionProperties(t *testing.T) {
+	tree := parse(t, iterInput)
+
+	var global, s1 []ast.Path
+	for path := range tree.GlobalProperties() {
+		global = append(global, path)
+	}
+	for path := range tree.LookupSection("s1").AllProperties() {
+		s1 = append(s1, path)
+	}
+
+	qt.Assert(t, qt.DeepEquals(global, []ast.Path{"a", "b"}))
+	qt.Assert(t, qt.DeepEquals(s1, []ast.Path{"s1/c"}))
+}
+
+func TestIteratorsStopEarly(t *testing.T) {
+	tree := parse(t, iterInput)
+
+	n := 0
+	for range tree.AllProperties() {
+		n++
+		if n == 2 {
+			break
+		}
+	}
+	qt.Assert(t, qt.Equals(n, 2))
+
+	n = 0
+	for range tree.AllSections() {
+		n++
+		break
+	}
+	qt.Assert(t, qt.Equals(n, 1))
+}
+
+func TestIteratorsModifyDuringIteration(t *testing.T) {
+	tree := parse(t, iterInput)
+
+	var have []ast.Path
+	for path := range tree.AllProperties() {
+		have = append(have, path)
+		// Removing and adding do not change what is visited.
+		tree.Remove(path)
+		tree.Add(path+"_new", ast.Number{Value: 0})
+	}
+	qt.Assert(t, qt.DeepEquals(have, []ast.Path{"a", "b", "s1/c", "remote/origin/url"}))
+
+	var sections []string
+	for sec := range tree.AllSections() {
+		sections = append(sections, sec.Name)
+		tree.RemoveSection(ast.SectionName(sec.Name, sec.Subsection))
+		tree.Add("new/x", ast.Number{Value: 0})
+	}
+	qt.Assert(t, qt.DeepEquals(sections, []string{"s1", "remote", "s3"}))
+	qt.Assert(t, qt.Equals(tree.String(), "a_new = 0\nb_new = 0\n[new]\nx = 0\n"))
+}
+
+func TestIteratorPathUsesCurrentNames(t *testing.T) {
+	tree := parse(t, iterInput)
+
+	var have []ast.Path
+	for path := range tree.AllProperties() {
+		if path == "a" {
+			qt.Assert(t, qt.IsNil(tree.RenameSection("s1", "renamed")))
+		}
+		have = append(have, path)
+	}
+
+	qt.Assert(t, qt.DeepEquals(have, []ast.Path{"a", "b", "renamed/c", "remote/origin/url"}))
+}
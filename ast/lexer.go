@@ -0,0 +1,473 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Position is a location in the input.
+type Position struct {
+	Filename string
+	Offset   int // byte offset, starting at 0
+	Line     int // line number, starting at 1
+	Column   int // column number (in runes), starting at 1
+}
+
+// String returns the position in the format "filename:line:column", or
+// "line:column" if Filename is empty.
+func (pos Position) String() string {
+	if pos.Filename == "" {
+		return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", pos.Filename, pos.Line, pos.Column)
+}
+
+// Error is a decoding error, with the position in the input where it occurred.
+type Error struct {
+	Pos Position
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Msg
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNewLine
+	tokIdent
+	tokString
+	tokNumber
+	tokLBracket
+	tokRBracket
+	tokAssign
+	tokComment
+	tokRaw
+	tokInherit
+)
+
+func (kind tokenKind) String() string {
+	switch kind {
+	case tokEOF:
+		return "end of file"
+	case tokNewLine:
+		return "newline"
+	case tokIdent:
+		return "identifier"
+	case tokString:
+		return "string"
+	case tokNumber:
+		return "number"
+	case tokLBracket:
+		return `"["`
+	case tokRBracket:
+		return `"]"`
+	case tokAssign:
+		return `"="`
+	case tokComment:
+		return "comment"
+	case tokRaw:
+		return "value"
+	case tokInherit:
+		return "inheritance clause"
+	default:
+		return fmt.Sprintf("tokenKind(%d)", int(kind))
+	}
+}
+
+// token is a lexical token. For tokString, text is the unquoted value; for
+// all the other kinds, text is the input text.
+type token struct {
+	kind tokenKind
+	text string
+	pos  Position
+}
+
+// lexer splits the input into tokens. It reads the input in chunks, so it
+// never needs to hold more than a chunk and the current token in memory.
+type lexer struct {
+	cfg   *config
+	rd    io.Reader
+	chunk []byte    // input read so far and not yet consumed is chunk[off:]
+	off   int       // offset of the next byte in chunk
+	nRead int64     // bytes read from rd
+	err   error     // sticky read error
+	pos   Position  // position of the next byte
+	buf   []byte    // text of the token being scanned, reused across tokens
+	prev  tokenKind // kind of the previous token, for context
+	// space is the blank text before the token being scanned, kept in the
+	// text of an inheritance clause. If keepSpace is set, the blanks at the
+	// end of a section name are kept for the next token.
+	space     []byte
+	keepSpace bool
+	// inHeader reports whether the lexer is between the brackets of a
+	// section header, for context.
+	inHeader bool
+}
+
+const chunkSize = 32 * 1024
+
+func newLexer(filename string, r io.Reader, cfg *config) *lexer {
+	return &lexer{
+		cfg:  cfg,
+		rd:   r,
+		pos:  Position{Filename: filename, Line: 1, Column: 1},
+		prev: tokNewLine,
+	}
+}
+
+// fill reads the next chunk of input. It returns false at the end of the input
+// or on read error, in which case lx.err is set.
+func (lx *lexer) fill() bool {
+	if lx.err != nil {
+		return false
+	}
+	if lx.chunk == nil {
+		lx.chunk = make([]byte, chunkSize)
+	}
+	for {
+		n, err := lx.rd.Read(lx.chunk[:cap(lx.chunk)])
+		lx.nRead += int64(n)
+		if max := lx.cfg.maxInputSize; max > 0 && lx.nRead > max {
+			n -= int(lx.nRead - max)
+			err = fmt.Errorf("%w: more than %d bytes", ErrInputTooLarge, max)
+		}
+		lx.chunk = lx.chunk[:n]
+		lx.off = 0
+		if err != nil {
+			lx.err = err
+		}
+		if n > 0 {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
+
+// readByte returns the next byte, advancing the position.
+// At the end of the input, readByte returns io.EOF.
+func (lx *lexer) readByte() (byte, error) {
+	c, err := lx.peekByte()
+	if err != nil {
+		return 0, err
+	}
+	lx.off++
+	lx.pos.Offset++
+	switch {
+	case c == '\n':
+		lx.pos.Line++
+		lx.pos.Column = 1
+	case c&0xC0 != 0x80: // not a UTF-8 continuation byte
+		lx.pos.Column++
+	}
+	return c, nil
+}
+
+// peekByte returns the next byte without consuming it.
+// At the end of the input, peekByte returns io.EOF.
+func (lx *lexer) peekByte() (byte, error) {
+	if lx.off >= len(lx.chunk) && !lx.fill() {
+		return 0, lx.err
+	}
+	return lx.chunk[lx.off], nil
+}
+
+// next returns the next token, skipping whitespace.
+func (lx *lexer) next() (token, error) {
+	tok, err := lx.scan()
+	if err != nil {
+		return token{}, err
+	}
+	lx.prev = tok.kind
+	switch tok.kind {
+	case tokLBracket:
+		lx.inHeader = true
+	case tokRBracket, tokNewLine:
+		lx.inHeader = false
+	}
+	return tok, nil
+}
+
+// scan returns the next token. The lexer is mostly context-free, but it uses
+// the previous token to recognize raw values and non-strict names.
+func (lx *lexer) scan() (token, error) {
+	cfg := lx.cfg
+	if lx.prev == tokAssign && cfg.valueTypes&RawValues != 0 {
+		return lx.scanRaw()
+	}
+	if !lx.keepSpace {
+		lx.space = lx.space[:0]
+	}
+	lx.keepSpace = false
+	for {
+		c, err := lx.peekByte()
+		if errors.Is(err, io.EOF) {
+			return token{kind: tokEOF, pos: lx.pos}, nil
+		}
+		if err != nil {
+			return token{}, err
+		}
+
+		pos := lx.pos
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' && !cfg.strict:
+			lx.readByte()
+			lx.space = append(lx.space, c)
+			continue
+		case c == '\n':
+			lx.readByte()
+			return token{kind: tokNewLine, text: "\n", pos: pos}, nil
+		case c == '[':
+			lx.readByte()
+			return token{kind: tokLBracket, text: "[", pos: pos}, nil
+		case c == ']':
+			lx.readByte()
+			return token{kind: tokRBracket, text: "]", pos: pos}, nil
+		case !cfg.strict && lx.prev == tokLBracket:
+			return lx.scanName(pos)
+		case c == ':' && lx.inHeader && cfg.inheritance == ColonInheritance:
+			return lx.scanClause(pos)
+		case c == '(' && lx.prev == tokRBracket && cfg.inheritance == ParenInheritance:
+			return lx.scanClause(pos)
+		case cfg.isSeparator[c]:
+			lx.readByte()
+			i := strings.IndexByte(cfg.separators, c)
+			return token{kind: tokAssign, text: cfg.separators[i : i+1], pos: pos}, nil
+		case cfg.isComment[c]:
+			return lx.scanComment(pos)
+		case !cfg.strict && lx.prev != tokAssign && !lx.inHeader:
+			return lx.scanKey(pos)
+		case c == '"':
+			return lx.scanString(pos)
+		case isDigit(c):
+			return lx.scanNumber(pos)
+		case isLetter(c):
+			return lx.scanIdent(pos)
+		default:
+			return token{}, &Error{Pos: pos, Msg: fmt.Sprintf("invalid input text %q", string(c))}
+		}
+	}
+}
+
+// scanWhile appends to lx.buf the bytes that satisfy accept.
+func (lx *lexer) scanWhile(accept func(byte) bool) error {
+	for {
+		c, err := lx.peekByte()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !accept(c) {
+			return nil
+		}
+		lx.readByte()
+		lx.buf = append(lx.buf, c)
+	}
+}
+
+// scanComment scans `[#;][^\n]*`. The newline is not part of the comment.
+func (lx *lexer) scanComment(pos Position) (token, error) {
+	lx.buf = lx.buf[:0]
+	if err := lx.scanWhile(notNewLine); err != nil {
+		return token{}, err
+	}
+	text := lx.buf
+	if !lx.cfg.strict {
+		text = bytes.TrimSuffix(text, []byte("\r"))
+	}
+	return token{kind: tokComment, text: string(text), pos: pos}, nil
+}
+
+// scanKey scans a non-strict key, up to a separator or the end of the line.
+func (lx *lexer) scanKey(pos Position) (token, error) {
+	lx.buf = lx.buf[:0]
+	err := lx.scanWhile(func(c byte) bool {
+		return c != '\n' && !lx.cfg.isSeparator[c]
+	})
+	if err != nil {
+		return token{}, err
+	}
+	return token{kind: tokIdent, text: string(trimSpace(lx.buf)), pos: pos}, nil
+}
+
+// scanName scans a non-strict section name, up to "]", the opening quote of a
+// subsection, the colon of an inheritance clause or the end of the line.
+func (lx *lexer) scanName(pos Position) (token, error) {
+	lx.buf = lx.buf[:0]
+	colon := lx.cfg.inheritance == ColonInheritance
+	err := lx.scanWhile(func(c byte) bool {
+		return c != '\n' && c != ']' && c != '"' && !(colon && c == ':')
+	})
+	if err != nil {
+		return token{}, err
+	}
+	name := trimSpace(lx.buf)
+	if colon {
+		end := len(bytes.TrimRight(lx.buf, " \t\r"))
+		lx.space = append(lx.space[:0], lx.buf[end:]...)
+		lx.keepSpace = true
+	}
+	return token{kind: tokIdent, text: string(name), pos: pos}, nil
+}
+
+// scanClause scans an inheritance clause: `:[^\]\n]*` before the "]" of a
+// section header, or `\([^)\n]*\)` after it. The text of the token keeps the
+// blanks before and inside the clause, so that the encoder can write the
+// header back unchanged.
+func (lx *lexer) scanClause(pos Position) (token, error) {
+	lx.buf = append(lx.buf[:0], lx.space...)
+	paren := lx.prev == tokRBracket
+	err := lx.scanWhile(func(c byte) bool {
+		if paren {
+			return c != '\n' && c != ')'
+		}
+		return c != '\n' && c != ']'
+	})
+	if err != nil {
+		return token{}, err
+	}
+	if paren {
+		if c, err := lx.peekByte(); err != nil || c != ')' {
+			return token{}, &Error{Pos: pos, Msg: "unterminated inheritance clause"}
+		}
+		lx.readByte()
+		lx.buf = append(lx.buf, ')')
+	}
+	return token{kind: tokInherit, text: string(lx.buf), pos: pos}, nil
+}
+
+// scanRaw scans a raw value, up to the end of the line. The value can be
+// empty.
+func (lx *lexer) scanRaw() (token, error) {
+	err := lx.scanWhile(func(c byte) bool { return c == ' ' || c == '\t' })
+	if err != nil {
+		return token{}, err
+	}
+	pos := lx.pos
+	lx.buf = lx.buf[:0]
+	if err := lx.scanWhile(notNewLine); err != nil {
+		return token{}, err
+	}
+	return token{kind: tokRaw, text: string(trimSpace(lx.buf)), pos: pos}, nil
+}
+
+// scanIdent scans `[a-zA-Z][a-zA-Z_\d]*`.
+func (lx *lexer) scanIdent(pos Position) (token, error) {
+	lx.buf = lx.buf[:0]
+	err := lx.scanWhile(func(c byte) bool {
+		return isLetter(c) || isDigit(c) || c == '_'
+	})
+	if err != nil {
+		return token{}, err
+	}
+	return token{kind: tokIdent, text: string(lx.buf), pos: pos}, nil
+}
+
+// scanNumber scans `\d+(\.\d+)?`.
+func (lx *lexer) scanNumber(pos Position) (token, error) {
+	lx.buf = lx.buf[:0]
+	if err := lx.scanWhile(isDigit); err != nil {
+		return token{}, err
+	}
+	c, err := lx.peekByte()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return token{}, err
+	}
+	if err == nil && c == '.' {
+		dot := lx.pos
+		lx.readByte()
+		lx.buf = append(lx.buf, '.')
+		n := len(lx.buf)
+		if err := lx.scanWhile(isDigit); err != nil {
+			return token{}, err
+		}
+		if len(lx.buf) == n {
+			return token{}, &Error{Pos: dot, Msg: `invalid input text "."`}
+		}
+	}
+	return token{kind: tokNumber, text: string(lx.buf), pos: pos}, nil
+}
+
+// scanString scans a double-quoted string with Go escape sequences and
+// returns the unquoted value. A string cannot span multiple lines.
+func (lx *lexer) scanString(pos Position) (token, error) {
+	lx.buf = lx.buf[:0]
+	c, _ := lx.readByte() // opening quote
+	lx.buf = append(lx.buf, c)
+	escaped := false
+	for {
+		c, err := lx.readByte()
+		if errors.Is(err, io.EOF) || c == '\n' {
+			return token{}, &Error{Pos: pos, Msg: "unterminated string"}
+		}
+		if err != nil {
+			return token{}, err
+		}
+		lx.buf = append(lx.buf, c)
+		if escaped {
+			escaped = false
+			continue
+		}
+		if c == '\\' {
+			escaped = true
+			continue
+		}
+		if c == '"' {
+			break
+		}
+	}
+	value, err := strconv.Unquote(string(lx.buf))
+	if err != nil {
+		return token{}, &Error{Pos: pos,
+			Msg: fmt.Sprintf("invalid quoted string %q: %v", lx.buf, err)}
+	}
+	return token{kind: tokString, text: value, pos: pos}, nil
+}
+
+func notNewLine(c byte) bool {
+	return c != '\n'
+}
+
+// trimSpace removes leading and trailing spaces, tabs and carriage returns.
+func trimSpace(b []byte) []byte {
+	return bytes.Trim(b, " \t\r")
+}
+
+// isNumber reports whether s matches `\d+(\.\d+)?`.
+func isNumber(s string) bool {
+	intPart, fracPart, hasDot := strings.Cut(s, ".")
+	return allDigits(intPart) && (!hasDot || allDigits(fracPart))
+}
+
+func allDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
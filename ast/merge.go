@@ -0,0 +1,349 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast
+
+import (
+	"fmt"
+	"slices"
+)
+
+// MergeOption configures [Merge3].
+type MergeOption func(*mergeConfig)
+
+type mergeConfig struct {
+	markers bool
+}
+
+// WithConflictMarkers sets whether [Merge3] marks each conflict in the merged
+// AST with comment lines in the style of diff3:
+//
+//	# <<<<<<< ours
+//	# timeout = 30
+//	# ||||||| base
+//	# timeout = 10
+//	# =======
+//	# timeout = 20
+//	# >>>>>>> theirs
+//	timeout = 30
+//
+// The comment lines start with the first comment marker of the parser of
+// ours (see [WithCommentMarkers]), or with "#" if ours was not parsed, so
+// that the merged AST can be parsed again. They are attached to the
+// conflicting property. If ours has removed the property, Merge3 adds it back
+// with the value of theirs, so that it can carry the markers. The default is
+// false.
+func WithConflictMarkers(on bool) MergeOption {
+	return func(cfg *mergeConfig) {
+		cfg.markers = on
+	}
+}
+
+// Conflict is a property changed in different ways by ours and theirs, as
+// returned by [Merge3].
+type Conflict struct {
+	Path Path
+	// Base, Ours and Theirs are the values of the property; nil if the
+	// property doesn't exist.
+	Base, Ours, Theirs Value
+}
+
+// String returns a one-line description of the conflict, such as
+// `s1/a: base 1, ours 2, theirs 3`.
+func (c Conflict) String() string {
+	describe := func(val Value) string {
+		if val == nil {
+			return "absent"
+		}
+		return string(appendValue(nil, val))
+	}
+	return fmt.Sprintf("%s: base %s, ours %s, theirs %s",
+		c.Path, describe(c.Base), describe(c.Ours), describe(c.Theirs))
+}
+
+// Merge3 merges the changes made by ours and by theirs to their common
+// ancestor base, and returns the merged AST and the conflicts. None of the
+// three ASTs is modified. For example, base is the configuration file shipped
+// with the previous version of a package, ours the same file as edited by
+// the user, and theirs the file shipped with the new version.
+//
+// The merge starts from a copy of ours, so the layout, comments and edits of
+// ours are kept, and applies to it the changes from base to theirs, key by
+// key:
+//   - a key added by theirs is inserted after the key that precedes it in
+//     theirs, with its comments; a section added by theirs is inserted after
+//     the section that precedes it in theirs.
+//   - a value changed by theirs replaces the value of ours, if ours didn't
+//     change it.
+//   - a key removed by theirs is removed, if ours didn't change it. A section
+//     removed by theirs is removed if it's left empty.
+//   - the comments of a key or section changed by theirs replace the comments
+//     of ours, if ours didn't change them.
+//
+// A key changed in different ways by ours and theirs, including a key removed
+// by one and changed by the other, is a conflict: the merged AST keeps the
+// value of ours, and the conflict is reported, in document order of theirs
+// and then of base. Changes to comments are never conflicts: the comments of
+// ours win. See [WithConflictMarkers] to mark the conflicts in the merged AST.
+//
+// Keys and sections are matched by name, as [AST.LookupOwn] does; of
+// duplicate keys or sections, only the first one takes part in the merge. The
+// keys inherited from a defaults section (see [AST.SetDefaultsSection]) are
+// ignored: each section is merged with its own keys.
+func Merge3(base, ours, theirs *AST, opts ...MergeOption) (*AST, []Conflict) {
+	m := merger{base: base, theirs: theirs, result: ours.Clone()}
+	for _, opt := range opts {
+		opt(&m.cfg)
+	}
+
+	m.properties(nil, nil, nil, base.Properties, theirs.Properties)
+
+	prev := -1 // position in result of the last section of theirs found
+	for _, sec := range theirs.Sections {
+		name := sec.name()
+		if theirs.LookupSection(name) != sec {
+			continue // duplicate
+		}
+		baseSec := base.LookupSection(name)
+		ourSec := m.result.LookupSection(name)
+		switch {
+		case ourSec != nil:
+			prev = position(m.result.Sections, ourSec)
+			if baseSec != nil {
+				m.comments(&ourSec.Comments, baseSec.Comments, sec.Comments)
+				m.properties(ourSec, baseSec, sec, baseSec.Properties, sec.Properties)
+			} else {
+				m.properties(ourSec, nil, sec, nil, sec.Properties)
+			}
+		case baseSec == nil:
+			// Added by theirs.
+			prev++
+			insert(m.result.sectionsIndex(), &m.result.Sections, prev, sec.Clone())
+		default:
+			// Removed by ours.
+			m.properties(nil, baseSec, sec, baseSec.Properties, sec.Properties)
+		}
+	}
+
+	for _, sec := range base.Sections {
+		name := sec.name()
+		if base.LookupSection(name) != sec || theirs.LookupSection(name) != nil {
+			continue
+		}
+		// Removed by theirs.
+		ourSec := m.result.LookupSection(name)
+		if ourSec == nil {
+			continue
+		}
+		m.properties(ourSec, sec, nil, sec.Properties, nil)
+		if len(ourSec.Properties) == 0 {
+			m.result.RemoveSection(name)
+		}
+	}
+
+	return m.result, m.conflicts
+}
+
+type merger struct {
+	cfg       mergeConfig
+	base      *AST
+	theirs    *AST
+	result    *AST
+	conflicts []Conflict
+}
+
+// properties merges into section ourSec of the result (nil for the global
+// section, or for a section removed by ours) the changes from the properties
+// baseProps of baseSec to the properties theirProps of theirSec. The sections
+// are nil for the global section.
+func (m *merger) properties(ourSec, baseSec, theirSec *Section,
+	baseProps, theirProps []*Property,
+) {
+	var prev string // key of the last property of theirs found in ours
+	for _, prop := range theirProps {
+		path := pathOf(theirSec, prop)
+		if m.theirs.LookupOwn(path) != prop {
+			continue // duplicate
+		}
+		baseProp := m.base.LookupOwn(path)
+		ourProp := m.result.LookupOwn(path)
+
+		switch {
+		case ourProp == nil && baseProp == nil:
+			if ourSec == nil && path.Section() != "" {
+				// Added by theirs to a section removed by ours.
+				m.conflict(path, nil, nil, prop)
+				continue
+			}
+			// Added by theirs.
+			newProp := m.insert(ourSec, prev, prop)
+			newProp.Comments = slices.Clone(prop.Comments)
+			prev = prop.Key
+		case ourProp == nil:
+			if prop.Value != baseProp.Value {
+				// Removed by ours, changed by theirs.
+				m.conflict(path, baseProp, nil, prop)
+			}
+		case baseProp == nil:
+			// Added by both.
+			prev = ourProp.Key
+			if ourProp.Value != prop.Value {
+				m.conflict(path, nil, ourProp, prop)
+			}
+		default:
+			prev = ourProp.Key
+			switch {
+			case prop.Value == baseProp.Value || prop.Value == ourProp.Value:
+			case ourProp.Value == baseProp.Value:
+				ourProp.Value = prop.Value
+			default:
+				m.conflict(path, baseProp, ourProp, prop)
+				continue
+			}
+			m.comments(&ourProp.Comments, baseProp.Comments, prop.Comments)
+		}
+	}
+
+	for _, prop := range baseProps {
+		path := pathOf(baseSec, prop)
+		if m.base.LookupOwn(path) != prop || m.theirs.LookupOwn(path) != nil {
+			continue
+		}
+		// Removed by theirs.
+		ourProp := m.result.LookupOwn(path)
+		switch {
+		case ourProp == nil:
+		case ourProp.Value == prop.Value:
+			m.result.Remove(path)
+		default:
+			m.conflict(path, prop, ourProp, nil)
+		}
+	}
+}
+
+// insert inserts a copy of prop into section sec of the result, just after key
+// prev or, if prev is empty, before the first property.
+func (m *merger) insert(sec *Section, prev string, prop *Property) *Property {
+	props := m.result.properties(sec)
+	i := 0
+	if prev != "" {
+		prevProp, _ := find(m.result.propsIndex(sec), *props, prev, m.result.foldCase)
+		i = position(*props, prevProp) + 1
+	}
+	newProp, err := m.result.insertProperty(sec, i, prop.Key, prop.Value, true)
+	if err != nil {
+		panic(fmt.Sprintf("ast.Merge3: %v", err)) // unreachable: the key is new
+	}
+	return newProp
+}
+
+// comments replaces *ours with theirs if ours is the same as base.
+func (m *merger) comments(ours *[]string, base, theirs []string) {
+	if slices.Equal(*ours, base) && !slices.Equal(theirs, base) {
+		*ours = slices.Clone(theirs)
+	}
+}
+
+// conflict records a conflict on path and, if enabled, marks it.
+func (m *merger) conflict(path Path, base, ours, theirs *Property) {
+	value := func(prop *Property) Value {
+		if prop == nil {
+			return nil
+		}
+		return prop.Value
+	}
+	m.conflicts = append(m.conflicts, Conflict{
+		Path:   path,
+		Base:   value(base),
+		Ours:   value(ours),
+		Theirs: value(theirs),
+	})
+	if !m.cfg.markers {
+		return
+	}
+
+	marker := "#"
+	if m.result.commentMarker != 0 {
+		marker = string(m.result.commentMarker)
+	}
+	line := func(prop *Property) string {
+		if prop == nil {
+			return marker + " (absent)"
+		}
+		return marker + " " + prop.Key + " = " + string(appendValue(nil, prop.Value))
+	}
+	target := ours
+	if target == nil {
+		target, _ = m.result.Add(path, theirs.Value)
+		target.Comments = slices.Clone(theirs.Comments)
+	}
+	target.Comments = append(target.Comments,
+		marker+" <<<<<<< ours", line(ours),
+		marker+" ||||||| base", line(base),
+		marker+" =======", line(theirs),
+		marker+" >>>>>>> theirs")
+}
+
+// Overlay returns a new AST with the properties of base overridden by the
+// properties of overrides, in order: a later AST overrides the keys of the
+// earlier ones. None of the ASTs is modified.
+//
+// The result starts as a copy of base, so it keeps the layout and the
+// comments of base. An overridden key keeps its position and its comments in
+// the result and takes the new value. A key that doesn't exist yet is appended
+// at the end of its section, with its comments, taking over the blank lines
+// of the previous last key as [AST.InsertAfter] does; a section that doesn't
+// exist yet is appended at the end of the result, with its comments. Thus new
+// keys and sections appear in the order of the overrides.
+//
+// Keys and sections are matched by name, according to the case sensitivity
+// of base (see [AST.SetCaseInsensitive]). Of duplicate keys or sections in an
+// override, only the first one is used, as with [AST.Lookup]. The keys
+// inherited from a defaults section are ignored.
+func Overlay(base *AST, overrides ...*AST) *AST {
+	result := base.Clone()
+	for _, over := range overrides {
+		overlayProperties(result, over, nil)
+		for _, sec := range over.Sections {
+			if over.LookupSection(sec.name()) != sec {
+				continue // duplicate
+			}
+			if result.LookupSection(sec.name()) == nil {
+				newSec := &Section{
+					Name:        sec.Name,
+					Subsection:  sec.Subsection,
+					Parents:     slices.Clone(sec.Parents),
+					Template:    sec.Template,
+					Inheritance: sec.Inheritance,
+					Comments:    slices.Clone(sec.Comments),
+					clause:      sec.clause,
+				}
+				push(result.sectionsIndex(), &result.Sections, newSec)
+			}
+			overlayProperties(result, over, sec)
+		}
+	}
+	return result
+}
+
+// overlayProperties sets in result the properties of section sec of over (nil
+// for the global section). The section must exist in result.
+func overlayProperties(result, over *AST, sec *Section) {
+	var resSec *Section
+	if sec != nil {
+		resSec = result.LookupSection(sec.name())
+	}
+	ix := result.propsIndex(resSec)
+	props := result.properties(resSec)
+	for _, prop := range *over.properties(sec) {
+		if over.LookupOwn(pathOf(sec, prop)) != prop {
+			continue // duplicate
+		}
+		if old, ok := find(ix, *props, prop.Key, result.foldCase); ok {
+			old.Value = prop.Value
+			continue
+		}
+		newProp, _ := result.insertProperty(resSec, len(*props), prop.Key, prop.Value, true)
+		newProp.Comments = slices.Clone(prop.Comments)
+	}
+}
@@ -0,0 +1,340 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast_test
+
+import (
+	"testing"
+
+	"github.com/go-quicktest/qt"
+
+	"github.com/marco-m/roundtrip_ini/ast"
+)
+
+func TestMerge3(t *testing.T) {
+	type testCase struct {
+		name          string
+		base          string
+		ours          string
+		theirs        string
+		want          string
+		wantConflicts []string
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		base := parse(t, tc.base)
+		ours := parse(t, tc.ours)
+		theirs := parse(t, tc.theirs)
+
+		merged, conflicts := ast.Merge3(base, ours, theirs)
+
+		qt.Assert(t, qt.Equals(merged.String(), normalizeEnds(tc.want)))
+		var have []string
+		for _, c := range conflicts {
+			have = append(have, c.String())
+		}
+		qt.Assert(t, qt.DeepEquals(have, tc.wantConflicts))
+		qt.Assert(t, qt.Equals(base.String(), normalizeEnds(tc.base)))
+		qt.Assert(t, qt.Equals(ours.String(), normalizeEnds(tc.ours)))
+		qt.Assert(t, qt.Equals(theirs.String(), normalizeEnds(tc.theirs)))
+	}
+
+	testCases := []testCase{
+		{
+			name:   "no changes",
+			base:   "a = 1\n[s1]\nb = 2",
+			ours:   "a = 1\n[s1]\nb = 2",
+			theirs: "a = 1\n[s1]\nb = 2",
+			want:   "a = 1\n[s1]\nb = 2",
+		},
+		{
+			name: "ours changes are kept",
+			base: "a = 1\n[s1]\nb = 2",
+			ours: `
+# my comment
+a = 10
+
+[s1]
+b = 2
+mine = "x"`,
+			theirs: "a = 1\n[s1]\nb = 2",
+			want: `
+# my comment
+a = 10
+
+[s1]
+b = 2
+mine = "x"`,
+		},
+		{
+			name: "theirs changes are taken",
+			base: `
+# timeout
+timeout = 10
+[s1]
+old = 1
+b = 2`,
+			ours: `
+# timeout
+timeout = 10
+
+[s1]
+# my b
+b = 2`,
+			theirs: `
+# timeout, in seconds
+timeout = 20
+[s1]
+b = 2
+# new c
+c = 3`,
+			want: `
+# timeout, in seconds
+timeout = 20
+
+[s1]
+# my b
+b = 2
+# new c
+c = 3`,
+		},
+		{
+			name:   "new key is inserted after its predecessor",
+			base:   "[s1]\na = 1\nc = 3",
+			ours:   "[s1]\nc = 3\na = 1\n\nz = 0",
+			theirs: "[s1]\na = 1\nb = 2\nc = 3",
+			want:   "[s1]\nc = 3\na = 1\nb = 2\n\nz = 0",
+		},
+		{
+			name:   "new first key",
+			base:   "[s1]\nb = 2",
+			ours:   "[s1]\nb = 2",
+			theirs: "[s1]\na = 1\nb = 2",
+			want:   "[s1]\na = 1\nb = 2",
+		},
+		{
+			name: "sections added and removed by theirs",
+			base: "[s1]\na = 1\n[old]\nx = 1\n[kept]\ny = 1",
+			ours: "[s1]\na = 1\n[old]\nx = 1\n[kept]\ny = 1\nmine = 2",
+			theirs: `
+[s1]
+a = 1
+# new section
+[new]
+n = 1
+[s3]
+z = 1`,
+			want: `
+[s1]
+a = 1
+# new section
+[new]
+n = 1
+[s3]
+z = 1
+[kept]
+mine = 2`,
+		},
+		{
+			name:   "same change on both sides",
+			base:   "a = 1\nb = 1",
+			ours:   "a = 2",
+			theirs: "a = 2",
+			want:   "a = 2",
+		},
+		{
+			name:          "both changed",
+			base:          "a = 1\nb = 1",
+			ours:          "a = 2\nb = 1",
+			theirs:        "a = 3\nb = 5",
+			want:          "a = 2\nb = 5",
+			wantConflicts: []string{"a: base 1, ours 2, theirs 3"},
+		},
+		{
+			name:          "removed by ours, changed by theirs",
+			base:          "[s1]\na = 1\nb = 1",
+			ours:          "[s1]\nb = 1",
+			theirs:        "[s1]\na = 2\nb = 1",
+			want:          "[s1]\nb = 1",
+			wantConflicts: []string{"s1/a: base 1, ours absent, theirs 2"},
+		},
+		{
+			name:          "changed by ours, removed by theirs",
+			base:          "[s1]\na = 1\n[s2]\nb = 1",
+			ours:          "[s1]\na = 2\n[s2]\nb = 2",
+			theirs:        "[s1]",
+			want:          "[s1]\na = 2\n[s2]\nb = 2",
+			wantConflicts: []string{"s1/a: base 1, ours 2, theirs absent", "s2/b: base 1, ours 2, theirs absent"},
+		},
+		{
+			name:          "added by both",
+			base:          "b = 1",
+			ours:          "a = \"x\"\nb = 1",
+			theirs:        "a = \"y\"\nb = 1",
+			want:          "a = \"x\"\nb = 1",
+			wantConflicts: []string{`a: base absent, ours "x", theirs "y"`},
+		},
+		{
+			name:          "section removed by ours",
+			base:          "x = 0\n[s1]\na = 1\nb = 1",
+			ours:          "x = 0",
+			theirs:        "x = 0\n[s1]\na = 1\nb = 2\nc = 3",
+			want:          "x = 0",
+			wantConflicts: []string{"s1/b: base 1, ours absent, theirs 2", "s1/c: base absent, ours absent, theirs 3"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) { run(t, tc) })
+	}
+}
+
+func TestMerge3ConflictMarkers(t *testing.T) {
+	base := parse(t, "[s1]\n# timeout\ntimeout = 10\nretries = 1\nold = 1")
+	ours := parse(t, "[s1]\n# timeout\ntimeout = 30\nold = 2")
+	theirs := parse(t, "[s1]\n# timeout\ntimeout = 20\nretries = 3")
+
+	merged, conflicts := ast.Merge3(base, ours, theirs, ast.WithConflictMarkers(true))
+
+	qt.Assert(t, qt.HasLen(conflicts, 3))
+	want := `
+[s1]
+# timeout
+# <<<<<<< ours
+# timeout = 30
+# ||||||| base
+# timeout = 10
+# =======
+# timeout = 20
+# >>>>>>> theirs
+timeout = 30
+# <<<<<<< ours
+# old = 2
+# ||||||| base
+# old = 1
+# =======
+# (absent)
+# >>>>>>> theirs
+old = 2
+# <<<<<<< ours
+# (absent)
+# ||||||| base
+# retries = 1
+# =======
+# retries = 3
+# >>>>>>> theirs
+retries = 3`
+	qt.Assert(t, qt.Equals(merged.String(), normalizeEnds(want)))
+}
+
+func TestMerge3ConflictMarkersCommentMarker(t *testing.T) {
+	parser := ast.NewParser(ast.WithCommentMarkers(";"))
+	parseSemicolon := func(input string) *ast.AST {
+		tree, err := parser.ParseString("", input)
+		qt.Assert(t, qt.IsNil(err))
+		return tree
+	}
+	base := parseSemicolon("[s1]\na = 1")
+	ours := parseSemicolon("[s1]\na = 2")
+	theirs := parseSemicolon("[s1]\na = 3")
+
+	merged, conflicts := ast.Merge3(base, ours, theirs, ast.WithConflictMarkers(true))
+
+	qt.Assert(t, qt.HasLen(conflicts, 1))
+	want := `
+[s1]
+; <<<<<<< ours
+; a = 2
+; ||||||| base
+; a = 1
+; =======
+; a = 3
+; >>>>>>> theirs
+a = 2`
+	qt.Assert(t, qt.Equals(merged.String(), normalizeEnds(want)))
+	reparsed, err := parser.ParseString("", merged.String())
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.IsTrue(ast.Equal(reparsed, merged)))
+}
+
+func TestMerge3CaseInsensitive(t *testing.T) {
+	base := parse(t, "[S1]\nA = 1")
+	ours := parse(t, "[s1]\na = 1")
+	theirs := parse(t, "[S1]\nA = 2")
+	for _, tree := range []*ast.AST{base, ours, theirs} {
+		tree.SetCaseInsensitive(true)
+	}
+
+	merged, conflicts := ast.Merge3(base, ours, theirs)
+
+	qt.Assert(t, qt.HasLen(conflicts, 0))
+	qt.Assert(t, qt.Equals(merged.String(), "[s1]\na = 2\n"))
+	qt.Assert(t, qt.IsTrue(merged.CaseInsensitive()))
+}
+
+func TestOverlay(t *testing.T) {
+	base := parse(t, `
+# defaults
+level = "info"
+
+[server]
+# listen port
+port = 8080
+host = "localhost"
+
+[db]
+url = "sqlite"`)
+	system := parse(t, `
+level = "warn"
+[server]
+port = 80
+# TLS
+tls = 1
+[remote "origin"]
+url = "x"`)
+	user := parse(t, `
+[server]
+port = 8000
+port = 9000
+[db]
+# pool size
+pool = 4
+[server]
+host = "ignored"
+[cache]`)
+
+	result := ast.Overlay(base, system, user)
+
+	want := `
+# defaults
+level = "warn"
+
+[server]
+# listen port
+port = 8000
+host = "localhost"
+# TLS
+tls = 1
+
+[db]
+url = "sqlite"
+# pool size
+pool = 4
+[remote "origin"]
+url = "x"
+[cache]`
+	qt.Assert(t, qt.Equals(result.String(), normalizeEnds(want)))
+	checkLookups(t, result)
+	qt.Assert(t, qt.Equals(base.Lookup("server/port").Value, ast.Value(ast.Number{Value: 8080})))
+	qt.Assert(t, qt.IsNil(base.Lookup("server/tls")))
+}
+
+func TestOverlayCaseInsensitive(t *testing.T) {
+	base := parse(t, "[Server]\nPort = 1")
+	base.SetCaseInsensitive(true)
+	over := parse(t, "[server]\nport = 2\nhost = \"h\"")
+
+	result := ast.Overlay(base, over)
+
+	qt.Assert(t, qt.Equals(result.String(), "[Server]\nPort = 2\nhost = \"h\"\n"))
+}
@@ -0,0 +1,159 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Option configures a [Parser].
+type Option func(*config)
+
+// ValueTypes is a set of value types accepted by a [Parser].
+type ValueTypes uint
+
+const (
+	// StringValues accepts double-quoted strings, decoded to [String].
+	StringValues ValueTypes = 1 << iota
+	// NumberValues accepts numbers, decoded to [Number].
+	NumberValues
+	// RawValues accepts any text up to the end of the line, decoded to
+	// [Raw] if it is not a String or Number (when also accepted). Since the
+	// value extends to the end of the line, a comment marker after the value
+	// is part of the value.
+	RawValues
+)
+
+// ErrInputTooLarge is returned when the input exceeds the size set by
+// [WithMaxInputSize].
+var ErrInputTooLarge = errors.New("input too large")
+
+// WithCommentMarkers sets the characters that start a comment. The default
+// is "#;". Each marker must be an ASCII punctuation character.
+func WithCommentMarkers(markers string) Option {
+	return func(cfg *config) {
+		cfg.commentMarkers = markers
+	}
+}
+
+// WithSeparators sets the characters accepted between a key and its value.
+// The default is "=". Each separator must be an ASCII punctuation character.
+// Note that the [Encoder] always writes the separator set by
+// [WithKeyValueSeparator].
+func WithSeparators(separators string) Option {
+	return func(cfg *config) {
+		cfg.separators = separators
+	}
+}
+
+// WithValueTypes sets the value types accepted by the parser. The default is
+// StringValues|NumberValues.
+func WithValueTypes(types ValueTypes) Option {
+	return func(cfg *config) {
+		cfg.valueTypes = types
+	}
+}
+
+// WithCaseInsensitive makes the lookups and edits of the parsed [AST] match
+// section names and keys without regard to case, as with
+// [AST.SetCaseInsensitive].
+func WithCaseInsensitive() Option {
+	return func(cfg *config) {
+		cfg.caseInsensitive = true
+	}
+}
+
+// WithDefaultsSection makes the lookups of the parsed [AST] fall back to
+// section secName, as with [AST.SetDefaultsSection].
+func WithDefaultsSection(secName string) Option {
+	return func(cfg *config) {
+		cfg.hasDefaults = true
+		cfg.defaults = secName
+	}
+}
+
+// WithStrict sets the strictness of the parser. A strict parser, the default,
+// accepts section names and keys that match `[a-zA-Z][a-zA-Z_\d]*` and only
+// "\n" as line ending.
+//
+// A non-strict parser accepts as section name any text between "[" and "]",
+// as key any text before a separator, and also "\r\n" as line ending.
+// Leading and trailing whitespace is removed from section names and keys.
+func WithStrict(strict bool) Option {
+	return func(cfg *config) {
+		cfg.strict = strict
+	}
+}
+
+// WithMaxInputSize makes the parser fail with [ErrInputTooLarge] when the
+// input is larger than size bytes. The default, 0, means no limit.
+func WithMaxInputSize(size int64) Option {
+	return func(cfg *config) {
+		cfg.maxInputSize = size
+	}
+}
+
+// config is the configuration of a Parser, shared read-only by its lexers.
+type config struct {
+	commentMarkers  string
+	separators      string
+	valueTypes      ValueTypes
+	caseInsensitive bool
+	hasDefaults     bool
+	defaults        string
+	inheritance     InheritanceSyntax
+	strict          bool
+	maxInputSize    int64
+
+	// Lookup tables, built from the fields above.
+	isComment   [256]bool
+	isSeparator [256]bool
+}
+
+func defaultConfig() config {
+	return config{
+		commentMarkers: "#;",
+		separators:     "=",
+		valueTypes:     StringValues | NumberValues,
+		strict:         true,
+	}
+}
+
+// compile validates the configuration and builds the lookup tables.
+func (cfg *config) compile() error {
+	if cfg.separators == "" {
+		return errors.New("ast: no separators")
+	}
+	if cfg.valueTypes == 0 {
+		return errors.New("ast: no value types")
+	}
+	for _, c := range []byte(cfg.commentMarkers) {
+		if !isMarker(c) {
+			return fmt.Errorf("ast: invalid comment marker %q", string(c))
+		}
+		cfg.isComment[c] = true
+	}
+	for _, c := range []byte(cfg.separators) {
+		if !isMarker(c) {
+			return fmt.Errorf("ast: invalid separator %q", string(c))
+		}
+		if cfg.isComment[c] {
+			return fmt.Errorf("ast: %q is both a comment marker and a separator",
+				string(c))
+		}
+		cfg.isSeparator[c] = true
+	}
+	return nil
+}
+
+// isMarker reports whether c can be used as comment marker or separator.
+func isMarker(c byte) bool {
+	switch c {
+	case '[', ']', '"', '_':
+		return false
+	}
+	return '!' <= c && c <= '/' || ':' <= c && c <= '@' ||
+		'[' <= c && c <= '`' || '{' <= c && c <= '~'
+}
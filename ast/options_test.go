@@ -0,0 +1,213 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast_test
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+
+	"github.com/marco-m/roundtrip_ini/ast"
+)
+
+func TestParserOptions(t *testing.T) {
+	testCases := []struct {
+		name  string
+		opts  []ast.Option
+		input string
+		want  string
+	}{
+		{
+			name:  "comment markers",
+			opts:  []ast.Option{ast.WithCommentMarkers("!")},
+			input: "! comment\na = 1",
+			want:  "! comment\na = 1",
+		},
+		{
+			name:  "separators",
+			opts:  []ast.Option{ast.WithSeparators("=:")},
+			input: "a : 1\nb = 2",
+			want:  "a = 1\nb = 2",
+		},
+		{
+			name:  "raw values",
+			opts:  []ast.Option{ast.WithValueTypes(ast.RawValues)},
+			input: "a = hello world  \nb = 1.5\nc = \"q\"\nd =",
+			want:  "a = hello world\nb = 1.5\nc = \"q\"\nd = ",
+		},
+		{
+			name:  "raw values extend to the end of the line",
+			opts:  []ast.Option{ast.WithValueTypes(ast.RawValues)},
+			input: "a = x ; not a comment",
+			want:  "a = x ; not a comment",
+		},
+		{
+			name: "non-strict names",
+			opts: []ast.Option{ast.WithStrict(false),
+				ast.WithValueTypes(ast.StringValues | ast.NumberValues | ast.RawValues)},
+			input: "[ remote/origin ]\nlog.level = debug\nmy key = 1\n[http://proxy]\nx = 2",
+			want:  "[remote/origin]\nlog.level = debug\nmy key = 1\n[http://proxy]\nx = 2",
+		},
+		{
+			name:  "non-strict subsections",
+			opts:  []ast.Option{ast.WithStrict(false)},
+			input: "[remote \"origin\"]\nurl = \"a\"\n[branch/x \"main\"]\nmerge = 1",
+			want:  "[remote \"origin\"]\nurl = \"a\"\n[branch/x \"main\"]\nmerge = 1",
+		},
+		{
+			name:  "non-strict accepts CRLF",
+			opts:  []ast.Option{ast.WithStrict(false)},
+			input: "# comment\r\na = 1\r\n\r\n[s1]\r\nb = \"x\"\r\n",
+			want:  "# comment\na = 1\n\n[s1]\nb = \"x\"",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tree, err := ast.NewParser(tc.opts...).ParseString("", tc.input)
+
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.Equals(tree.String(), tc.want+"\n"))
+		})
+	}
+}
+
+func TestParserOptionsValueTypes(t *testing.T) {
+	types := ast.StringValues | ast.NumberValues | ast.RawValues
+	parser := ast.NewParser(ast.WithValueTypes(types))
+
+	tree, err := parser.ParseString("", "a = x\nb = 1.5\nc = \"q\"")
+
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(tree.Lookup("a").Value, ast.Value(ast.Raw{Value: "x"})))
+	qt.Assert(t, qt.Equals(tree.Lookup("b").Value, ast.Value(ast.Number{Value: 1.5})))
+	qt.Assert(t, qt.Equals(tree.Lookup("c").Value, ast.Value(ast.String{Value: "q"})))
+}
+
+func TestParserOptionsErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		opts    []ast.Option
+		input   string
+		wantErr string
+	}{
+		{
+			name:    "value type not accepted",
+			opts:    []ast.Option{ast.WithValueTypes(ast.StringValues)},
+			input:   "a = 1",
+			wantErr: `1:5: unexpected token "1" (expected value)`,
+		},
+		{
+			name:    "strict rejects CR",
+			input:   "a = 1\r\n",
+			wantErr: `1:6: invalid input text "\r"`,
+		},
+		{
+			name:    "invalid comment marker",
+			opts:    []ast.Option{ast.WithCommentMarkers("#a")},
+			wantErr: `ast: invalid comment marker "a"`,
+		},
+		{
+			name:    "invalid separator",
+			opts:    []ast.Option{ast.WithSeparators("[")},
+			wantErr: `ast: invalid separator "["`,
+		},
+		{
+			name:    "overlapping comment marker and separator",
+			opts:    []ast.Option{ast.WithSeparators(":"), ast.WithCommentMarkers(":")},
+			wantErr: `ast: ":" is both a comment marker and a separator`,
+		},
+		{
+			name:    "no value types",
+			opts:    []ast.Option{ast.WithValueTypes(0)},
+			wantErr: `ast: no value types`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ast.NewParser(tc.opts...).ParseString("", tc.input)
+
+			qt.Assert(t, qt.IsNotNil(err))
+			qt.Assert(t, qt.Equals(err.Error(), tc.wantErr))
+		})
+	}
+}
+
+func TestParserOptionsCaseInsensitive(t *testing.T) {
+	input := `
+Name = "x"
+[Server]
+Port = 80`
+	parser := ast.NewParser(ast.WithCaseInsensitive())
+
+	tree, err := parser.ParseString("", input)
+	qt.Assert(t, qt.IsNil(err))
+
+	qt.Assert(t, qt.IsNotNil(tree.Lookup("name")))
+	qt.Assert(t, qt.IsNotNil(tree.Lookup("SERVER/port")))
+	qt.Assert(t, qt.IsNotNil(tree.LookupSection("server")))
+
+	tree.EnableIndex()
+	qt.Assert(t, qt.IsNotNil(tree.Lookup("server/PORT")))
+}
+
+func TestParserOptionsMaxInputSize(t *testing.T) {
+	input := "a = 1\nb = 2\n"
+	testCases := []struct {
+		name    string
+		size    int64
+		wantErr bool
+	}{
+		{name: "below limit", size: int64(len(input)) + 1},
+		{name: "at limit", size: int64(len(input))},
+		{name: "above limit", size: int64(len(input)) - 1, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			parser := ast.NewParser(ast.WithMaxInputSize(tc.size))
+
+			_, err := parser.ParseString("", input)
+
+			if tc.wantErr {
+				qt.Assert(t, qt.ErrorIs(err, ast.ErrInputTooLarge))
+			} else {
+				qt.Assert(t, qt.IsNil(err))
+			}
+		})
+	}
+}
+
+func TestNewParserWithoutOptionsIsShared(t *testing.T) {
+	qt.Assert(t, qt.Equals(ast.NewParser(), ast.NewParser()))
+}
+
+func TestParserConcurrentUse(t *testing.T) {
+	parser := ast.NewParser(ast.WithSeparators("=:"))
+	input := genINI(10, 10)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tree, err := parser.Parse("", strings.NewReader(input))
+			if err == nil && tree.String() != input {
+				err = errors.New("round trip mismatch")
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		qt.Assert(t, qt.IsNil(err))
+	}
+}
@@ -0,0 +1,343 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Parser decodes the INI format into an [AST].
+//
+// A Parser is immutable and safe for concurrent use: create it once and reuse
+// it.
+type Parser struct {
+	cfg config
+	err error // invalid configuration
+}
+
+// defaultParser is returned by NewParser when called without options.
+var defaultParser = newParser(nil)
+
+// NewParser returns a parser for INI files, configured by opts.
+//
+// NewParser never fails: if opts are invalid, all the methods of the returned
+// parser return the configuration error. Called without options, NewParser
+// returns a shared parser with the default configuration.
+func NewParser(opts ...Option) *Parser {
+	if len(opts) == 0 {
+		return defaultParser
+	}
+	return newParser(opts)
+}
+
+func newParser(opts []Option) *Parser {
+	p := &Parser{cfg: defaultConfig()}
+	for _, opt := range opts {
+		opt(&p.cfg)
+	}
+	p.err = p.cfg.compile()
+	return p
+}
+
+// Parse decodes the INI text read from r. Filename is used only to report
+// positions.
+func (p *Parser) Parse(filename string, r io.Reader) (*AST, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	ps := parser{lex: newLexer(filename, r, &p.cfg)}
+	return ps.parse()
+}
+
+// ParseString decodes the INI text in input. Filename is used only to report
+// positions.
+func (p *Parser) ParseString(filename string, input string) (*AST, error) {
+	return p.Parse(filename, strings.NewReader(input))
+}
+
+// ParseBytes decodes the INI text in input. Filename is used only to report
+// positions.
+func (p *Parser) ParseBytes(filename string, input []byte) (*AST, error) {
+	return p.Parse(filename, bytes.NewReader(input))
+}
+
+// parser is a recursive descent parser for the grammar documented in the
+// package comment. It needs a single token of lookahead, plus the comments
+// preceding a node, since comments belong to the node that follows them.
+type parser struct {
+	lex *lexer
+	tok token // current token
+}
+
+// next advances to the next token.
+func (ps *parser) next() error {
+	tok, err := ps.lex.next()
+	if err != nil {
+		return err
+	}
+	ps.tok = tok
+	return nil
+}
+
+// expect consumes the current token if it has the wanted kind.
+func (ps *parser) expect(kind tokenKind) (token, error) {
+	tok := ps.tok
+	if tok.kind != kind {
+		return token{}, ps.unexpected(kind.String())
+	}
+	return tok, ps.next()
+}
+
+func (ps *parser) unexpected(expected string) error {
+	var what string
+	switch ps.tok.kind {
+	case tokEOF, tokNewLine:
+		what = ps.tok.kind.String()
+	default:
+		what = fmt.Sprintf("token %q", ps.tok.text)
+	}
+	return &Error{
+		Pos: ps.tok.pos,
+		Msg: fmt.Sprintf("unexpected %s (expected %s)", what, expected),
+	}
+}
+
+func (ps *parser) parse() (*AST, error) {
+	tree := &AST{
+		Pos:         ps.lex.pos,
+		foldCase:    ps.lex.cfg.caseInsensitive,
+		hasDefaults: ps.lex.cfg.hasDefaults,
+		defaults:    ps.lex.cfg.defaults,
+	}
+	if markers := ps.lex.cfg.commentMarkers; markers != "" {
+		tree.commentMarker = markers[0]
+	}
+	if !ps.lex.cfg.strict {
+		tree.lenient = true
+		tree.reserved = ps.lex.cfg.separators + ps.lex.cfg.commentMarkers
+	}
+	if err := ps.next(); err != nil {
+		return nil, err
+	}
+
+	blanks, err := ps.blankLines()
+	if err != nil {
+		return nil, err
+	}
+	tree.BlankLines = blanks
+
+	for {
+		comments, err := ps.comments()
+		if err != nil {
+			return nil, err
+		}
+
+		switch ps.tok.kind {
+		case tokIdent:
+			prop, err := ps.property(comments)
+			if err != nil {
+				return nil, err
+			}
+			if n := len(tree.Sections); n > 0 {
+				sec := tree.Sections[n-1]
+				sec.Properties = append(sec.Properties, prop)
+			} else {
+				tree.Properties = append(tree.Properties, prop)
+			}
+		case tokLBracket:
+			sec, err := ps.section(comments)
+			if err != nil {
+				return nil, err
+			}
+			tree.Sections = append(tree.Sections, sec)
+		case tokEOF:
+			// The blank lines after the comments at the end of the input
+			// are dropped.
+			for len(comments) > 0 && comments[len(comments)-1] == "" {
+				comments = comments[:len(comments)-1]
+			}
+			tree.Comments = comments
+			return tree, nil
+		default:
+			return nil, ps.unexpected("key or section")
+		}
+	}
+}
+
+// blankLines parses NewLine*.
+func (ps *parser) blankLines() ([]string, error) {
+	var blanks []string
+	for ps.tok.kind == tokNewLine {
+		blanks = append(blanks, ps.tok.text)
+		if err := ps.next(); err != nil {
+			return nil, err
+		}
+	}
+	return blanks, nil
+}
+
+// comments parses (Comment NewLine NewLine*)*. The last NewLine is optional
+// at the end of the input. The blank lines after a comment are kept among the
+// comments as empty strings.
+func (ps *parser) comments() ([]string, error) {
+	var comments []string
+	for ps.tok.kind == tokComment {
+		comments = append(comments, ps.tok.text)
+		if err := ps.next(); err != nil {
+			return nil, err
+		}
+		if ps.tok.kind == tokEOF {
+			break
+		}
+		if _, err := ps.expect(tokNewLine); err != nil {
+			return nil, err
+		}
+		for ps.tok.kind == tokNewLine {
+			comments = append(comments, "")
+			if err := ps.next(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return comments, nil
+}
+
+// property parses Ident "=" Value NewLine? NewLine*.
+func (ps *parser) property(comments []string) (*Property, error) {
+	key, val, err := ps.keyValue()
+	if err != nil {
+		return nil, err
+	}
+	blanks, err := ps.endOfLine()
+	if err != nil {
+		return nil, err
+	}
+	return &Property{
+		Pos:        key.pos,
+		Comments:   comments,
+		Key:        key.text,
+		Value:      val,
+		BlankLines: blanks,
+	}, nil
+}
+
+// keyValue parses Ident "=" Value.
+func (ps *parser) keyValue() (token, Value, error) {
+	key, err := ps.expect(tokIdent)
+	if err != nil {
+		return token{}, nil, err
+	}
+	if _, err := ps.expect(tokAssign); err != nil {
+		return token{}, nil, err
+	}
+	val, err := ps.value()
+	if err != nil {
+		return token{}, nil, err
+	}
+	return key, val, nil
+}
+
+// value parses String | Number | Raw, depending on the accepted value types.
+func (ps *parser) value() (Value, error) {
+	types := ps.lex.cfg.valueTypes
+	tok := ps.tok
+	switch {
+	case tok.kind == tokString && types&StringValues != 0:
+		return String{Value: tok.text}, ps.next()
+	case tok.kind == tokNumber && types&NumberValues != 0:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, &Error{Pos: tok.pos, Msg: err.Error()}
+		}
+		return Number{Value: f}, ps.next()
+	case tok.kind == tokRaw:
+		return rawValue(tok.text, types), ps.next()
+	default:
+		return nil, ps.unexpected("value")
+	}
+}
+
+// rawValue returns text as a String or Number, if it is one and that type is
+// accepted, or as a Raw.
+func rawValue(text string, types ValueTypes) Value {
+	if types&StringValues != 0 && len(text) >= 2 && text[0] == '"' {
+		if s, err := strconv.Unquote(text); err == nil {
+			return String{Value: s}
+		}
+	}
+	if types&NumberValues != 0 && isNumber(text) {
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return Number{Value: f}
+		}
+	}
+	return Raw{Value: text}
+}
+
+// section parses "[" Ident "]" NewLine? NewLine*.
+func (ps *parser) section(comments []string) (*Section, error) {
+	open, name, subsection, clause, err := ps.sectionHeader()
+	if err != nil {
+		return nil, err
+	}
+	sec := &Section{
+		Pos:        open.pos,
+		Comments:   comments,
+		Name:       name.text,
+		Subsection: subsection.text,
+	}
+	if clause.kind == tokInherit {
+		if err := parseInheritance(sec, ps.lex.cfg.inheritance, clause.text); err != nil {
+			return nil, &Error{Pos: clause.pos, Msg: err.Error()}
+		}
+		sec.clause = clause.text
+	}
+	if sec.BlankLines, err = ps.endOfLine(); err != nil {
+		return nil, err
+	}
+	return sec, nil
+}
+
+// sectionHeader parses "[" Ident String? Colon? "]" Paren?.
+func (ps *parser) sectionHeader() (open, name, subsection, clause token, err error) {
+	if open, err = ps.expect(tokLBracket); err != nil {
+		return
+	}
+	if name, err = ps.expect(tokIdent); err != nil {
+		return
+	}
+	if ps.tok.kind == tokString {
+		subsection = ps.tok
+		if err = ps.next(); err != nil {
+			return
+		}
+	}
+	if ps.tok.kind == tokInherit {
+		clause = ps.tok
+		if err = ps.next(); err != nil {
+			return
+		}
+	}
+	if _, err = ps.expect(tokRBracket); err != nil {
+		return
+	}
+	if ps.tok.kind == tokInherit && clause.kind != tokInherit {
+		clause = ps.tok
+		err = ps.next()
+	}
+	return
+}
+
+// endOfLine parses NewLine? NewLine*, returning the blank lines.
+func (ps *parser) endOfLine() ([]string, error) {
+	if ps.tok.kind == tokNewLine {
+		if err := ps.next(); err != nil {
+			return nil, err
+		}
+	}
+	return ps.blankLines()
+}
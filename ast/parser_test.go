@@ -0,0 +1,213 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+// This file tests the lexer and the parser error reporting.
+
+package ast_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/go-quicktest/qt"
+
+	"github.com/marco-m/roundtrip_ini/ast"
+)
+
+func TestParseErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{
+			name:    "missing value",
+			input:   "k =",
+			wantErr: "f.ini:1:4: unexpected end of file (expected value)",
+		},
+		{
+			name:    "missing key",
+			input:   "= 1",
+			wantErr: `f.ini:1:1: unexpected token "=" (expected key or section)`,
+		},
+		{
+			name:    "unterminated section",
+			input:   "[s",
+			wantErr: `f.ini:1:3: unexpected end of file (expected "]")`,
+		},
+		{
+			name:    "invalid character",
+			input:   "a = -1",
+			wantErr: `f.ini:1:5: invalid input text "-"`,
+		},
+		{
+			name:    "number with trailing dot",
+			input:   "a = 1.",
+			wantErr: `f.ini:1:6: invalid input text "."`,
+		},
+		{
+			name:    "unterminated string",
+			input:   "a = \"x\nb = 1",
+			wantErr: "f.ini:1:5: unterminated string",
+		},
+		{
+			name:    "invalid escape sequence",
+			input:   `a = "\x"`,
+			wantErr: `f.ini:1:5: invalid quoted string "\"\\x\"": invalid syntax`,
+		},
+		{
+			name:    "column counts runes",
+			input:   "a = \"é\" ?",
+			wantErr: `f.ini:1:9: invalid input text "?"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ast.NewParser().ParseString("f.ini", tc.input)
+
+			qt.Assert(t, qt.IsNotNil(err))
+			qt.Assert(t, qt.Equals(err.Error(), tc.wantErr))
+			var parseErr *ast.Error
+			qt.Assert(t, qt.ErrorAs(err, &parseErr))
+		})
+	}
+}
+
+func TestParsePositions(t *testing.T) {
+	input := `
+# comment for a
+a = 1
+[s1]
+
+	b = "x"`
+
+	tree, err := ast.NewParser().ParseString("f.ini", input)
+	qt.Assert(t, qt.IsNil(err))
+
+	qt.Assert(t, qt.Equals(tree.Pos,
+		ast.Position{Filename: "f.ini", Offset: 0, Line: 1, Column: 1}))
+	qt.Assert(t, qt.Equals(tree.Properties[0].Pos,
+		ast.Position{Filename: "f.ini", Offset: 17, Line: 3, Column: 1}))
+	qt.Assert(t, qt.Equals(tree.Sections[0].Pos,
+		ast.Position{Filename: "f.ini", Offset: 23, Line: 4, Column: 1}))
+	qt.Assert(t, qt.Equals(tree.Sections[0].Properties[0].Pos,
+		ast.Position{Filename: "f.ini", Offset: 30, Line: 6, Column: 2}))
+}
+
+func TestParseManyCommentsBeforeSection(t *testing.T) {
+	input := normalizeEnds(`
+# line 1
+# line 2
+# line 3
+# line 4
+# line 5
+[s1]`)
+
+	tree := parse(t, input)
+
+	qt.Assert(t, qt.HasLen(tree.Sections[0].Comments, 5))
+	qt.Assert(t, qt.Equals(tree.String(), input))
+}
+
+// The layout of the my.cnf of Debian: comment groups separated by blank lines,
+// then the include directives, which are comments for the parser.
+func TestParseBlankLinesBetweenComments(t *testing.T) {
+	input := `# The MariaDB configuration file
+#
+# The MariaDB/MySQL tools read configuration files in the following order:
+
+#
+# * IMPORTANT: Additional settings that can override those from this file!
+#
+
+!includedir /etc/mysql/conf.d/
+!includedir /etc/mysql/mariadb.conf.d/
+`
+	parser := ast.NewParser(ast.WithCommentMarkers("#;!"))
+
+	tree, err := parser.ParseString("my.cnf", input)
+
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(tree.Comments, []string{
+		"# The MariaDB configuration file",
+		"#",
+		"# The MariaDB/MySQL tools read configuration files in the following order:",
+		"",
+		"#",
+		"# * IMPORTANT: Additional settings that can override those from this file!",
+		"#",
+		"",
+		"!includedir /etc/mysql/conf.d/",
+		"!includedir /etc/mysql/mariadb.conf.d/",
+	}))
+	qt.Assert(t, qt.Equals(tree.String(), input))
+
+	tree = parse(t, "a = 1\n# c1\n\n# c2\n\nb = 2\n")
+	qt.Assert(t, qt.DeepEquals(tree.Properties[1].Comments, []string{"# c1", "", "# c2", ""}))
+}
+
+func TestParseReader(t *testing.T) {
+	input := "a = 1\n[s1]\nb = \"x\"\n"
+	// OneByteReader hides the io.ByteScanner of strings.Reader.
+	r := iotest.OneByteReader(strings.NewReader(input))
+
+	tree, err := ast.NewParser().Parse("", r)
+
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(tree.String(), input))
+}
+
+func TestParseReaderError(t *testing.T) {
+	errRead := errors.New("read failure")
+	r := io.MultiReader(strings.NewReader("a = 1\n"), iotest.ErrReader(errRead))
+
+	_, err := ast.NewParser().Parse("", r)
+
+	qt.Assert(t, qt.ErrorIs(err, errRead))
+}
+
+//
+// Benchmarks.
+//
+
+// genINI returns an INI document with nSections sections of nKeys keys each,
+// with comments and blank lines.
+func genINI(nSections, nKeys int) string {
+	var bld strings.Builder
+	for i := 0; i < nSections; i++ {
+		fmt.Fprintf(&bld, "# comment for section %d\n[section%d]\n\n", i, i)
+		for j := 0; j < nKeys; j++ {
+			if j%5 == 0 {
+				fmt.Fprintf(&bld, "; comment for key %d\n", j)
+			}
+			if j%2 == 0 {
+				fmt.Fprintf(&bld, "key%d = \"value %d\"\n", j, j)
+			} else {
+				fmt.Fprintf(&bld, "key%d = %d.5\n", j, j)
+			}
+		}
+		bld.WriteString("\n")
+	}
+	return bld.String()
+}
+
+func BenchmarkParse(b *testing.B) {
+	for _, size := range []int{10, 100, 1000} {
+		input := genINI(size, size)
+		b.Run(fmt.Sprintf("%dx%d", size, size), func(b *testing.B) {
+			parser := ast.NewParser()
+			b.SetBytes(int64(len(input)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := parser.ParseString("", input); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// OpKind is the kind of an [Operation].
+type OpKind int
+
+const (
+	// OpSet sets the value of Path to Value, as [AST.Add] does: it creates
+	// the property, and its section, if they don't exist.
+	OpSet OpKind = iota + 1
+	// OpDelete removes the property Path.
+	OpDelete
+	// OpRename renames the key of property Path to NewKey, as
+	// [AST.RenameKey] does.
+	OpRename
+	// OpInsert inserts the new property Path with value Value in an existing
+	// section: just after key After of the same section if After is set, at
+	// the end of the section otherwise. The key must not exist.
+	OpInsert
+)
+
+var opNames = []string{OpSet: "set", OpDelete: "delete", OpRename: "rename", OpInsert: "insert"}
+
+func (kind OpKind) String() string {
+	if kind > 0 && int(kind) < len(opNames) {
+		return opNames[kind]
+	}
+	return fmt.Sprintf("OpKind(%d)", int(kind))
+}
+
+// MarshalText implements [encoding.TextMarshaler].
+func (kind OpKind) MarshalText() ([]byte, error) {
+	if kind <= 0 || int(kind) >= len(opNames) {
+		return nil, fmt.Errorf("ast: invalid operation %d", int(kind))
+	}
+	return []byte(opNames[kind]), nil
+}
+
+// UnmarshalText implements [encoding.TextUnmarshaler].
+func (kind *OpKind) UnmarshalText(text []byte) error {
+	for k, name := range opNames {
+		if name != "" && name == string(text) {
+			*kind = OpKind(k)
+			return nil
+		}
+	}
+	return fmt.Errorf("ast: invalid operation %q", text)
+}
+
+// Operation is an edit of a [Patch].
+type Operation struct {
+	Op   OpKind
+	Path Path
+	// Value is the value for OpSet and OpInsert.
+	Value Value
+	// NewKey is the new key for OpRename.
+	NewKey string
+	// After is the optional key after which OpInsert inserts the property.
+	After string
+	// Expect is an optional precondition for OpSet, OpDelete and OpRename: if
+	// not nil, the operation succeeds only if Path exists and has value
+	// Expect. A key inherited from the defaults section or from a parent
+	// section doesn't exist for the precondition: see [AST.LookupOwn].
+	Expect Value
+}
+
+// String returns a short description of op, such as "rename s1/a to b".
+func (op Operation) String() string {
+	switch op.Op {
+	case OpRename:
+		return fmt.Sprintf("%s %s to %s", op.Op, op.Path, op.NewKey)
+	case OpInsert:
+		if op.After != "" {
+			return fmt.Sprintf("%s %s after %s", op.Op, op.Path, op.After)
+		}
+	}
+	return fmt.Sprintf("%s %s", op.Op, op.Path)
+}
+
+// jsonOperation is the JSON form of an Operation.
+type jsonOperation struct {
+	Op     OpKind     `json:"op"`
+	Path   Path       `json:"path"`
+	Value  *jsonValue `json:"value,omitempty"`
+	NewKey string     `json:"new_key,omitempty"`
+	After  string     `json:"after,omitempty"`
+	Expect *jsonValue `json:"expect,omitempty"`
+}
+
+// MarshalJSON encodes op as a JSON object such as
+//
+//	{"op": "set", "path": "s1/a", "value": {"type": "number", "value": 2},
+//	 "expect": {"type": "number", "value": 1}}
+//
+// The members with a zero value are omitted. The type of a value is one of
+// "string", "number" and "raw".
+func (op Operation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonOperation{
+		Op:     op.Op,
+		Path:   op.Path,
+		Value:  toJSONValue(op.Value),
+		NewKey: op.NewKey,
+		After:  op.After,
+		Expect: toJSONValue(op.Expect),
+	})
+}
+
+// UnmarshalJSON decodes op from the JSON form described in
+// [Operation.MarshalJSON].
+func (op *Operation) UnmarshalJSON(data []byte) error {
+	var jop jsonOperation
+	if err := json.Unmarshal(data, &jop); err != nil {
+		return err
+	}
+	value, err := jop.Value.toValue()
+	if err != nil {
+		return err
+	}
+	expect, err := jop.Expect.toValue()
+	if err != nil {
+		return err
+	}
+	*op = Operation{
+		Op:     jop.Op,
+		Path:   jop.Path,
+		Value:  value,
+		NewKey: jop.NewKey,
+		After:  jop.After,
+		Expect: expect,
+	}
+	return nil
+}
+
+// jsonValue is the JSON form of a Value.
+type jsonValue struct {
+	Type  string `json:"type"`
+	Value any    `json:"value"`
+}
+
+// toJSONValue returns the JSON form of val, or nil if val is nil.
+func toJSONValue(val Value) *jsonValue {
+	switch val := val.(type) {
+	case String:
+		return &jsonValue{Type: "string", Value: val.Value}
+	case Number:
+		return &jsonValue{Type: "number", Value: val.Value}
+	case Raw:
+		return &jsonValue{Type: "raw", Value: val.Value}
+	default:
+		return nil
+	}
+}
+
+// toValue returns the Value of jv, or nil if jv is nil.
+func (jv *jsonValue) toValue() (Value, error) {
+	if jv == nil {
+		return nil, nil
+	}
+	switch v := jv.Value.(type) {
+	case string:
+		switch jv.Type {
+		case "string":
+			return String{Value: v}, nil
+		case "raw":
+			return Raw{Value: v}, nil
+		}
+	case float64:
+		if jv.Type == "number" {
+			return Number{Value: v}, nil
+		}
+	}
+	return nil, fmt.Errorf("ast: invalid value %v of type %q", jv.Value, jv.Type)
+}
+
+// Patch is a list of operations, applied in order by [AST.ApplyPatch].
+// It can be serialized to JSON, as an array of operations; see
+// [Operation.MarshalJSON].
+type Patch []Operation
+
+// ConflictError is the error returned by [AST.ApplyPatch] when an operation
+// cannot be applied: a precondition doesn't hold, or the property to change
+// doesn't exist or already exists.
+type ConflictError struct {
+	Index int // index of the operation in the patch
+	Op    Operation
+	Err   error
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("ast: patch operation %d (%s): %s", e.Index, e.Op,
+		strings.TrimPrefix(e.Err.Error(), "ast: "))
+}
+
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+// ApplyPatch applies the operations of patch to tree, in order. The patch is
+// atomic: if an operation fails, ApplyPatch returns a *[ConflictError] and
+// leaves tree unchanged. Errors wrapping [ErrNotFound] or [ErrExists] are
+// available with [errors.Is].
+//
+// ApplyPatch validates the operations and then applies them to a clone of
+// tree; only if all of them succeed, it applies them to tree. Thus the
+// sections and properties of tree that are not removed keep their identity.
+func (tree *AST) ApplyPatch(patch Patch) error {
+	for i, op := range patch {
+		if err := op.validate(); err != nil {
+			return fmt.Errorf("ast: patch operation %d: %w", i, err)
+		}
+	}
+	clone := tree.Clone()
+	for i, op := range patch {
+		if err := clone.applyOperation(op); err != nil {
+			return &ConflictError{Index: i, Op: op, Err: err}
+		}
+	}
+	for _, op := range patch {
+		if err := tree.applyOperation(op); err != nil {
+			panic(fmt.Sprintf("ast.ApplyPatch: %s: %v", op, err)) // unreachable
+		}
+	}
+	return nil
+}
+
+// validate checks that op has the fields required by its kind.
+func (op Operation) validate() error {
+	switch op.Op {
+	case OpSet, OpInsert:
+		if op.Value == nil {
+			return fmt.Errorf("%s %s: missing value", op.Op, op.Path)
+		}
+	case OpRename:
+		if op.NewKey == "" {
+			return fmt.Errorf("%s %s: missing new key", op.Op, op.Path)
+		}
+	case OpDelete:
+	default:
+		return fmt.Errorf("invalid operation %d", int(op.Op))
+	}
+	if op.Op == OpInsert && op.Expect != nil {
+		return fmt.Errorf("%s %s: unexpected precondition", op.Op, op.Path)
+	}
+	return nil
+}
+
+func (tree *AST) applyOperation(op Operation) error {
+	if op.Expect != nil {
+		prop := tree.LookupOwn(op.Path)
+		if prop == nil {
+			return fmt.Errorf("ast: key %q: %w", op.Path, ErrNotFound)
+		}
+		if prop.Value != op.Expect {
+			return fmt.Errorf("expected value %s, found %s",
+				appendValue(nil, op.Expect), appendValue(nil, prop.Value))
+		}
+	}
+
+	switch op.Op {
+	case OpSet:
+		tree.Add(op.Path, op.Value)
+	case OpDelete:
+		if _, outcome := tree.Remove(op.Path); outcome == NotFound {
+			return fmt.Errorf("ast: key %q: %w", op.Path, ErrNotFound)
+		}
+	case OpRename:
+		return tree.RenameKey(op.Path, op.NewKey)
+	case OpInsert:
+		section, subsection, key := op.Path.split()
+		var err error
+		if op.After != "" {
+			_, err = tree.InsertAfter(NewSubsectionPath(section, subsection, op.After), key, op.Value)
+		} else {
+			sec, ok := tree.findSection(section, subsection)
+			if !ok {
+				return fmt.Errorf("ast: section %q: %w", SectionName(section, subsection),
+					ErrNotFound)
+			}
+			_, err = tree.insertProperty(sec, len(*tree.properties(sec)), key, op.Value, true)
+		}
+		return err
+	}
+	return nil
+}
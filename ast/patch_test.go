@@ -0,0 +1,320 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+
+	"github.com/marco-m/roundtrip_ini/ast"
+)
+
+const patchInput = `
+a = 1
+
+[s1]
+# comment for b
+b = "x"
+c = 2`
+
+func TestApplyPatch(t *testing.T) {
+	type testCase struct {
+		name  string
+		patch ast.Patch
+		want  string
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		tree := parse(t, patchInput)
+		tree.EnableIndex()
+		b := tree.Lookup("s1/b")
+
+		err := tree.ApplyPatch(tc.patch)
+
+		qt.Assert(t, qt.IsNil(err))
+		qt.Assert(t, qt.Equals(tree.String(), normalizeEnds(tc.want)))
+		checkLookups(t, tree)
+		if prop := tree.Lookup("s1/b"); prop != nil {
+			qt.Assert(t, qt.Equals(prop, b), qt.Commentf("identity not preserved"))
+		}
+	}
+
+	testCases := []testCase{
+		{
+			name: "empty",
+			want: patchInput,
+		},
+		{
+			name: "set",
+			patch: ast.Patch{
+				{Op: ast.OpSet, Path: "a", Value: ast.Number{Value: 10}},
+				{Op: ast.OpSet, Path: "s1/b", Value: ast.String{Value: "y"},
+					Expect: ast.String{Value: "x"}},
+				{Op: ast.OpSet, Path: "s2/d", Value: ast.Number{Value: 3}},
+			},
+			want: `
+a = 10
+
+[s1]
+# comment for b
+b = "y"
+c = 2
+[s2]
+d = 3`,
+		},
+		{
+			name: "delete",
+			patch: ast.Patch{
+				{Op: ast.OpDelete, Path: "a", Expect: ast.Number{Value: 1}},
+				{Op: ast.OpDelete, Path: "s1/c"},
+			},
+			want: `
+[s1]
+# comment for b
+b = "x"`,
+		},
+		{
+			name: "rename",
+			patch: ast.Patch{
+				{Op: ast.OpRename, Path: "s1/c", NewKey: "d", Expect: ast.Number{Value: 2}},
+				{Op: ast.OpSet, Path: "s1/d", Value: ast.Number{Value: 3}},
+			},
+			want: `
+a = 1
+
+[s1]
+# comment for b
+b = "x"
+d = 3`,
+		},
+		{
+			name: "insert",
+			patch: ast.Patch{
+				{Op: ast.OpInsert, Path: "z", Value: ast.Number{Value: 0}},
+				{Op: ast.OpInsert, Path: "s1/x", Value: ast.Number{Value: 5}, After: "b"},
+				{Op: ast.OpInsert, Path: "s1/y", Value: ast.Number{Value: 6}},
+			},
+			want: `
+a = 1
+z = 0
+
+[s1]
+# comment for b
+b = "x"
+x = 5
+c = 2
+y = 6`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) { run(t, tc) })
+	}
+}
+
+func TestApplyPatchConflicts(t *testing.T) {
+	type testCase struct {
+		name    string
+		patch   ast.Patch
+		wantErr string
+		wantIs  error
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		tree := parse(t, patchInput)
+
+		err := tree.ApplyPatch(tc.patch)
+
+		qt.Assert(t, qt.IsNotNil(err))
+		qt.Assert(t, qt.Equals(err.Error(), tc.wantErr))
+		var conflict *ast.ConflictError
+		qt.Assert(t, qt.IsTrue(errors.As(err, &conflict)))
+		if tc.wantIs != nil {
+			qt.Assert(t, qt.ErrorIs(err, tc.wantIs))
+		}
+		qt.Assert(t, qt.Equals(tree.String(), normalizeEnds(patchInput)))
+	}
+
+	testCases := []testCase{
+		{
+			name: "expect other value",
+			patch: ast.Patch{
+				{Op: ast.OpSet, Path: "a", Value: ast.Number{Value: 2}},
+				{Op: ast.OpSet, Path: "s1/b", Value: ast.String{Value: "y"},
+					Expect: ast.String{Value: "old"}},
+			},
+			wantErr: `ast: patch operation 1 (set s1/b): expected value "old", found "x"`,
+		},
+		{
+			name: "expect missing key",
+			patch: ast.Patch{
+				{Op: ast.OpSet, Path: "s1/z", Value: ast.Number{Value: 1},
+					Expect: ast.Number{Value: 0}},
+			},
+			wantErr: `ast: patch operation 0 (set s1/z): key "s1/z": not found`,
+			wantIs:  ast.ErrNotFound,
+		},
+		{
+			name: "delete missing key",
+			patch: ast.Patch{
+				{Op: ast.OpDelete, Path: "a"},
+				{Op: ast.OpDelete, Path: "a"},
+			},
+			wantErr: `ast: patch operation 1 (delete a): key "a": not found`,
+			wantIs:  ast.ErrNotFound,
+		},
+		{
+			name: "rename to existing key",
+			patch: ast.Patch{
+				{Op: ast.OpRename, Path: "s1/b", NewKey: "c"},
+			},
+			wantErr: `ast: patch operation 0 (rename s1/b to c): key "c": already exists`,
+			wantIs:  ast.ErrExists,
+		},
+		{
+			name: "insert existing key",
+			patch: ast.Patch{
+				{Op: ast.OpInsert, Path: "s1/c", Value: ast.Number{Value: 1}},
+			},
+			wantErr: `ast: patch operation 0 (insert s1/c): key "c": already exists`,
+			wantIs:  ast.ErrExists,
+		},
+		{
+			name: "insert in missing section",
+			patch: ast.Patch{
+				{Op: ast.OpInsert, Path: "s2/c", Value: ast.Number{Value: 1}},
+			},
+			wantErr: `ast: patch operation 0 (insert s2/c): section "s2": not found`,
+			wantIs:  ast.ErrNotFound,
+		},
+		{
+			name: "insert after missing key",
+			patch: ast.Patch{
+				{Op: ast.OpInsert, Path: "s1/d", Value: ast.Number{Value: 1}, After: "z"},
+			},
+			wantErr: `ast: patch operation 0 (insert s1/d after z): key "s1/z": not found`,
+			wantIs:  ast.ErrNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) { run(t, tc) })
+	}
+}
+
+func TestApplyPatchInheritedKey(t *testing.T) {
+	input := "[DEFAULT]\na = 1\n[s1]\nb = 2"
+	tree := parse(t, input)
+	tree.SetDefaultsSection("DEFAULT")
+
+	for _, op := range []ast.OpKind{ast.OpSet, ast.OpDelete} {
+		err := tree.ApplyPatch(ast.Patch{
+			{Op: op, Path: "s1/a", Value: ast.Number{Value: 3}, Expect: ast.Number{Value: 1}},
+		})
+
+		qt.Assert(t, qt.ErrorIs(err, ast.ErrNotFound))
+		qt.Assert(t, qt.Equals(tree.String(), normalizeEnds(input)))
+	}
+}
+
+func TestApplyPatchInvalid(t *testing.T) {
+	testCases := []struct {
+		name    string
+		op      ast.Operation
+		wantErr string
+	}{
+		{
+			name:    "unknown operation",
+			op:      ast.Operation{Path: "a"},
+			wantErr: "ast: patch operation 0: invalid operation 0",
+		},
+		{
+			name:    "set without value",
+			op:      ast.Operation{Op: ast.OpSet, Path: "a"},
+			wantErr: "ast: patch operation 0: set a: missing value",
+		},
+		{
+			name:    "rename without new key",
+			op:      ast.Operation{Op: ast.OpRename, Path: "a"},
+			wantErr: "ast: patch operation 0: rename a: missing new key",
+		},
+		{
+			name: "insert with precondition",
+			op: ast.Operation{Op: ast.OpInsert, Path: "b", Value: ast.Number{Value: 1},
+				Expect: ast.Number{Value: 1}},
+			wantErr: "ast: patch operation 0: insert b: unexpected precondition",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tree := parse(t, patchInput)
+
+			err := tree.ApplyPatch(ast.Patch{tc.op})
+
+			qt.Assert(t, qt.ErrorMatches(err, tc.wantErr))
+			qt.Assert(t, qt.Equals(tree.String(), normalizeEnds(patchInput)))
+		})
+	}
+}
+
+func TestPatchJSON(t *testing.T) {
+	patch := ast.Patch{
+		{Op: ast.OpSet, Path: "s1/b", Value: ast.String{Value: "y"},
+			Expect: ast.String{Value: "x"}},
+		{Op: ast.OpDelete, Path: "a"},
+		{Op: ast.OpRename, Path: "s1/c", NewKey: "d"},
+		{Op: ast.OpInsert, Path: "s1/e", Value: ast.Raw{Value: "on"}, After: "b"},
+	}
+	want := `[
+{"op":"set","path":"s1/b","value":{"type":"string","value":"y"},
+ "expect":{"type":"string","value":"x"}},
+{"op":"delete","path":"a"},
+{"op":"rename","path":"s1/c","new_key":"d"},
+{"op":"insert","path":"s1/e","value":{"type":"raw","value":"on"},"after":"b"}
+]`
+
+	data, err := json.Marshal(patch)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.JSONEquals(data, json.RawMessage(want)))
+
+	var decoded ast.Patch
+	qt.Assert(t, qt.IsNil(json.Unmarshal(data, &decoded)))
+	qt.Assert(t, qt.DeepEquals(decoded, patch))
+}
+
+func TestPatchJSONErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{
+			name:    "unknown operation",
+			data:    `[{"op":"copy","path":"a"}]`,
+			wantErr: `ast: invalid operation "copy"`,
+		},
+		{
+			name:    "unknown type",
+			data:    `[{"op":"set","path":"a","value":{"type":"bool","value":true}}]`,
+			wantErr: `ast: invalid value true of type "bool"`,
+		},
+		{
+			name:    "type mismatch",
+			data:    `[{"op":"set","path":"a","value":{"type":"number","value":"1"}}]`,
+			wantErr: `ast: invalid value 1 of type "number"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var patch ast.Patch
+			err := json.Unmarshal([]byte(tc.data), &patch)
+			qt.Assert(t, qt.ErrorMatches(err, tc.wantErr))
+		})
+	}
+}
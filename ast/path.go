@@ -0,0 +1,149 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast
+
+import "strings"
+
+// Path addresses a property of an AST. It is a list of components separated by
+// '/', in one of the forms:
+//   - "key"                    key in the global section
+//   - "section/key"            key in section [section]
+//   - "section/subsection/key" key in section [section "subsection"]
+//
+// A backslash escapes the character that follows it, so that a '/' can be part
+// of a name: "remote\/origin/url" is key "url" in section [remote/origin].
+// A subsection can also contain unescaped '/', since everything between the
+// first and the last component belongs to it: "branch/feature/x/merge" is key
+// "merge" in section [branch "feature/x"].
+//
+// Use [NewPath] and [NewSubsectionPath] to build a Path from names that may
+// contain '/' or '\'.
+//
+// The methods of AST that take a section name instead of a Path use the same
+// syntax, without the key: "section" or "section/subsection". Use
+// [SectionName] to build it.
+type Path string
+
+// NewPath returns the Path of key in section ("" for the global section).
+func NewPath(section, key string) Path {
+	if section == "" {
+		return Path(escapeName(key))
+	}
+	return Path(escapeName(section) + "/" + escapeName(key))
+}
+
+// NewSubsectionPath returns the Path of key in section [section "subsection"].
+func NewSubsectionPath(section, subsection, key string) Path {
+	if subsection == "" {
+		return NewPath(section, key)
+	}
+	return Path(SectionName(section, subsection) + "/" + escapeName(key))
+}
+
+// SectionName returns the name that addresses section [section "subsection"]
+// in the methods of AST that take a section name. If subsection is "", it
+// addresses section [section].
+func SectionName(section, subsection string) string {
+	if subsection == "" {
+		return escapeName(section)
+	}
+	return escapeName(section) + "/" + escapeName(subsection)
+}
+
+// Section returns the section of p, or "" for the global section.
+func (p Path) Section() string {
+	section, _, _ := p.split()
+	return section
+}
+
+// Subsection returns the subsection of p, or "" if none.
+func (p Path) Subsection() string {
+	_, subsection, _ := p.split()
+	return subsection
+}
+
+// Key returns the key of p.
+func (p Path) Key() string {
+	_, _, key := p.split()
+	return key
+}
+
+// split returns the unescaped components of p.
+func (p Path) split() (section, subsection, key string) {
+	parts := splitEscaped(string(p))
+	key = parts[len(parts)-1]
+	if len(parts) > 1 {
+		section = parts[0]
+	}
+	if len(parts) > 2 {
+		subsection = strings.Join(parts[1:len(parts)-1], "/")
+	}
+	return section, subsection, key
+}
+
+// splitSectionName returns the unescaped section and subsection of name, in
+// the syntax of [SectionName].
+func splitSectionName(name string) (section, subsection string) {
+	parts := splitEscaped(name)
+	return parts[0], strings.Join(parts[1:], "/")
+}
+
+// splitEscaped splits s at each unescaped '/' and unescapes the parts. It
+// returns at least one part.
+func splitEscaped(s string) []string {
+	if !strings.ContainsRune(s, '\\') {
+		return strings.Split(s, "/")
+	}
+	var parts []string
+	var bld strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s):
+			i++
+			bld.WriteByte(s[i])
+		case c == '/':
+			parts = append(parts, bld.String())
+			bld.Reset()
+		default:
+			bld.WriteByte(c)
+		}
+	}
+	return append(parts, bld.String())
+}
+
+// escapeName escapes the '/' and '\' in name.
+func escapeName(name string) string {
+	if !strings.ContainsAny(name, `/\`) {
+		return name
+	}
+	var bld strings.Builder
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c == '/' || c == '\\' {
+			bld.WriteByte('\\')
+		}
+		bld.WriteByte(name[i])
+	}
+	return bld.String()
+}
+
+// cutSectionName cuts name, in the syntax of [SectionName], before the first
+// unescaped "/", which separates the section from the subsection. Both parts
+// stay escaped.
+func cutSectionName(name string) (section, rest string) {
+	for i := 0; i < len(name); i++ {
+		switch name[i] {
+		case '\\':
+			i++
+		case '/':
+			return name[:i], name[i:]
+		}
+	}
+	return name, ""
+}
+
+// sectionKey returns secName, in the syntax of [SectionName], in the canonical
+// form used to match sections.
+func sectionKey(secName string) string {
+	return SectionName(splitSectionName(secName))
+}
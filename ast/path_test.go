@@ -0,0 +1,138 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast_test
+
+import (
+	"testing"
+
+	"github.com/go-quicktest/qt"
+
+	"github.com/marco-m/roundtrip_ini/ast"
+)
+
+func TestPathComponents(t *testing.T) {
+	testCases := []struct {
+		path           ast.Path
+		wantSection    string
+		wantSubsection string
+		wantKey        string
+	}{
+		{path: "", wantKey: ""},
+		{path: "a", wantKey: "a"},
+		{path: "/a", wantKey: "a"},
+		{path: "s1/a", wantSection: "s1", wantKey: "a"},
+		{path: "s1/", wantSection: "s1", wantKey: ""},
+		{path: "s1/sub/a", wantSection: "s1", wantSubsection: "sub", wantKey: "a"},
+		{path: "branch/feature/x/merge", wantSection: "branch",
+			wantSubsection: "feature/x", wantKey: "merge"},
+		{path: `remote\/origin/url`, wantSection: "remote/origin", wantKey: "url"},
+		{path: `a\/b`, wantKey: "a/b"},
+		{path: `s1\\/a`, wantSection: `s1\`, wantKey: "a"},
+		{path: `s1/a\`, wantSection: "s1", wantKey: `a\`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.path), func(t *testing.T) {
+			qt.Check(t, qt.Equals(tc.path.Section(), tc.wantSection))
+			qt.Check(t, qt.Equals(tc.path.Subsection(), tc.wantSubsection))
+			qt.Check(t, qt.Equals(tc.path.Key(), tc.wantKey))
+		})
+	}
+}
+
+func TestPathConstructors(t *testing.T) {
+	testCases := []struct {
+		name       string
+		section    string
+		subsection string
+		key        string
+		want       ast.Path
+	}{
+		{name: "global", key: "a", want: "a"},
+		{name: "section", section: "s1", key: "a", want: "s1/a"},
+		{name: "subsection", section: "s1", subsection: "sub", key: "a", want: "s1/sub/a"},
+		{name: "escapes", section: "http://proxy", subsection: `x\y`, key: "a/b",
+			want: `http:\/\/proxy/x\\y/a\/b`},
+		{name: "key with slash in global section", key: "a/b", want: `a\/b`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := ast.NewSubsectionPath(tc.section, tc.subsection, tc.key)
+
+			qt.Assert(t, qt.Equals(path, tc.want))
+			qt.Assert(t, qt.Equals(path.Section(), tc.section))
+			qt.Assert(t, qt.Equals(path.Subsection(), tc.subsection))
+			qt.Assert(t, qt.Equals(path.Key(), tc.key))
+		})
+	}
+}
+
+func TestPathSubsections(t *testing.T) {
+	input := `
+[remote "origin"]
+url = "a"
+[remote "up/stream"]
+url = "b"
+[remote]
+url = "c"`
+	tree := parse(t, input)
+
+	qt.Assert(t, qt.Equals(tree.String(), normalizeEnds(input)))
+	qt.Assert(t, qt.Equals(tree.Sections[0].Name, "remote"))
+	qt.Assert(t, qt.Equals(tree.Sections[0].Subsection, "origin"))
+
+	checkValue(t, tree, "remote/origin/url", ast.String{Value: "a"})
+	checkValue(t, tree, "remote/up/stream/url", ast.String{Value: "b"})
+	checkValue(t, tree, ast.NewSubsectionPath("remote", "up/stream", "url"),
+		ast.String{Value: "b"})
+	checkValue(t, tree, "remote/url", ast.String{Value: "c"})
+	qt.Assert(t, qt.Equals(tree.LookupSection("remote/origin"), tree.Sections[0]))
+	qt.Assert(t, qt.Equals(tree.LookupSection(ast.SectionName("remote", "up/stream")),
+		tree.Sections[1]))
+
+	_, outcome := tree.Add("branch/main/merge", ast.String{Value: "main"})
+	qt.Assert(t, qt.Equals(outcome, ast.Created))
+	qt.Assert(t, qt.IsNil(tree.RenameSection("remote/origin", "remote/old")))
+	_, outcome = tree.RemoveSection("remote")
+	qt.Assert(t, qt.Equals(outcome, ast.Removed))
+
+	want := `
+[remote "old"]
+url = "a"
+[remote "up/stream"]
+url = "b"
+[branch "main"]
+merge = "main"`
+	qt.Assert(t, qt.Equals(tree.String(), normalizeEnds(want)))
+}
+
+func TestPathSectionNameWithSlash(t *testing.T) {
+	parser := ast.NewParser(ast.WithStrict(false))
+	tree, err := parser.ParseString("", "[remote/origin]\nurl = \"a\"\n[http://proxy]\nport = 8080")
+	qt.Assert(t, qt.IsNil(err))
+
+	checkValue(t, tree, `remote\/origin/url`, ast.String{Value: "a"})
+	checkValue(t, tree, ast.NewPath("remote/origin", "url"), ast.String{Value: "a"})
+	checkValue(t, tree, ast.NewPath("http://proxy", "port"), ast.Number{Value: 8080})
+	qt.Assert(t, qt.IsNil(tree.Lookup("remote/origin/url")))
+	qt.Assert(t, qt.IsNotNil(tree.LookupSection(ast.SectionName("http://proxy", ""))))
+}
+
+func TestPathKeyWithSlash(t *testing.T) {
+	parser := ast.NewParser(ast.WithStrict(false))
+	tree, err := parser.ParseString("", "a/b = 1\n[s1]\nc/d = 2")
+	qt.Assert(t, qt.IsNil(err))
+
+	checkValue(t, tree, `a\/b`, ast.Number{Value: 1})
+	checkValue(t, tree, ast.NewPath("s1", "c/d"), ast.Number{Value: 2})
+}
+
+// Assert that keyPath exists in tree and has value want.
+func checkValue(t *testing.T, tree *ast.AST, keyPath ast.Path, want ast.Value) {
+	t.Helper()
+	prop := tree.Lookup(keyPath)
+	qt.Assert(t, qt.IsNotNil(prop), qt.Commentf("keyPath: %s", keyPath))
+	qt.Assert(t, qt.Equals(prop.Value, want))
+}
@@ -0,0 +1,285 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Pattern selects properties by section and key names, for [AST.Query].
+// Create it with [CompileGlob] or [CompileRegexp].
+type Pattern struct {
+	src string
+	// Glob patterns.
+	global     bool           // match only the global section
+	section    *regexp.Regexp // unescaped section name
+	subsection *regexp.Regexp // unescaped subsection; nil means no subsection
+	// Regexp patterns.
+	sectionName *regexp.Regexp // section name, in the syntax of SectionName
+	// Both.
+	key *regexp.Regexp
+	// Case-insensitive variants of the glob regexps, used with
+	// case-insensitive trees. Subsections are always case-sensitive.
+	sectionFold, keyFold *regexp.Regexp
+}
+
+// CompileGlob compiles a glob pattern with the syntax of [Path]: "key",
+// "section/key" or "section/subsection/key". In each component,
+//   - '*' matches any sequence of characters, including '/'
+//   - '?' matches any single character
+//   - '[' class ']' matches a character of the class, as in "[a-z]" or,
+//     negated, "[!a-z]" or "[^a-z]"
+//   - '\' escapes the next character, as in "\*" or "\/"
+//
+// The number of components decides what is matched: "timeout" matches only
+// the global section, "*/timeout" matches the sections without a subsection
+// and "*/*/timeout" the sections with a subsection. For example,
+// "db-*/host" matches key host of sections [db-1] and [db-main].
+//
+// On a case-insensitive tree (see [AST.SetCaseInsensitive]) the pattern
+// matches without regard to case.
+func CompileGlob(pattern string) (*Pattern, error) {
+	parts := splitGlob(pattern)
+	p := &Pattern{src: pattern}
+	var sec, sub, key string
+	switch len(parts) {
+	case 1:
+		p.global = true
+		key = parts[0]
+	case 2:
+		sec, key = parts[0], parts[1]
+	default:
+		sec, key = parts[0], parts[len(parts)-1]
+		sub = strings.Join(parts[1:len(parts)-1], "/")
+	}
+
+	var err error
+	compile := func(glob string) (re, fold *regexp.Regexp) {
+		if err != nil {
+			return nil, nil
+		}
+		var expr string
+		if expr, err = globToRegexp(glob); err != nil {
+			return nil, nil
+		}
+		if re, err = regexp.Compile(expr); err != nil {
+			return nil, nil
+		}
+		return re, regexp.MustCompile("(?i)" + expr)
+	}
+	p.key, p.keyFold = compile(key)
+	if !p.global {
+		p.section, p.sectionFold = compile(sec)
+	}
+	if len(parts) > 2 {
+		p.subsection, _ = compile(sub)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ast: glob %q: %w", pattern, err)
+	}
+	return p, nil
+}
+
+// CompileRegexp compiles a pattern made of two regular expressions, in the
+// syntax of package [regexp]: section is matched against the section name, in
+// the syntax of [SectionName] ("" for the global section), and key against the
+// key. As with [regexp.Regexp.MatchString], a regular expression matches if it
+// matches any part of the name, so an empty regular expression matches every
+// name. For example, CompileRegexp("", `^log\.`) matches the keys starting with
+// "log." in all the sections, and CompileRegexp("^$", "") matches all the
+// keys of the global section.
+//
+// The regular expressions are case-sensitive, also on case-insensitive trees,
+// unless they use the "(?i)" flag.
+func CompileRegexp(section, key string) (*Pattern, error) {
+	secRE, err := regexp.Compile(section)
+	if err != nil {
+		return nil, fmt.Errorf("ast: section regexp: %w", err)
+	}
+	keyRE, err := regexp.Compile(key)
+	if err != nil {
+		return nil, fmt.Errorf("ast: key regexp: %w", err)
+	}
+	return &Pattern{
+		src:         fmt.Sprintf("%q %q", section, key),
+		sectionName: secRE,
+		key:         keyRE,
+	}, nil
+}
+
+// String returns the source of the pattern.
+func (p *Pattern) String() string {
+	return p.src
+}
+
+// Match reports whether keyPath matches the pattern. The match is
+// case-sensitive.
+func (p *Pattern) Match(keyPath Path) bool {
+	section, subsection, key := keyPath.split()
+	return p.match(section, subsection, key, false)
+}
+
+// matchSection reports whether the section named section and subsection
+// matches p. Section "" is the global section.
+func (p *Pattern) matchSection(section, subsection string, fold bool) bool {
+	if p.sectionName != nil {
+		return p.sectionName.MatchString(SectionName(section, subsection))
+	}
+	if p.global || section == "" {
+		return p.global && section == ""
+	}
+	if p.subsection == nil {
+		return subsection == "" && pick(p.section, p.sectionFold, fold).MatchString(section)
+	}
+	return subsection != "" &&
+		pick(p.section, p.sectionFold, fold).MatchString(section) &&
+		p.subsection.MatchString(subsection)
+}
+
+func (p *Pattern) match(section, subsection, key string, fold bool) bool {
+	return p.matchSection(section, subsection, fold) && p.matchKey(key, fold)
+}
+
+func (p *Pattern) matchKey(key string, fold bool) bool {
+	return pick(p.key, p.keyFold, fold).MatchString(key)
+}
+
+// pick returns foldRE if fold is true and foldRE is not nil, re otherwise.
+func pick(re, foldRE *regexp.Regexp, fold bool) *regexp.Regexp {
+	if fold && foldRE != nil {
+		return foldRE
+	}
+	return re
+}
+
+// Match is a property selected by [AST.Query].
+type Match struct {
+	Path     Path
+	Property *Property
+}
+
+// Query returns the properties that match p, in document order.
+func (tree *AST) Query(p *Pattern) []Match {
+	var matches []Match
+	if p.matchSection("", "", tree.foldCase) {
+		for _, prop := range tree.Properties {
+			if p.matchKey(prop.Key, tree.foldCase) {
+				matches = append(matches, Match{pathOf(nil, prop), prop})
+			}
+		}
+	}
+	for _, sec := range tree.Sections {
+		if !p.matchSection(sec.Name, sec.Subsection, tree.foldCase) {
+			continue
+		}
+		for _, prop := range sec.Properties {
+			if p.matchKey(prop.Key, tree.foldCase) {
+				matches = append(matches, Match{pathOf(sec, prop), prop})
+			}
+		}
+	}
+	return matches
+}
+
+// RemoveMatching removes the properties that match p and returns them, in
+// document order.
+func (tree *AST) RemoveMatching(p *Pattern) []Match {
+	var removed []Match
+	filter := func(sec *Section) {
+		props := tree.properties(sec)
+		kept := (*props)[:0]
+		for _, prop := range *props {
+			if p.matchKey(prop.Key, tree.foldCase) {
+				removed = append(removed, Match{pathOf(sec, prop), prop})
+			} else {
+				kept = append(kept, prop)
+			}
+		}
+		clear((*props)[len(kept):])
+		*props = kept
+	}
+
+	if p.matchSection("", "", tree.foldCase) {
+		filter(nil)
+	}
+	for _, sec := range tree.Sections {
+		if p.matchSection(sec.Name, sec.Subsection, tree.foldCase) {
+			filter(sec)
+		}
+	}
+	if len(removed) > 0 {
+		tree.Reindex()
+	}
+	return removed
+}
+
+// SetMatching sets the value of the properties that match p to value and
+// returns the properties whose value changed, in document order.
+func (tree *AST) SetMatching(p *Pattern, value Value) []Match {
+	var updated []Match
+	for _, m := range tree.Query(p) {
+		if m.Property.Value != value {
+			m.Property.Value = value
+			updated = append(updated, m)
+		}
+	}
+	return updated
+}
+
+// splitGlob splits pattern at each unescaped '/', keeping the escapes.
+func splitGlob(pattern string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '/':
+			parts = append(parts, pattern[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, pattern[start:])
+}
+
+// globToRegexp converts a glob pattern, without '/' separators, to an
+// anchored regular expression.
+func globToRegexp(glob string) (string, error) {
+	var bld strings.Builder
+	bld.WriteString(`^(?s:`)
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			bld.WriteString(`.*`)
+		case '?':
+			bld.WriteString(`.`)
+		case '\\':
+			if i+1 == len(glob) {
+				return "", fmt.Errorf("trailing backslash")
+			}
+			i++
+			bld.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end == -1 {
+				return "", fmt.Errorf("missing closing ]")
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			if class == "" || class == "^" {
+				return "", fmt.Errorf("empty character class")
+			}
+			bld.WriteString("[" + strings.ReplaceAll(class, `[`, `\[`) + "]")
+			i += end + 1
+		default:
+			bld.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	bld.WriteString(`)$`)
+	return bld.String(), nil
+}
@@ -0,0 +1,223 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast_test
+
+import (
+	"testing"
+
+	"github.com/go-quicktest/qt"
+
+	"github.com/marco-m/roundtrip_ini/ast"
+)
+
+const queryInput = `
+timeout = 1
+log.level = "info"
+[db-1]
+host = "a"
+timeout = 2
+[db-main]
+host = "b"
+log.file = "x"
+[web]
+timeout = 3
+[remote "origin"]
+timeout = 4
+[remote "up/stream"]
+timeout = 5`
+
+func TestQuery(t *testing.T) {
+	testCases := []struct {
+		name    string
+		pattern func() (*ast.Pattern, error)
+		want    []ast.Path
+	}{
+		{
+			name:    "glob, global section",
+			pattern: func() (*ast.Pattern, error) { return ast.CompileGlob("timeout") },
+			want:    []ast.Path{"timeout"},
+		},
+		{
+			name:    "glob, every section",
+			pattern: func() (*ast.Pattern, error) { return ast.CompileGlob("*/timeout") },
+			want:    []ast.Path{"db-1/timeout", "web/timeout"},
+		},
+		{
+			name:    "glob, every subsection",
+			pattern: func() (*ast.Pattern, error) { return ast.CompileGlob("*/*/timeout") },
+			want:    []ast.Path{"remote/origin/timeout", `remote/up\/stream/timeout`},
+		},
+		{
+			name:    "glob, section prefix",
+			pattern: func() (*ast.Pattern, error) { return ast.CompileGlob("db-*/host") },
+			want:    []ast.Path{"db-1/host", "db-main/host"},
+		},
+		{
+			name:    "glob, question mark and class",
+			pattern: func() (*ast.Pattern, error) { return ast.CompileGlob("db-?/[a-h]*") },
+			want:    []ast.Path{"db-1/host"},
+		},
+		{
+			name:    "glob, negated class",
+			pattern: func() (*ast.Pattern, error) { return ast.CompileGlob("[!d]*/*") },
+			want:    []ast.Path{"web/timeout"},
+		},
+		{
+			name:    "glob, escaped slash in subsection",
+			pattern: func() (*ast.Pattern, error) { return ast.CompileGlob(`remote/up\/*/*`) },
+			want:    []ast.Path{`remote/up\/stream/timeout`},
+		},
+		{
+			name:    "glob, no match",
+			pattern: func() (*ast.Pattern, error) { return ast.CompileGlob("x/*") },
+		},
+		{
+			name:    "regexp, key prefix in every section",
+			pattern: func() (*ast.Pattern, error) { return ast.CompileRegexp("", `^log\.`) },
+			want:    []ast.Path{"log.level", "db-main/log.file"},
+		},
+		{
+			name:    "regexp, global section",
+			pattern: func() (*ast.Pattern, error) { return ast.CompileRegexp("^$", "") },
+			want:    []ast.Path{"timeout", "log.level"},
+		},
+		{
+			name: "regexp, subsection",
+			pattern: func() (*ast.Pattern, error) {
+				return ast.CompileRegexp(`^remote/`, "^timeout$")
+			},
+			want: []ast.Path{"remote/origin/timeout", `remote/up\/stream/timeout`},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tree, err := ast.NewParser(ast.WithStrict(false)).ParseString("", queryInput)
+			qt.Assert(t, qt.IsNil(err))
+			pattern, err := tc.pattern()
+			qt.Assert(t, qt.IsNil(err))
+
+			var have []ast.Path
+			for _, m := range tree.Query(pattern) {
+				qt.Assert(t, qt.Equals(tree.Lookup(m.Path), m.Property))
+				qt.Assert(t, qt.IsTrue(pattern.Match(m.Path)))
+				have = append(have, m.Path)
+			}
+
+			qt.Assert(t, qt.DeepEquals(have, tc.want))
+		})
+	}
+}
+
+func TestQueryCaseInsensitive(t *testing.T) {
+	tree := parse(t, "[Server]\nTimeout = 1")
+	pattern, err := ast.CompileGlob("server/timeout")
+	qt.Assert(t, qt.IsNil(err))
+
+	qt.Assert(t, qt.HasLen(tree.Query(pattern), 0))
+	tree.SetCaseInsensitive(true)
+	qt.Assert(t, qt.HasLen(tree.Query(pattern), 1))
+}
+
+func TestCompilePatternErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		compile func() error
+		wantErr string
+	}{
+		{
+			name: "glob, unterminated class",
+			compile: func() error {
+				_, err := ast.CompileGlob("s1/[a-")
+				return err
+			},
+			wantErr: `ast: glob "s1/[a-": missing closing ]`,
+		},
+		{
+			name: "glob, trailing backslash",
+			compile: func() error {
+				_, err := ast.CompileGlob(`s1/a\`)
+				return err
+			},
+			wantErr: `ast: glob "s1/a\\": trailing backslash`,
+		},
+		{
+			name: "glob, invalid class",
+			compile: func() error {
+				_, err := ast.CompileGlob("[z-a]")
+				return err
+			},
+			wantErr: "ast: glob \"[z-a]\": error parsing regexp: invalid character class range: `z-a`",
+		},
+		{
+			name: "regexp, invalid key",
+			compile: func() error {
+				_, err := ast.CompileRegexp("", "(")
+				return err
+			},
+			wantErr: "ast: key regexp: error parsing regexp: missing closing ): `(`",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.compile()
+
+			qt.Assert(t, qt.IsNotNil(err))
+			qt.Assert(t, qt.Equals(err.Error(), tc.wantErr))
+		})
+	}
+}
+
+func TestRemoveMatching(t *testing.T) {
+	tree := parse(t, `
+timeout = 1
+[s1]
+a = 1
+timeout = 2
+b = 2
+[s2]
+timeout = 3`)
+	tree.EnableIndex()
+	qt.Assert(t, qt.IsNotNil(tree.Lookup("s1/timeout")))
+	pattern, err := ast.CompileGlob("*/timeout")
+	qt.Assert(t, qt.IsNil(err))
+
+	removed := tree.RemoveMatching(pattern)
+
+	qt.Assert(t, qt.HasLen(removed, 2))
+	qt.Assert(t, qt.Equals(removed[0].Path, "s1/timeout"))
+	qt.Assert(t, qt.Equals(removed[1].Path, "s2/timeout"))
+	want := `
+timeout = 1
+[s1]
+a = 1
+b = 2
+[s2]`
+	qt.Assert(t, qt.Equals(tree.String(), normalizeEnds(want)))
+	qt.Assert(t, qt.IsNil(tree.Lookup("s1/timeout")))
+	checkLookups(t, tree)
+}
+
+func TestSetMatching(t *testing.T) {
+	tree := parse(t, `
+[s1]
+timeout = 5
+[s2]
+timeout = 3`)
+	pattern, err := ast.CompileGlob("*/timeout")
+	qt.Assert(t, qt.IsNil(err))
+
+	updated := tree.SetMatching(pattern, ast.Number{Value: 5})
+
+	qt.Assert(t, qt.HasLen(updated, 1))
+	qt.Assert(t, qt.Equals(updated[0].Path, "s2/timeout"))
+	want := `
+[s1]
+timeout = 5
+[s2]
+timeout = 5`
+	qt.Assert(t, qt.Equals(tree.String(), normalizeEnds(want)))
+	qt.Assert(t, qt.HasLen(tree.SetMatching(pattern, ast.Number{Value: 5}), 0))
+}
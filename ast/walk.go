@@ -0,0 +1,341 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast
+
+import "fmt"
+
+// Node is a node of the AST: *AST, *Section, *Property or *Comment.
+// Note that it is a sealed interface.
+type Node interface{ node() }
+
+func (*AST) node()      {} // sealed
+func (*Section) node()  {} // sealed
+func (*Property) node() {} // sealed
+func (*Comment) node()  {} // sealed
+
+// Comment is a comment line, including the comment marker.
+//
+// The comments of a Section or a Property are stored as strings in its
+// Comments field. [Walk], [Inspect] and [Apply] wrap each of them in a Comment
+// and, once the Comment has been visited, store its Text back in the Comments
+// field.
+type Comment struct {
+	Text string
+}
+
+// A Visitor's Visit method is invoked for each node encountered by [Walk].
+// If the result visitor w is not nil, Walk visits each of the children of
+// node with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses an AST in depth-first order: it starts by calling
+// v.Visit(node); node must not be nil. If the visitor w returned by
+// v.Visit(node) is not nil, Walk is invoked recursively with visitor w for
+// each of the non-nil children of node, followed by a call of w.Visit(nil).
+//
+// The children of an AST are its global properties, its sections and then
+// its comments at the end of the input. The children of a Section are its
+// comments and then its properties. The children of a Property are its
+// comments.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *AST:
+		for _, prop := range n.Properties {
+			Walk(v, prop)
+		}
+		for _, sec := range n.Sections {
+			Walk(v, sec)
+		}
+		walkComments(v, n.Comments)
+	case *Section:
+		walkComments(v, n.Comments)
+		for _, prop := range n.Properties {
+			Walk(v, prop)
+		}
+	case *Property:
+		walkComments(v, n.Comments)
+	case *Comment:
+		// nothing to do
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
+	}
+
+	v.Visit(nil)
+}
+
+func walkComments(v Visitor, comments []string) {
+	for i := range comments {
+		cmt := &Comment{Text: comments[i]}
+		Walk(v, cmt)
+		comments[i] = cmt.Text
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses an AST in depth-first order: it starts by calling
+// f(node); node must not be nil. If f returns true, Inspect invokes f
+// recursively for each of the non-nil children of node, followed by a call of
+// f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// An ApplyFunc is invoked by [Apply] for each node, before and/or after the
+// node's children, using a Cursor describing the current node and providing
+// operations on it.
+//
+// The return value of ApplyFunc controls the traversal. See Apply for details.
+type ApplyFunc func(*Cursor) bool
+
+// Apply traverses an AST recursively, starting with root, and calling pre and
+// post for each node as described below. Apply returns root, possibly
+// modified, or its replacement.
+//
+// If pre is not nil, it is called for each node before the node's children
+// are traversed (pre-order). If pre returns false, no children are traversed,
+// and post is not called for that node.
+//
+// If post is not nil, and a prior call of pre didn't return false, post is
+// called for each node after its children are traversed (post-order). If post
+// returns false, traversal is terminated and Apply returns immediately.
+//
+// The children are the same as for [Walk]. Only the children, not the root,
+// can be deleted. The children of a node deleted or replaced by pre are not
+// traversed; post is called for the replacement node.
+//
+// If root is an *AST with the index enabled (see [AST.EnableIndex]) and Apply
+// changes it, the index is rebuilt. If root is a *Section of an indexed AST,
+// call [AST.Reindex] after Apply.
+func Apply(root Node, pre, post ApplyFunc) (result Node) {
+	parent := &rootList{node: root}
+	defer func() {
+		if r := recover(); r != nil && r != abort {
+			panic(r)
+		}
+		result = parent.node
+		if tree, ok := result.(*AST); ok && parent.changed {
+			tree.Reindex()
+		}
+	}()
+
+	a := &applier{pre: pre, post: post}
+	a.apply(nil, parent, &iterator{})
+	return parent.node
+}
+
+var abort = new(int) // singleton, to signal termination of Apply
+
+// A Cursor describes a node encountered during [Apply]. Information about the
+// node and its parent is available from the Node, Parent and Index methods.
+type Cursor struct {
+	parent   Node
+	list     nodeList
+	iter     *iterator
+	node     Node
+	replaced bool
+}
+
+// Node returns the current Node, or nil if it has been deleted.
+func (c *Cursor) Node() Node { return c.node }
+
+// Parent returns the parent of the current Node, or nil for the root.
+func (c *Cursor) Parent() Node { return c.parent }
+
+// Index returns the index of the current Node among the children of the same
+// kind of its parent (the comments, properties or sections), or -1 for the
+// root.
+func (c *Cursor) Index() int {
+	if c.parent == nil {
+		return -1
+	}
+	return c.iter.index
+}
+
+// Replace replaces the current Node with n. The replacement node is not
+// walked by Apply. Replace panics if n is not of the same type as the current
+// node.
+func (c *Cursor) Replace(n Node) {
+	c.list.set(c.iter.index, n)
+	c.node = n
+	c.replaced = true
+}
+
+// Delete deletes the current Node from its containing slice. Delete panics if
+// the current node is the root.
+func (c *Cursor) Delete() {
+	c.list.delete(c.iter.index)
+	c.iter.step--
+	c.node = nil
+}
+
+// iterator tracks the position of the current node in its list.
+type iterator struct {
+	index, step int
+}
+
+type applier struct {
+	pre, post ApplyFunc
+	cursor    Cursor
+}
+
+func (a *applier) apply(parent Node, list nodeList, iter *iterator) {
+	saved := a.cursor
+	a.cursor = Cursor{parent: parent, list: list, iter: iter, node: list.at(iter.index)}
+	defer func() { a.cursor = saved }()
+
+	if a.pre != nil && !a.pre(&a.cursor) {
+		a.sync()
+		return
+	}
+	if a.cursor.node == nil {
+		return // deleted by pre
+	}
+	if !a.cursor.replaced {
+		a.children(a.cursor.node, list)
+	}
+	if a.post != nil && !a.post(&a.cursor) {
+		a.sync()
+		panic(abort)
+	}
+	a.sync()
+}
+
+// children applies a to the children of n.
+func (a *applier) children(n Node, list nodeList) {
+	root := rootOf(list)
+	switch n := n.(type) {
+	case *AST:
+		a.applyList(n, &sliceList[*Property]{a: &n.Properties, root: root})
+		a.applyList(n, &sliceList[*Section]{a: &n.Sections, root: root})
+		a.applyList(n, &commentList{a: &n.Comments})
+	case *Section:
+		a.applyList(n, &commentList{a: &n.Comments})
+		a.applyList(n, &sliceList[*Property]{a: &n.Properties, root: root})
+	case *Property:
+		a.applyList(n, &commentList{a: &n.Comments})
+	case *Comment:
+		// nothing to do
+	default:
+		panic(fmt.Sprintf("ast.Apply: unexpected node type %T", n))
+	}
+}
+
+// sync stores the current node back in its list, so that the changes to the
+// Text of a Comment are not lost.
+func (a *applier) sync() {
+	if cmt, ok := a.cursor.node.(*Comment); ok {
+		a.cursor.list.set(a.cursor.iter.index, cmt)
+	}
+}
+
+func (a *applier) applyList(parent Node, list nodeList) {
+	iter := &iterator{}
+	for iter.index = 0; iter.index < list.len(); iter.index += iter.step {
+		iter.step = 1
+		a.apply(parent, list, iter)
+	}
+}
+
+// nodeList is a slice of nodes of the same type.
+type nodeList interface {
+	len() int
+	at(i int) Node
+	set(i int, n Node)
+	delete(i int)
+}
+
+// rootOf returns the rootList from which list descends.
+func rootOf(list nodeList) *rootList {
+	switch l := list.(type) {
+	case *rootList:
+		return l
+	case *sliceList[*Property]:
+		return l.root
+	case *sliceList[*Section]:
+		return l.root
+	default:
+		return nil // comments have no children
+	}
+}
+
+// rootList is the list containing the root of Apply. It also records whether
+// Apply changed the structure of the tree.
+type rootList struct {
+	node    Node
+	changed bool
+}
+
+func (l *rootList) len() int      { return 1 }
+func (l *rootList) at(i int) Node { return l.node }
+
+func (l *rootList) set(i int, n Node) {
+	if fmt.Sprintf("%T", n) != fmt.Sprintf("%T", l.node) {
+		panic(fmt.Sprintf("ast.Cursor.Replace: cannot replace %T with %T", l.node, n))
+	}
+	if n != l.node {
+		l.node = n
+		l.changed = true
+	}
+}
+
+func (l *rootList) delete(i int) {
+	panic("ast.Cursor.Delete: cannot delete the root")
+}
+
+// sliceList is a list of properties or sections.
+type sliceList[E Node] struct {
+	a    *[]E
+	root *rootList
+}
+
+func (l *sliceList[E]) len() int      { return len(*l.a) }
+func (l *sliceList[E]) at(i int) Node { return (*l.a)[i] }
+
+func (l *sliceList[E]) set(i int, n Node) {
+	e, ok := n.(E)
+	if !ok {
+		panic(fmt.Sprintf("ast.Cursor.Replace: cannot replace %T with %T", (*l.a)[i], n))
+	}
+	(*l.a)[i] = e
+	l.root.changed = true
+}
+
+func (l *sliceList[E]) delete(i int) {
+	*l.a = removeFromSlice(*l.a, i)
+	l.root.changed = true
+}
+
+// commentList is a list of comments, stored as strings.
+type commentList struct {
+	a *[]string
+}
+
+func (l *commentList) len() int      { return len(*l.a) }
+func (l *commentList) at(i int) Node { return &Comment{Text: (*l.a)[i]} }
+
+func (l *commentList) set(i int, n Node) {
+	cmt, ok := n.(*Comment)
+	if !ok || cmt == nil {
+		panic(fmt.Sprintf("ast.Cursor.Replace: cannot replace *ast.Comment with %T", n))
+	}
+	(*l.a)[i] = cmt.Text
+}
+
+func (l *commentList) delete(i int) {
+	*l.a = removeFromSlice(*l.a, i)
+}
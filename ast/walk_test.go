@@ -0,0 +1,342 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package ast_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+
+	"github.com/marco-m/roundtrip_ini/ast"
+)
+
+const walkInput = `
+# comment for a
+a = 1
+[s1]
+# comment for b
+# line 2 for b
+b = 2
+# comment for s2
+[s2]
+c = 3`
+
+// describe returns a short description of node.
+func describe(node ast.Node) string {
+	switch n := node.(type) {
+	case nil:
+		return "end"
+	case *ast.AST:
+		return "ast"
+	case *ast.Section:
+		return "section " + n.Name
+	case *ast.Property:
+		return "property " + n.Key
+	case *ast.Comment:
+		return "comment " + n.Text
+	default:
+		return fmt.Sprintf("unexpected %T", n)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	tree := parse(t, walkInput)
+
+	var have []string
+	ast.Inspect(tree, func(node ast.Node) bool {
+		if node != nil {
+			have = append(have, describe(node))
+		}
+		return true
+	})
+
+	want := []string{
+		"ast",
+		"property a",
+		"comment # comment for a",
+		"section s1",
+		"property b",
+		"comment # comment for b",
+		"comment # line 2 for b",
+		"section s2",
+		"comment # comment for s2",
+		"property c",
+	}
+	qt.Assert(t, qt.DeepEquals(have, want))
+}
+
+func TestInspectSkipsChildren(t *testing.T) {
+	tree := parse(t, walkInput)
+
+	var have []string
+	ast.Inspect(tree, func(node ast.Node) bool {
+		if node != nil {
+			have = append(have, describe(node))
+		}
+		_, isSection := node.(*ast.Section)
+		return !isSection
+	})
+
+	want := []string{"ast", "property a", "comment # comment for a", "section s1", "section s2"}
+	qt.Assert(t, qt.DeepEquals(have, want))
+}
+
+type depthVisitor struct {
+	depth int
+	lines *[]string
+}
+
+func (v depthVisitor) Visit(node ast.Node) ast.Visitor {
+	*v.lines = append(*v.lines, strings.Repeat(".", v.depth)+describe(node))
+	if node == nil {
+		return nil
+	}
+	return depthVisitor{depth: v.depth + 1, lines: v.lines}
+}
+
+func TestWalk(t *testing.T) {
+	tree := parse(t, "a = 1\n[s1]\n# comment\nb = 2")
+
+	var have []string
+	ast.Walk(depthVisitor{lines: &have}, tree)
+
+	want := []string{
+		"ast",
+		".property a",
+		"..end",
+		".section s1",
+		"..property b",
+		"...comment # comment",
+		"....end",
+		"...end",
+		"..end",
+		".end",
+	}
+	qt.Assert(t, qt.DeepEquals(have, want))
+}
+
+func TestWalkChangesComments(t *testing.T) {
+	tree := parse(t, walkInput)
+
+	ast.Inspect(tree, func(node ast.Node) bool {
+		if cmt, ok := node.(*ast.Comment); ok {
+			cmt.Text = strings.ToUpper(cmt.Text)
+		}
+		return true
+	})
+
+	qt.Assert(t, qt.DeepEquals(tree.Lookup("s1/b").Comments,
+		[]string{"# COMMENT FOR B", "# LINE 2 FOR B"}))
+}
+
+func TestInspectCommentsAtEnd(t *testing.T) {
+	tree := parse(t, "a = 1\n[s1]\nb = 2\n# end 1\n# end 2")
+
+	var have []string
+	ast.Inspect(tree, func(node ast.Node) bool {
+		if cmt, ok := node.(*ast.Comment); ok {
+			cmt.Text = strings.ToUpper(cmt.Text)
+		}
+		if node != nil {
+			have = append(have, describe(node))
+		}
+		return true
+	})
+
+	want := []string{"ast", "property a", "section s1", "property b",
+		"comment # END 1", "comment # END 2"}
+	qt.Assert(t, qt.DeepEquals(have, want))
+	qt.Assert(t, qt.DeepEquals(tree.Comments, []string{"# END 1", "# END 2"}))
+}
+
+func TestApply(t *testing.T) {
+	type testCase struct {
+		name string
+		pre  ast.ApplyFunc
+		post ast.ApplyFunc
+		want string
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		tree := parse(t, walkInput)
+		tree.EnableIndex()
+		qt.Assert(t, qt.IsNotNil(tree.Lookup("s1/b")))
+
+		result := ast.Apply(tree, tc.pre, tc.post)
+
+		qt.Assert(t, qt.Equals(result, ast.Node(tree)))
+		qt.Assert(t, qt.Equals(tree.String(), normalizeEnds(tc.want)))
+		checkLookups(t, tree)
+	}
+
+	testCases := []testCase{
+		{
+			name: "nothing",
+			want: walkInput,
+		},
+		{
+			name: "delete comments",
+			pre: func(c *ast.Cursor) bool {
+				if _, ok := c.Node().(*ast.Comment); ok {
+					c.Delete()
+				}
+				return true
+			},
+			want: `
+a = 1
+[s1]
+b = 2
+[s2]
+c = 3`,
+		},
+		{
+			name: "delete in post",
+			post: func(c *ast.Cursor) bool {
+				switch n := c.Node().(type) {
+				case *ast.Property:
+					if n.Key == "a" {
+						c.Delete()
+					}
+				case *ast.Section:
+					if n.Name == "s1" {
+						c.Delete()
+					}
+				}
+				return true
+			},
+			want: `
+# comment for s2
+[s2]
+c = 3`,
+		},
+		{
+			name: "replace property",
+			pre: func(c *ast.Cursor) bool {
+				if prop, ok := c.Node().(*ast.Property); ok && prop.Key == "b" {
+					c.Replace(&ast.Property{Key: "x", Value: ast.String{Value: "new"}})
+				}
+				return true
+			},
+			want: `
+# comment for a
+a = 1
+[s1]
+x = "new"
+# comment for s2
+[s2]
+c = 3`,
+		},
+		{
+			name: "change comment in post",
+			post: func(c *ast.Cursor) bool {
+				if cmt, ok := c.Node().(*ast.Comment); ok {
+					if _, ok := c.Parent().(*ast.Section); ok {
+						cmt.Text = "; section comment"
+					}
+				}
+				return true
+			},
+			want: `
+# comment for a
+a = 1
+[s1]
+# comment for b
+# line 2 for b
+b = 2
+; section comment
+[s2]
+c = 3`,
+		},
+		{
+			name: "stop",
+			post: func(c *ast.Cursor) bool {
+				if prop, ok := c.Node().(*ast.Property); ok {
+					prop.Value = ast.Number{Value: 0}
+					return prop.Key != "b"
+				}
+				return true
+			},
+			want: `
+# comment for a
+a = 0
+[s1]
+# comment for b
+# line 2 for b
+b = 0
+# comment for s2
+[s2]
+c = 3`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) { run(t, tc) })
+	}
+}
+
+func TestApplyCursor(t *testing.T) {
+	tree := parse(t, walkInput)
+
+	var have []string
+	ast.Apply(tree, func(c *ast.Cursor) bool {
+		have = append(have, fmt.Sprintf("%s: parent %s, index %d",
+			describe(c.Node()), describeParent(c.Parent()), c.Index()))
+		return true
+	}, nil)
+
+	want := []string{
+		"ast: parent none, index -1",
+		"property a: parent ast, index 0",
+		"comment # comment for a: parent property a, index 0",
+		"section s1: parent ast, index 0",
+		"property b: parent section s1, index 0",
+		"comment # comment for b: parent property b, index 0",
+		"comment # line 2 for b: parent property b, index 1",
+		"section s2: parent ast, index 1",
+		"comment # comment for s2: parent section s2, index 0",
+		"property c: parent section s2, index 0",
+	}
+	qt.Assert(t, qt.DeepEquals(have, want))
+}
+
+func TestApplyReplaceRoot(t *testing.T) {
+	tree := parse(t, walkInput)
+	other := parse(t, "z = 1")
+
+	result := ast.Apply(tree, func(c *ast.Cursor) bool {
+		c.Replace(other)
+		return true
+	}, nil)
+
+	qt.Assert(t, qt.Equals(result, ast.Node(other)))
+}
+
+func TestApplyPanics(t *testing.T) {
+	tree := parse(t, walkInput)
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		ast.Apply(tree, func(c *ast.Cursor) bool {
+			c.Delete()
+			return true
+		}, nil)
+	}, `ast.Cursor.Delete: cannot delete the root`))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		ast.Apply(tree, func(c *ast.Cursor) bool {
+			if _, ok := c.Node().(*ast.Property); ok {
+				c.Replace(&ast.Section{})
+			}
+			return true
+		}, nil)
+	}, `ast.Cursor.Replace: cannot replace \*ast.Property with \*ast.Section`))
+}
+
+func describeParent(node ast.Node) string {
+	if node == nil {
+		return "none"
+	}
+	return describe(node)
+}
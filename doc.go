@@ -0,0 +1,10 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+// Package roundtrip_ini performs high-level decoding, editing and encoding of
+// the INI format, preserving comments and blank lines.
+//
+// It builds on package [github.com/marco-m/roundtrip_ini/ast], which
+// represents a single INI file. This package deals with configurations made
+// of several files, such as [Layers].
+package roundtrip_ini
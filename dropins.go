@@ -0,0 +1,138 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package roundtrip_ini
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/marco-m/roundtrip_ini/ast"
+)
+
+// DropIns is a configuration made of a main file, such as foo.service, and of
+// the drop-in files of directory foo.service.d with extension DropInExt, such
+// as foo.service.d/10-local.conf, as read by systemd and many daemons.
+//
+// DropIns is a [Layers] stack: the main file is layer 0 and the drop-in files
+// follow in lexical order of their names, so that a drop-in file overrides
+// the main file and the drop-in files that sort before it.
+type DropIns struct {
+	*Layers
+	dir    string // the drop-in directory
+	fsys   fs.FS  // nil for the operating system
+	parser *ast.Parser
+}
+
+// DropInExt is the extension of the drop-in files. The other files of the
+// drop-in directory, such as the backup files of an editor, are ignored.
+const DropInExt = ".conf"
+
+// LoadDropIns reads the main file filename and the drop-in files of directory
+// filename+".d", with a parser configured by opts. If filename doesn't exist,
+// layer 0 is empty. If the directory doesn't exist, there are no drop-in
+// files.
+func LoadDropIns(filename string, opts ...ast.Option) (*DropIns, error) {
+	return loadDropIns(nil, filename, opts)
+}
+
+// LoadDropInsFS is like [LoadDropIns], but reads the files from fsys. To
+// edit the drop-in files, fsys must be a [WriteFileFS].
+func LoadDropInsFS(fsys fs.FS, filename string, opts ...ast.Option) (*DropIns, error) {
+	return loadDropIns(fsys, filename, opts)
+}
+
+func loadDropIns(fsys fs.FS, filename string, opts []ast.Option) (*DropIns, error) {
+	d := &DropIns{
+		dir:    filename + ".d",
+		fsys:   fsys,
+		parser: ast.NewParser(opts...),
+	}
+
+	var entries []fs.DirEntry
+	var err error
+	if fsys == nil {
+		entries, err = os.ReadDir(d.dir)
+	} else {
+		entries, err = fs.ReadDir(fsys, d.dir)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("roundtrip_ini: %w", err)
+	}
+
+	filenames := []string{filename}
+	for _, entry := range entries { // sorted by name
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), DropInExt) {
+			filenames = append(filenames, d.join(entry.Name()))
+		}
+	}
+	if d.Layers, err = loadLayers(fsys, filenames, opts); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// join returns the path of drop-in file name.
+func (d *DropIns) join(name string) string {
+	if d.fsys == nil {
+		return filepath.Join(d.dir, name)
+	}
+	return path.Join(d.dir, name)
+}
+
+// DropIn returns the index of the layer of drop-in file name, such as
+// "10-local.conf", or -1 if there is no such file.
+func (d *DropIns) DropIn(name string) int {
+	filename := d.join(name)
+	for i := 1; i < d.Len(); i++ {
+		if d.Layer(i).Filename == filename {
+			return i
+		}
+	}
+	return -1
+}
+
+// SetDropIn sets keyPath to value in drop-in file name, such as
+// "10-local.conf", and saves the file, leaving the main file and the other
+// drop-in files untouched. If the file doesn't exist, SetDropIn creates it,
+// and the drop-in directory if needed, and inserts its layer according to the
+// lexical order of name.
+//
+// SetDropIn returns an error if name is not a plain file name with extension
+// DropInExt.
+func (d *DropIns) SetDropIn(name string, keyPath ast.Path, value ast.Value) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || !strings.HasSuffix(name, DropInExt) {
+		return fmt.Errorf("roundtrip_ini: invalid drop-in file name %q (want extension %q)",
+			name, DropInExt)
+	}
+	if i := d.DropIn(name); i != -1 {
+		return d.Set(i, keyPath, value)
+	}
+
+	filename := d.join(name)
+	tree, err := d.parser.ParseString(filename, "")
+	if err != nil {
+		return fmt.Errorf("roundtrip_ini: %w", err)
+	}
+	tree.Add(keyPath, value)
+	if d.fsys == nil {
+		if err := os.MkdirAll(d.dir, 0o755); err != nil {
+			return fmt.Errorf("roundtrip_ini: %w", err)
+		}
+	}
+	if err := writeLayer(d.fsys, filename, tree); err != nil {
+		return err
+	}
+	i := 1
+	for i < d.Len() && d.Layer(i).Filename < filename {
+		i++
+	}
+	d.layers = slices.Insert(d.layers, i, &Layer{Filename: filename, Tree: tree, fsys: d.fsys})
+	return nil
+}
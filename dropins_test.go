@@ -0,0 +1,159 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package roundtrip_ini_test
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-quicktest/qt"
+
+	"github.com/marco-m/roundtrip_ini"
+	"github.com/marco-m/roundtrip_ini/ast"
+)
+
+var dropInFiles = map[string]string{
+	"foo.conf":                 "[Service]\n# vendor default\nTimeout = 10\nUser = \"root\"\n",
+	"foo.conf.d/20-site.conf":  "[Service]\nTimeout = 30\n",
+	"foo.conf.d/10-early.conf": "[Service]\nTimeout = 20\nUser = \"nobody\"\n",
+	"foo.conf.d/README":        "not a drop-in",
+	"foo.conf.d/sub.conf/x":    "not a drop-in",
+}
+
+func TestDropIns(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(), dropInFiles)
+	main := filepath.Join(dir, "foo.conf")
+
+	d, err := roundtrip_ini.LoadDropIns(main)
+	qt.Assert(t, qt.IsNil(err))
+
+	qt.Assert(t, qt.Equals(d.Len(), 3))
+	qt.Assert(t, qt.Equals(d.Layer(0).Filename, main))
+	qt.Assert(t, qt.Equals(d.Layer(1).Filename, filepath.Join(dir, "foo.conf.d", "10-early.conf")))
+	qt.Assert(t, qt.Equals(d.DropIn("20-site.conf"), 2))
+	qt.Assert(t, qt.Equals(d.DropIn("README"), -1))
+
+	setting, ok := d.Get("Service/Timeout")
+	qt.Assert(t, qt.IsTrue(ok))
+	qt.Assert(t, qt.Equals(setting.Value, ast.Value(ast.Number{Value: 30})))
+	qt.Assert(t, qt.Equals(setting.Filename, filepath.Join(dir, "foo.conf.d", "20-site.conf")))
+	qt.Assert(t, qt.HasLen(d.Shadowed("Service/Timeout"), 2))
+
+	setting, _ = d.Get("Service/User")
+	qt.Assert(t, qt.Equals(setting.Layer, 1))
+}
+
+func TestDropInsSet(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(), dropInFiles)
+	main := filepath.Join(dir, "foo.conf")
+	d, err := roundtrip_ini.LoadDropIns(main)
+	qt.Assert(t, qt.IsNil(err))
+
+	qt.Assert(t, qt.IsNil(d.SetDropIn("15-local.conf", "Service/User", ast.String{Value: "me"})))
+	qt.Assert(t, qt.IsNil(d.SetDropIn("20-site.conf", "Service/Nice", ast.Number{Value: 5})))
+
+	qt.Assert(t, qt.Equals(d.DropIn("15-local.conf"), 2))
+	setting, _ := d.Get("Service/User")
+	qt.Assert(t, qt.Equals(setting.Layer, 2))
+	qt.Assert(t, qt.Equals(readFile(t, main), dropInFiles["foo.conf"]))
+	qt.Assert(t, qt.Equals(readFile(t, filepath.Join(dir, "foo.conf.d", "15-local.conf")),
+		"[Service]\nUser = \"me\"\n"))
+	qt.Assert(t, qt.Equals(readFile(t, filepath.Join(dir, "foo.conf.d", "20-site.conf")),
+		"[Service]\nTimeout = 30\nNice = 5\n"))
+
+	reloaded, err := roundtrip_ini.LoadDropIns(main)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(reloaded.Len(), 4))
+	setting, _ = reloaded.Get("Service/User")
+	qt.Assert(t, qt.Equals(setting.Filename, filepath.Join(dir, "foo.conf.d", "15-local.conf")))
+}
+
+func TestDropInsMissing(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "bar.ini")
+
+	d, err := roundtrip_ini.LoadDropIns(main)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(d.Len(), 1))
+
+	qt.Assert(t, qt.IsNil(d.SetDropIn("50-x.conf", "a", ast.Number{Value: 1})))
+	qt.Assert(t, qt.Equals(readFile(t, filepath.Join(dir, "bar.ini.d", "50-x.conf")), "a = 1\n"))
+
+	err = d.SetDropIn("50-x.ini", "a", ast.Number{Value: 1})
+	qt.Assert(t, qt.ErrorMatches(err,
+		`roundtrip_ini: invalid drop-in file name "50-x.ini" \(want extension ".conf"\)`))
+	err = d.SetDropIn("../50-x.conf", "a", ast.Number{Value: 1})
+	qt.Assert(t, qt.ErrorMatches(err, `roundtrip_ini: invalid drop-in file name .*`))
+}
+
+func TestDropInsSystemdUnit(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(), map[string]string{
+		"u.service":                     "[Service]\nNice = 0\n",
+		"u.service.d/override.conf":     "[Service]\nNice = 5\n",
+		"u.service.d/override.conf~":    "[Service]\nNice = 9\n",
+		"u.service.d/override.service":  "[Service]\nNice = 9\n",
+		"other.service.d/override.conf": "[Service]\nNice = 9\n",
+	})
+
+	d, err := roundtrip_ini.LoadDropIns(filepath.Join(dir, "u.service"))
+
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(d.Len(), 2))
+	setting, ok := d.Get("Service/Nice")
+	qt.Assert(t, qt.IsTrue(ok))
+	qt.Assert(t, qt.Equals(setting.Filename, filepath.Join(dir, "u.service.d", "override.conf")))
+}
+
+func TestDropInsWithoutExtension(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(), map[string]string{
+		"foo":              "a = 1\n",
+		"foo.d/10-x.conf":  "a = 2\n",
+		"foo.d/10-x.conf~": "a = 3\n",
+	})
+
+	d, err := roundtrip_ini.LoadDropIns(filepath.Join(dir, "foo"))
+
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(d.Len(), 2))
+	setting, ok := d.Get("a")
+	qt.Assert(t, qt.IsTrue(ok))
+	qt.Assert(t, qt.Equals(setting.Value, ast.Value(ast.Number{Value: 2})))
+}
+
+// writableFS is a MapFS that can write files.
+type writableFS struct {
+	fstest.MapFS
+}
+
+func (fsys writableFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	fsys.MapFS[name] = &fstest.MapFile{Data: data, Mode: perm}
+	return nil
+}
+
+func TestDropInsFS(t *testing.T) {
+	mapFS := fstest.MapFS{}
+	for name, content := range dropInFiles {
+		mapFS[name] = &fstest.MapFile{Data: []byte(content)}
+	}
+
+	d, err := roundtrip_ini.LoadDropInsFS(mapFS, "foo.conf")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(d.Layer(2).Filename, "foo.conf.d/20-site.conf"))
+	setting, _ := d.Get("Service/Timeout")
+	qt.Assert(t, qt.Equals(setting.String(), "foo.conf.d/20-site.conf:2: 30"))
+
+	err = d.SetDropIn("30-x.conf", "Service/Nice", ast.Number{Value: 1})
+	qt.Assert(t, qt.IsTrue(errors.Is(err, errors.ErrUnsupported)))
+	qt.Assert(t, qt.Equals(d.Len(), 3))
+
+	wfs := writableFS{mapFS}
+	d, err = roundtrip_ini.LoadDropInsFS(wfs, "foo.conf")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.IsNil(d.SetDropIn("30-x.conf", "Service/Nice", ast.Number{Value: 1})))
+	qt.Assert(t, qt.Equals(string(mapFS["foo.conf.d/30-x.conf"].Data), "[Service]\nNice = 1\n"))
+	qt.Assert(t, qt.Equals(d.DropIn("30-x.conf"), 3))
+}
@@ -1,11 +1,8 @@
 module github.com/marco-m/roundtrip_ini
 
-go 1.21
+go 1.23
 
-require (
-	github.com/alecthomas/participle/v2 v2.1.0
-	github.com/go-quicktest/qt v1.101.0
-)
+require github.com/go-quicktest/qt v1.101.0
 
 require (
 	github.com/google/go-cmp v0.5.9 // indirect
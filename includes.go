@@ -0,0 +1,443 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package roundtrip_ini
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/marco-m/roundtrip_ini/ast"
+)
+
+// IncludeSyntax is the syntax of the include directives of a dialect of the
+// INI format.
+type IncludeSyntax int
+
+const (
+	// GitIncludes is the syntax of git config files: key path of section
+	// [include], as in
+	//
+	//	[include]
+	//	path = other.inc
+	//
+	// The conditional includes of the [includeIf "condition"] sections are
+	// not supported.
+	GitIncludes IncludeSyntax = iota + 1
+	// MySQLIncludes is the syntax of MySQL option files: the directives
+	// "!include file" and "!includedir dir", which includes the files of dir
+	// with extension .cnf, in lexical order.
+	MySQLIncludes
+	// MercurialIncludes is the syntax of Mercurial config files: the
+	// directive "%include file".
+	MercurialIncludes
+	// SambaIncludes is the syntax of Samba config files: key include of any
+	// section, as in "include = other.conf".
+	SambaIncludes
+)
+
+// DefaultMaxIncludeDepth is the maximum nesting of includes used when
+// IncludeConfig.MaxDepth is 0.
+const DefaultMaxIncludeDepth = 10
+
+// IncludeConfig configures [LoadIncludes].
+type IncludeConfig struct {
+	Syntax IncludeSyntax
+	// MaxDepth is the maximum nesting of includes. If it is 0,
+	// DefaultMaxIncludeDepth is used.
+	MaxDepth int
+	// Options configure the parser. For GitIncludes and SambaIncludes, the
+	// loader accepts unquoted values, as these dialects write them, with
+	// [ast.RawValues]; for SambaIncludes, it also accepts keys with spaces,
+	// such as "read only", with [ast.WithStrict](false). For MySQLIncludes
+	// and MercurialIncludes, the directives are parsed as comments: the
+	// loader adds "!" or "%" to the default comment markers, so an option
+	// that changes the comment markers must include it.
+	Options []ast.Option
+}
+
+// Includes is a configuration made of a file and of the files it includes,
+// recursively, with the include directives of an [IncludeSyntax].
+//
+// Includes is a [Layers] stack, in load order: layer 0 is the main file, and
+// each file is followed by the files it includes, in the order of the
+// directives. A file included more than once is loaded only the first time.
+//
+// Unlike [Layers.Get], [Includes.Get] and [Includes.Shadowed] resolve a key as
+// git and MySQL do, reading the files in document order with the content of
+// an included file in place of its directive: the last value read wins. Thus
+// an included file overrides the keys set before the directive, but not the
+// keys set after it.
+//
+// A relative path in a directive is relative to the directory of the file that
+// contains the directive. The directives added after loading are not
+// followed; the files whose directive has been removed after loading are read
+// at the end of the file that included them.
+type Includes struct {
+	*Layers
+	syntax    IncludeSyntax
+	parents   []int // index of the layer that includes each layer
+	directive []int // index of the directive that includes each layer
+}
+
+// LoadIncludes reads the main file filename and the files it includes. If
+// filename doesn't exist, layer 0 is empty. A missing included file, an
+// include cycle and includes nested deeper than the maximum depth are errors.
+func LoadIncludes(filename string, cfg IncludeConfig) (*Includes, error) {
+	return loadIncludes(nil, filename, cfg)
+}
+
+// LoadIncludesFS is like [LoadIncludes], but reads the files from fsys. To
+// edit the files, fsys must be a [WriteFileFS].
+func LoadIncludesFS(fsys fs.FS, filename string, cfg IncludeConfig) (*Includes, error) {
+	return loadIncludes(fsys, filename, cfg)
+}
+
+func loadIncludes(fsys fs.FS, filename string, cfg IncludeConfig) (*Includes, error) {
+	if cfg.MaxDepth == 0 {
+		cfg.MaxDepth = DefaultMaxIncludeDepth
+	}
+	opts := cfg.Options
+	rawValues := ast.WithValueTypes(ast.StringValues | ast.NumberValues | ast.RawValues)
+	switch cfg.Syntax {
+	case GitIncludes:
+		opts = append([]ast.Option{rawValues}, opts...)
+	case SambaIncludes:
+		opts = append([]ast.Option{rawValues, ast.WithStrict(false)}, opts...)
+	case MySQLIncludes:
+		opts = append([]ast.Option{ast.WithCommentMarkers("#;!")}, opts...)
+	case MercurialIncludes:
+		opts = append([]ast.Option{ast.WithCommentMarkers("#;%")}, opts...)
+	default:
+		return nil, fmt.Errorf("roundtrip_ini: invalid include syntax %d", int(cfg.Syntax))
+	}
+
+	ld := &includeLoader{
+		cfg:    cfg,
+		fsys:   fsys,
+		parser: ast.NewParser(opts...),
+		inc:    &Includes{Layers: &Layers{}, syntax: cfg.Syntax},
+		loaded: make(map[string]bool),
+	}
+	if err := ld.load(filename, -1, -1); err != nil {
+		return nil, err
+	}
+	return ld.inc, nil
+}
+
+// IncludedBy returns the index of the layer that includes layer i, or -1 for
+// the main file.
+func (inc *Includes) IncludedBy(i int) int {
+	return inc.parents[i]
+}
+
+// Get returns the effective value of keyPath: the last value read, with the
+// included files read in place of their directives. Get returns false if no
+// file has keyPath.
+func (inc *Includes) Get(keyPath ast.Path) (Setting, bool) {
+	settings := inc.settings(keyPath)
+	if len(settings) == 0 {
+		return Setting{}, false
+	}
+	return settings[len(settings)-1], true
+}
+
+// Shadowed returns the values of keyPath hidden by the effective value
+// returned by [Includes.Get], from the last to the first read.
+func (inc *Includes) Shadowed(keyPath ast.Path) []Setting {
+	settings := inc.settings(keyPath)
+	if len(settings) == 0 {
+		return nil
+	}
+	settings = settings[:len(settings)-1]
+	slices.Reverse(settings)
+	return settings
+}
+
+// settings returns the values of keyPath in the order in which they are read,
+// or the values inherited from the defaults section, as with [Layers.Get], if
+// no file has keyPath.
+func (inc *Includes) settings(keyPath ast.Path) []Setting {
+	ls := inc.Layers
+	props := make([]*ast.Property, len(ls.layers))
+	found := false
+	for i, layer := range ls.layers {
+		props[i] = layer.Tree.LookupOwn(keyPath)
+		found = found || props[i] != nil
+	}
+	if !found {
+		if defPath, ok := ls.defaultPath(keyPath); ok {
+			return inc.settings(defPath)
+		}
+		return nil
+	}
+	children := make([][]int, len(ls.layers))
+	for i := 1; i < len(ls.layers); i++ {
+		children[inc.parents[i]] = append(children[inc.parents[i]], i)
+	}
+
+	var settings []Setting
+	var read func(i int)
+	read = func(i int) {
+		layer := ls.layers[i]
+		next := 0 // next child of layer i to read
+		readUpTo := func(k int) {
+			for ; next < len(children[i]); next++ {
+				child := children[i][next]
+				if inc.directive[child] > k {
+					break
+				}
+				read(child)
+			}
+		}
+		k := 0 // index of the next directive of layer i
+		inc.syntax.walk(layer.Tree, func(prop *ast.Property, d *directive) {
+			if prop != nil && prop == props[i] {
+				settings = append(settings, Setting{Value: prop.Value, Layer: i,
+					Filename: layer.Filename, Pos: prop.Pos})
+			}
+			if d != nil {
+				readUpTo(k)
+				k++
+			}
+		})
+		readUpTo(len(ls.layers))
+	}
+	read(0)
+	return settings
+}
+
+// Update sets keyPath to value in the file that defines its effective value,
+// as returned by [Includes.Get], and saves that file. If no file defines
+// keyPath, Update adds it to the main file.
+func (inc *Includes) Update(keyPath ast.Path, value ast.Value) error {
+	if s, ok := inc.Get(keyPath); ok {
+		return inc.Set(s.Layer, keyPath, value)
+	}
+	return inc.Set(0, keyPath, value)
+}
+
+// Delete removes keyPath from the file that defines its effective value, as
+// returned by [Includes.Get], and saves that file. Note that keyPath may still
+// have a value from another file. Delete returns an error wrapping
+// [ast.ErrNotFound] if no file defines keyPath.
+func (inc *Includes) Delete(keyPath ast.Path) error {
+	s, ok := inc.Get(keyPath)
+	if !ok {
+		return fmt.Errorf("roundtrip_ini: key %q: %w", keyPath, ast.ErrNotFound)
+	}
+	return inc.Unset(s.Layer, keyPath)
+}
+
+type includeLoader struct {
+	cfg    IncludeConfig
+	fsys   fs.FS // nil for the operating system
+	parser *ast.Parser
+	inc    *Includes
+	loaded map[string]bool
+	stack  []string // files being loaded, for cycle detection
+}
+
+// directive is an include directive.
+type directive struct {
+	target string
+	dir    bool          // include the files of directory target
+	pos    ast.Position  // zero if unknown
+	prop   *ast.Property // the property of the directive, if any
+}
+
+// load loads filename and the files it includes. Parent is the index of the
+// layer that includes filename, or -1 for the main file, and nth is the index
+// of the directive of parent that includes filename.
+func (ld *includeLoader) load(filename string, parent, nth int) error {
+	if slices.Contains(ld.stack, filename) {
+		return fmt.Errorf("roundtrip_ini: include cycle: %s",
+			strings.Join(append(ld.stack, filename), " -> "))
+	}
+	if ld.loaded[filename] {
+		return nil
+	}
+	if len(ld.stack) > ld.cfg.MaxDepth {
+		return fmt.Errorf("roundtrip_ini: %s: includes nested deeper than %d levels",
+			filename, ld.cfg.MaxDepth)
+	}
+	ld.loaded[filename] = true
+
+	layer, err := loadLayer(ld.parser, ld.fsys, filename)
+	if err != nil {
+		return err
+	}
+	ls := ld.inc.Layers
+	ls.layers = append(ls.layers, layer)
+	ld.inc.parents = append(ld.inc.parents, parent)
+	ld.inc.directive = append(ld.inc.directive, nth)
+	index := len(ls.layers) - 1
+
+	var directives []directive
+	ld.cfg.Syntax.walk(layer.Tree, func(_ *ast.Property, d *directive) {
+		if d != nil {
+			directives = append(directives, *d)
+		}
+	})
+	ld.stack = append(ld.stack, filename)
+	defer func() { ld.stack = ld.stack[:len(ld.stack)-1] }()
+	for k, d := range directives {
+		if d.target == "" {
+			return fmt.Errorf("roundtrip_ini: %s: invalid include path %v", d.pos, d.prop.Value)
+		}
+		targets, err := ld.resolve(filename, d)
+		if err != nil {
+			where := filename
+			if d.pos.Line > 0 {
+				where = d.pos.String()
+			}
+			return fmt.Errorf("roundtrip_ini: %s: include %s: %w", where, d.target, err)
+		}
+		for _, target := range targets {
+			if err := ld.load(target, index, k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// walk calls visit for the properties and the include directives of tree, in
+// document order. For a directive that is a property, visit gets both; the
+// target of the directive is "" if the value of the property is not text.
+func (syntax IncludeSyntax) walk(tree *ast.AST, visit func(*ast.Property, *directive)) {
+	comments := func(texts []string) {
+		if syntax != MySQLIncludes && syntax != MercurialIncludes {
+			return
+		}
+		for _, text := range texts {
+			if d, ok := syntax.commentDirective(text); ok {
+				visit(nil, &d)
+			}
+		}
+	}
+	property := func(sec *ast.Section, prop *ast.Property) {
+		comments(prop.Comments)
+		if !syntax.isDirective(sec, prop.Key) {
+			visit(prop, nil)
+			return
+		}
+		d := directive{pos: prop.Pos, prop: prop}
+		switch val := prop.Value.(type) {
+		case ast.String:
+			d.target = val.Value
+		case ast.Raw:
+			d.target = val.Value
+		}
+		visit(prop, &d)
+	}
+
+	for _, prop := range tree.Properties {
+		property(nil, prop)
+	}
+	for _, sec := range tree.Sections {
+		comments(sec.Comments)
+		for _, prop := range sec.Properties {
+			property(sec, prop)
+		}
+	}
+	comments(tree.Comments)
+}
+
+// isDirective reports whether key of section sec (nil for the global section)
+// is an include directive, for the syntaxes that use properties.
+func (syntax IncludeSyntax) isDirective(sec *ast.Section, key string) bool {
+	switch syntax {
+	case SambaIncludes:
+		return strings.EqualFold(key, "include")
+	case GitIncludes:
+		return sec != nil && strings.EqualFold(sec.Name, "include") &&
+			sec.Subsection == "" && strings.EqualFold(key, "path")
+	}
+	return false
+}
+
+// commentDirective parses an include directive in a comment, for the
+// syntaxes that use comments.
+func (syntax IncludeSyntax) commentDirective(text string) (directive, bool) {
+	type form struct {
+		prefix string
+		dir    bool
+	}
+	forms := []form{{"%include", false}}
+	if syntax == MySQLIncludes {
+		forms = []form{{"!includedir", true}, {"!include", false}}
+	}
+	for _, f := range forms {
+		rest, ok := strings.CutPrefix(text, f.prefix)
+		if !ok || rest == "" || (rest[0] != ' ' && rest[0] != '\t') {
+			continue
+		}
+		if target := strings.TrimSpace(rest); target != "" {
+			return directive{target: target, dir: f.dir}, true
+		}
+	}
+	return directive{}, false
+}
+
+// resolve returns the files included by directive d of file filename.
+func (ld *includeLoader) resolve(filename string, d directive) ([]string, error) {
+	var target string
+	if ld.fsys == nil {
+		target = d.target
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(filename), target)
+		}
+	} else {
+		target = path.Join(path.Dir(filename), d.target)
+	}
+
+	if !d.dir {
+		if _, err := ld.stat(target); err != nil {
+			return nil, err
+		}
+		return []string{target}, nil
+	}
+
+	var entries []fs.DirEntry
+	var err error
+	if ld.fsys == nil {
+		entries, err = os.ReadDir(target)
+	} else {
+		entries, err = fs.ReadDir(ld.fsys, target)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var targets []string
+	for _, entry := range entries { // sorted by name
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".cnf") {
+			if ld.fsys == nil {
+				targets = append(targets, filepath.Join(target, entry.Name()))
+			} else {
+				targets = append(targets, path.Join(target, entry.Name()))
+			}
+		}
+	}
+	return targets, nil
+}
+
+func (ld *includeLoader) stat(name string) (fs.FileInfo, error) {
+	var info fs.FileInfo
+	var err error
+	if ld.fsys == nil {
+		info, err = os.Stat(name)
+	} else {
+		info, err = fs.Stat(ld.fsys, name)
+	}
+	if err == nil && info.IsDir() {
+		err = errors.New("is a directory")
+	}
+	return info, err
+}
@@ -0,0 +1,413 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package roundtrip_ini_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-quicktest/qt"
+
+	"github.com/marco-m/roundtrip_ini"
+	"github.com/marco-m/roundtrip_ini/ast"
+)
+
+var gitIncludeFiles = map[string]string{
+	"config": `[core]
+editor = "vi"
+pager = "less"
+[include]
+path = "conf/user.inc"
+path = "conf/work.inc"
+`,
+	"conf/user.inc": `[user]
+name = "me"
+[include]
+path = "../common.inc"
+`,
+	"conf/work.inc": "[core]\neditor = \"emacs\"\n[include]\npath = \"../common.inc\"\n",
+	"common.inc":    "[user]\nemail = \"me@example.com\"\n",
+}
+
+func TestIncludes(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(), gitIncludeFiles)
+	main := filepath.Join(dir, "config")
+
+	inc, err := roundtrip_ini.LoadIncludes(main, roundtrip_ini.IncludeConfig{
+		Syntax: roundtrip_ini.GitIncludes,
+	})
+	qt.Assert(t, qt.IsNil(err))
+
+	var have []string
+	for i := range inc.Len() {
+		rel, _ := filepath.Rel(dir, inc.Layer(i).Filename)
+		have = append(have, filepath.ToSlash(rel))
+	}
+	qt.Assert(t, qt.DeepEquals(have,
+		[]string{"config", "conf/user.inc", "common.inc", "conf/work.inc"}))
+	qt.Assert(t, qt.Equals(inc.IncludedBy(0), -1))
+	qt.Assert(t, qt.Equals(inc.IncludedBy(2), 1))
+	qt.Assert(t, qt.Equals(inc.IncludedBy(3), 0))
+
+	setting, ok := inc.Get("core/editor")
+	qt.Assert(t, qt.IsTrue(ok))
+	qt.Assert(t, qt.Equals(setting.Value, ast.Value(ast.String{Value: "emacs"})))
+	qt.Assert(t, qt.Equals(setting.Layer, 3))
+	setting, _ = inc.Get("user/email")
+	qt.Assert(t, qt.Equals(setting.Layer, 2))
+}
+
+func TestIncludesEdit(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(), gitIncludeFiles)
+	main := filepath.Join(dir, "config")
+	inc, err := roundtrip_ini.LoadIncludes(main, roundtrip_ini.IncludeConfig{
+		Syntax: roundtrip_ini.GitIncludes,
+	})
+	qt.Assert(t, qt.IsNil(err))
+
+	qt.Assert(t, qt.IsNil(inc.Update("user/email", ast.String{Value: "new@example.com"})))
+	qt.Assert(t, qt.IsNil(inc.Update("core/pager", ast.String{Value: "more"})))
+	qt.Assert(t, qt.IsNil(inc.Update("core/autocrlf", ast.Number{Value: 0})))
+	qt.Assert(t, qt.Equals(readFile(t, filepath.Join(dir, "common.inc")),
+		"[user]\nemail = \"new@example.com\"\n"))
+	qt.Assert(t, qt.Equals(readFile(t, main), `[core]
+editor = "vi"
+pager = "more"
+autocrlf = 0
+[include]
+path = "conf/user.inc"
+path = "conf/work.inc"
+`))
+
+	qt.Assert(t, qt.IsNil(inc.Delete("core/editor")))
+	qt.Assert(t, qt.Equals(readFile(t, filepath.Join(dir, "conf", "work.inc")),
+		"[core]\n[include]\npath = \"../common.inc\"\n"))
+	setting, _ := inc.Get("core/editor")
+	qt.Assert(t, qt.Equals(setting.Layer, 0))
+
+	err = inc.Delete("core/missing")
+	qt.Assert(t, qt.IsTrue(errors.Is(err, ast.ErrNotFound)))
+}
+
+func TestIncludesSyntax(t *testing.T) {
+	type testCase struct {
+		name   string
+		syntax roundtrip_ini.IncludeSyntax
+		files  map[string]string
+		want   []string
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		mapFS := fstest.MapFS{}
+		for name, content := range tc.files {
+			mapFS[name] = &fstest.MapFile{Data: []byte(content)}
+		}
+
+		inc, err := roundtrip_ini.LoadIncludesFS(mapFS, "etc/main",
+			roundtrip_ini.IncludeConfig{Syntax: tc.syntax})
+
+		qt.Assert(t, qt.IsNil(err))
+		var have []string
+		for i := range inc.Len() {
+			have = append(have, inc.Layer(i).Filename)
+		}
+		qt.Assert(t, qt.DeepEquals(have, tc.want))
+	}
+
+	testCases := []testCase{
+		{
+			name:   "MySQL",
+			syntax: roundtrip_ini.MySQLIncludes,
+			files: map[string]string{
+				"etc/main": `[mysqld]
+port = 3306
+!include extra.cnf
+# not a directive
+#!include ignored.cnf
+!includedir conf.d
+`,
+				"etc/extra.cnf":     "[client]\nport = 3307\n",
+				"etc/conf.d/b.cnf":  "[mysqld]\nport = 3308\n",
+				"etc/conf.d/a.cnf":  "!include ../extra.cnf\n",
+				"etc/conf.d/README": "not included",
+			},
+			want: []string{"etc/main", "etc/extra.cnf", "etc/conf.d/a.cnf", "etc/conf.d/b.cnf"},
+		},
+		{
+			name:   "MySQL Debian layout",
+			syntax: roundtrip_ini.MySQLIncludes,
+			files: map[string]string{
+				"etc/main": `# The MariaDB configuration file
+#
+# The MariaDB/MySQL tools read configuration files in the following order:
+# 0. "/etc/mysql/my.cnf" symlinks to this file, reason why all the rest is read.
+# 1. "/etc/mysql/mariadb.cnf" (this file) to set global defaults,
+# 2. "/etc/mysql/conf.d/*.cnf" to set global options.
+
+#
+# * IMPORTANT: Additional settings that can override those from this file!
+#   The files must end with '.cnf', otherwise they'll be ignored.
+#
+
+!includedir conf.d/
+!includedir mariadb.conf.d/
+`,
+				"etc/conf.d/mysql.cnf":             "[mysql]\n",
+				"etc/mariadb.conf.d/50-server.cnf": "[mysqld]\n\n# this is read by the standalone daemon\n\nport = 3306\n",
+			},
+			want: []string{"etc/main", "etc/conf.d/mysql.cnf", "etc/mariadb.conf.d/50-server.cnf"},
+		},
+		{
+			name:   "Mercurial",
+			syntax: roundtrip_ini.MercurialIncludes,
+			files: map[string]string{
+				"etc/main": `%include hgrc.d/ui.rc
+[ui]
+username = "me"
+%include hgrc.d/paths.rc
+`,
+				"etc/hgrc.d/ui.rc":    "[ui]\nverbose = 1\n",
+				"etc/hgrc.d/paths.rc": "[paths]\ndefault = \"x\"\n",
+			},
+			want: []string{"etc/main", "etc/hgrc.d/ui.rc", "etc/hgrc.d/paths.rc"},
+		},
+		{
+			name:   "Samba",
+			syntax: roundtrip_ini.SambaIncludes,
+			files: map[string]string{
+				"etc/main": `[global]
+workgroup = "HOME"
+include = "shares.conf"
+[homes]
+include = "homes.conf"
+`,
+				"etc/shares.conf": "[public]\npath = \"/srv\"\n",
+				"etc/homes.conf":  "[homes]\nbrowseable = 0\n",
+			},
+			want: []string{"etc/main", "etc/shares.conf", "etc/homes.conf"},
+		},
+		{
+			name:   "git unquoted",
+			syntax: roundtrip_ini.GitIncludes,
+			files: map[string]string{
+				"etc/main": `[user]
+	name = Jane Doe
+	email = jane@example.com
+[include]
+	path = other.inc
+`,
+				"etc/other.inc": "[core]\n\teditor = vim -u NONE\n",
+			},
+			want: []string{"etc/main", "etc/other.inc"},
+		},
+		{
+			name:   "Samba unquoted",
+			syntax: roundtrip_ini.SambaIncludes,
+			files: map[string]string{
+				"etc/main": `[global]
+   workgroup = WORKGROUP
+   server string = %h server (Samba, Ubuntu)
+   include = smb.%m.conf
+[printers]
+   read only = yes
+`,
+				"etc/smb.%m.conf": "[public]\n   path = /srv/public\n   guest ok = yes\n",
+			},
+			want: []string{"etc/main", "etc/smb.%m.conf"},
+		},
+		{
+			name:   "git ignores other sections",
+			syntax: roundtrip_ini.GitIncludes,
+			files: map[string]string{
+				"etc/main": "[core]\npath = \"x\"\n[include \"sub\"]\npath = \"y\"\n",
+			},
+			want: []string{"etc/main"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) { run(t, tc) })
+	}
+}
+
+func TestIncludesUnquotedAbsolutePath(t *testing.T) {
+	dir := t.TempDir()
+	extra := filepath.Join(dir, "extra.conf")
+	writeFiles(t, dir, map[string]string{
+		"smb.conf":   "[global]\n   include = " + extra + "\n   log level = 1\n",
+		"extra.conf": "[global]\n   log level = 3\n",
+	})
+
+	inc, err := roundtrip_ini.LoadIncludes(filepath.Join(dir, "smb.conf"),
+		roundtrip_ini.IncludeConfig{Syntax: roundtrip_ini.SambaIncludes})
+
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(inc.Len(), 2))
+	qt.Assert(t, qt.Equals(inc.Layer(1).Filename, extra))
+	setting, ok := inc.Get("global/log level")
+	qt.Assert(t, qt.IsTrue(ok))
+	qt.Assert(t, qt.Equals(setting.Value, ast.Value(ast.Number{Value: 1})))
+}
+
+func TestIncludesDefaultsSection(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(), map[string]string{
+		"main":     "[DEFAULT]\ninclude = \"inc.conf\"\n[server]\ntimeout = 30\n",
+		"inc.conf": "[DEFAULT]\ntimeout = 10\n",
+	})
+	main := filepath.Join(dir, "main")
+	inc, err := roundtrip_ini.LoadIncludes(main, roundtrip_ini.IncludeConfig{
+		Syntax:  roundtrip_ini.SambaIncludes,
+		Options: []ast.Option{ast.WithDefaultsSection("DEFAULT")},
+	})
+	qt.Assert(t, qt.IsNil(err))
+
+	qt.Assert(t, qt.IsNil(inc.Update("server/timeout", ast.Number{Value: 60})))
+
+	qt.Assert(t, qt.Equals(readFile(t, main),
+		"[DEFAULT]\ninclude = \"inc.conf\"\n[server]\ntimeout = 60\n"))
+	qt.Assert(t, qt.Equals(readFile(t, filepath.Join(dir, "inc.conf")), "[DEFAULT]\ntimeout = 10\n"))
+}
+
+func TestIncludesOrder(t *testing.T) {
+	type testCase struct {
+		name      string
+		syntax    roundtrip_ini.IncludeSyntax
+		main      string
+		included  string
+		wantLayer map[ast.Path]int // layer of the effective value of each key
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		mapFS := writableFS{fstest.MapFS{
+			"main":   &fstest.MapFile{Data: []byte(tc.main)},
+			"inc.rc": &fstest.MapFile{Data: []byte(tc.included)},
+		}}
+		inc, err := roundtrip_ini.LoadIncludesFS(mapFS, "main",
+			roundtrip_ini.IncludeConfig{Syntax: tc.syntax})
+		qt.Assert(t, qt.IsNil(err))
+
+		for keyPath, want := range tc.wantLayer {
+			setting, ok := inc.Get(keyPath)
+			qt.Assert(t, qt.IsTrue(ok))
+			qt.Assert(t, qt.Equals(setting.Layer, want), qt.Commentf("key %s", keyPath))
+			shadowed := inc.Shadowed(keyPath)
+			qt.Assert(t, qt.HasLen(shadowed, 1))
+			qt.Assert(t, qt.Equals(shadowed[0].Layer, 1-want))
+		}
+
+		// Update edits the file that defines the effective value.
+		for keyPath, want := range tc.wantLayer {
+			qt.Assert(t, qt.IsNil(inc.Update(keyPath, ast.Number{Value: 9})))
+			setting, _ := inc.Get(keyPath)
+			qt.Assert(t, qt.Equals(setting.Layer, want))
+			qt.Assert(t, qt.Equals(setting.Value, ast.Value(ast.Number{Value: 9})))
+		}
+	}
+
+	testCases := []testCase{
+		{
+			name:      "git",
+			syntax:    roundtrip_ini.GitIncludes,
+			main:      "[s1]\nbefore = 1\n[include]\npath = \"inc.rc\"\n[s2]\nafter = 1\n",
+			included:  "[s1]\nbefore = 2\n[s2]\nafter = 2\n",
+			wantLayer: map[ast.Path]int{"s1/before": 1, "s2/after": 0},
+		},
+		{
+			name:      "MySQL",
+			syntax:    roundtrip_ini.MySQLIncludes,
+			main:      "[s1]\nbefore = 1\n!include inc.rc\nafter = 1\n",
+			included:  "[s1]\nbefore = 2\nafter = 2\n",
+			wantLayer: map[ast.Path]int{"s1/before": 1, "s1/after": 0},
+		},
+		{
+			name:      "MySQL before section",
+			syntax:    roundtrip_ini.MySQLIncludes,
+			main:      "[s1]\nbefore = 1\n!include inc.rc\n[s2]\nafter = 1\n",
+			included:  "[s1]\nbefore = 2\n[s2]\nafter = 2\n",
+			wantLayer: map[ast.Path]int{"s1/before": 1, "s2/after": 0},
+		},
+		{
+			name:      "MySQL at end of file",
+			syntax:    roundtrip_ini.MySQLIncludes,
+			main:      "[s1]\nbefore = 1\nafter = 1\n!include inc.rc\n",
+			included:  "[s1]\nbefore = 2\n",
+			wantLayer: map[ast.Path]int{"s1/before": 1},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) { run(t, tc) })
+	}
+}
+
+func TestIncludesErrors(t *testing.T) {
+	type testCase struct {
+		name     string
+		files    map[string]string
+		maxDepth int
+		wantErr  string
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		mapFS := fstest.MapFS{}
+		for name, content := range tc.files {
+			mapFS[name] = &fstest.MapFile{Data: []byte(content)}
+		}
+
+		_, err := roundtrip_ini.LoadIncludesFS(mapFS, "a", roundtrip_ini.IncludeConfig{
+			Syntax:   roundtrip_ini.SambaIncludes,
+			MaxDepth: tc.maxDepth,
+		})
+
+		qt.Assert(t, qt.ErrorMatches(err, tc.wantErr))
+	}
+
+	testCases := []testCase{
+		{
+			name: "cycle",
+			files: map[string]string{
+				"a":     "include = \"b\"\n",
+				"b":     "include = \"sub/c\"\n",
+				"sub/c": "x = 1\ninclude = \"../b\"\n",
+			},
+			wantErr: `roundtrip_ini: include cycle: a -> b -> sub/c -> b`,
+		},
+		{
+			name:    "self",
+			files:   map[string]string{"a": "include = \"a\"\n"},
+			wantErr: `roundtrip_ini: include cycle: a -> a`,
+		},
+		{
+			name: "too deep",
+			files: map[string]string{
+				"a": "include = \"b\"\n",
+				"b": "include = \"c\"\n",
+				"c": "x = 1\n",
+			},
+			maxDepth: 1,
+			wantErr:  `roundtrip_ini: c: includes nested deeper than 1 levels`,
+		},
+		{
+			name:    "missing file",
+			files:   map[string]string{"a": "x = 1\n[s1]\ninclude = \"b\"\n"},
+			wantErr: `roundtrip_ini: a:3:1: include b: open b: file does not exist`,
+		},
+		{
+			name:    "invalid path",
+			files:   map[string]string{"a": "include = 1\n"},
+			wantErr: `roundtrip_ini: a:1:1: invalid include path 1`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) { run(t, tc) })
+	}
+}
+
+func TestIncludesInvalidSyntax(t *testing.T) {
+	_, err := roundtrip_ini.LoadIncludes("x.ini", roundtrip_ini.IncludeConfig{})
+	qt.Assert(t, qt.ErrorMatches(err, `roundtrip_ini: invalid include syntax 0`))
+}
@@ -0,0 +1,307 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package roundtrip_ini
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/marco-m/roundtrip_ini/ast"
+)
+
+// Layer is a file of a [Layers] stack.
+type Layer struct {
+	// Filename is the path of the file.
+	Filename string
+	// Tree is the content of the file. The tree of a missing file is empty.
+	Tree *ast.AST
+
+	fsys fs.FS // nil for the operating system
+}
+
+// WriteFileFS is a file system that can also write files. The layers loaded
+// from a WriteFileFS can be saved.
+type WriteFileFS interface {
+	fs.FS
+	// WriteFile writes data to the named file, creating it if necessary, as
+	// [os.WriteFile] does.
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+}
+
+// Save writes Tree to Filename, creating the file if needed. On disk, Save
+// writes a temporary file in the same directory and renames it, so that the
+// file is never left half-written. For a layer loaded from an [fs.FS], Save
+// returns an error wrapping [errors.ErrUnsupported] unless the file system is
+// a [WriteFileFS].
+func (l *Layer) Save() error {
+	return writeLayer(l.fsys, l.Filename, l.Tree)
+}
+
+// Layers is a stack of INI files, from the lowest to the highest priority,
+// such as the system, global and local configuration files of git. The
+// effective value of a key is the one in the highest-priority layer that has
+// the key; the values of the key in the lower-priority layers are shadowed.
+type Layers struct {
+	layers []*Layer
+}
+
+// LoadLayers reads the files filenames, from the lowest to the highest
+// priority, with a parser configured by opts. A file that doesn't exist is
+// loaded as an empty layer, which [Layers.Set] creates when needed.
+func LoadLayers(filenames []string, opts ...ast.Option) (*Layers, error) {
+	return loadLayers(nil, filenames, opts)
+}
+
+// LoadLayersFS is like [LoadLayers], but reads the files from fsys.
+func LoadLayersFS(fsys fs.FS, filenames []string, opts ...ast.Option) (*Layers, error) {
+	return loadLayers(fsys, filenames, opts)
+}
+
+func loadLayers(fsys fs.FS, filenames []string, opts []ast.Option) (*Layers, error) {
+	parser := ast.NewParser(opts...)
+	ls := &Layers{}
+	for _, filename := range filenames {
+		layer, err := loadLayer(parser, fsys, filename)
+		if err != nil {
+			return nil, err
+		}
+		ls.layers = append(ls.layers, layer)
+	}
+	return ls, nil
+}
+
+// loadLayer parses filename from fsys (nil for the operating system). If
+// filename doesn't exist, the layer is empty.
+func loadLayer(parser *ast.Parser, fsys fs.FS, filename string) (*Layer, error) {
+	var data []byte
+	var err error
+	if fsys == nil {
+		data, err = os.ReadFile(filename)
+	} else {
+		data, err = fs.ReadFile(fsys, filename)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		data, err = nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("roundtrip_ini: %w", err)
+	}
+	tree, err := parser.ParseBytes(filename, data)
+	if err != nil {
+		return nil, fmt.Errorf("roundtrip_ini: %w", err)
+	}
+	return &Layer{Filename: filename, Tree: tree, fsys: fsys}, nil
+}
+
+// writeLayer writes tree to filename in fsys (nil for the operating system).
+func writeLayer(fsys fs.FS, filename string, tree *ast.AST) error {
+	if fsys == nil {
+		return writeFile(filename, tree)
+	}
+	wfs, ok := fsys.(WriteFileFS)
+	if !ok {
+		return fmt.Errorf("roundtrip_ini: %s: %w", filename, errors.ErrUnsupported)
+	}
+	if err := wfs.WriteFile(filename, []byte(tree.String()), 0o644); err != nil {
+		return fmt.Errorf("roundtrip_ini: %w", err)
+	}
+	return nil
+}
+
+// writeFile writes tree to filename, through a temporary file in the same
+// directory. The permissions of an existing file are kept.
+func writeFile(filename string, tree *ast.AST) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("roundtrip_ini: %w", err)
+		}
+	}()
+	perm := fs.FileMode(0o644)
+	if info, err := os.Stat(filename); err == nil {
+		perm = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err := tree.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// Len returns the number of layers.
+func (ls *Layers) Len() int {
+	return len(ls.layers)
+}
+
+// Layer returns layer i, where 0 is the lowest-priority layer. It panics if
+// i is out of range.
+func (ls *Layers) Layer(i int) *Layer {
+	return ls.layers[i]
+}
+
+// Setting is the value of a key in a layer, with its provenance.
+type Setting struct {
+	Value ast.Value
+	// Layer is the index of the layer.
+	Layer int
+	// Filename is the file of the layer.
+	Filename string
+	// Pos is the position of the key in the file. It is the zero Position
+	// for a key set after loading.
+	Pos ast.Position
+}
+
+// String returns the setting in the format "filename:line: value".
+func (s Setting) String() string {
+	return fmt.Sprintf("%s:%d: %v", s.Filename, s.Pos.Line, s.Value)
+}
+
+// Get returns the effective value of keyPath, from the highest-priority layer
+// that has it. Get returns false if no layer has keyPath.
+//
+// If the files are parsed with a defaults section (see
+// [ast.WithDefaultsSection]), a key that no layer has falls back to the key of
+// the defaults section, from the highest-priority layer that has it, if a
+// layer has the section of keyPath. Thus a key of the defaults section never
+// overrides the same key of another section in a lower-priority layer. Get
+// doesn't follow the parents of a section (see [ast.WithInheritance]).
+func (ls *Layers) Get(keyPath ast.Path) (Setting, bool) {
+	settings := ls.settings(keyPath)
+	if len(settings) == 0 {
+		return Setting{}, false
+	}
+	return settings[0], true
+}
+
+// Shadowed returns the values of keyPath hidden by the effective value
+// returned by [Layers.Get], from the highest to the lowest priority.
+func (ls *Layers) Shadowed(keyPath ast.Path) []Setting {
+	settings := ls.settings(keyPath)
+	if len(settings) == 0 {
+		return nil
+	}
+	return settings[1:]
+}
+
+// settings returns the values of keyPath, from the highest to the lowest
+// priority, or the values inherited from the defaults section if no layer has
+// keyPath.
+func (ls *Layers) settings(keyPath ast.Path) []Setting {
+	var settings []Setting
+	for i := len(ls.layers) - 1; i >= 0; i-- {
+		if s, ok := ls.setting(i, keyPath); ok {
+			settings = append(settings, s)
+		}
+	}
+	if len(settings) > 0 {
+		return settings
+	}
+	if defPath, ok := ls.defaultPath(keyPath); ok {
+		return ls.settings(defPath)
+	}
+	return nil
+}
+
+// setting returns the value of keyPath in layer i, without the keys inherited
+// from the defaults section or from the parents of a section.
+func (ls *Layers) setting(i int, keyPath ast.Path) (Setting, bool) {
+	layer := ls.layers[i]
+	prop := layer.Tree.LookupOwn(keyPath)
+	if prop == nil {
+		return Setting{}, false
+	}
+	return Setting{Value: prop.Value, Layer: i, Filename: layer.Filename, Pos: prop.Pos}, true
+}
+
+// defaultPath returns the path of the key of keyPath in the defaults section,
+// if the layers have a defaults section and a layer has the section of
+// keyPath, which is not the defaults section.
+func (ls *Layers) defaultPath(keyPath ast.Path) (ast.Path, bool) {
+	var defaults string
+	hasDefaults := false
+	for _, layer := range ls.layers {
+		if defaults, hasDefaults = layer.Tree.DefaultsSection(); hasDefaults {
+			break
+		}
+	}
+	if !hasDefaults {
+		return "", false
+	}
+	secName := ast.SectionName(keyPath.Section(), keyPath.Subsection())
+	hasSection := secName == ""
+	var defPath ast.Path
+	if defaults == "" {
+		defPath = ast.NewPath("", keyPath.Key())
+	}
+	for _, layer := range ls.layers {
+		hasSection = hasSection || layer.Tree.LookupSection(secName) != nil
+		if sec := layer.Tree.LookupSection(defaults); sec != nil && defPath == "" {
+			defPath = ast.NewSubsectionPath(sec.Name, sec.Subsection, keyPath.Key())
+		}
+	}
+	if !hasSection || defPath == "" || defPath == keyPath {
+		return "", false
+	}
+	return defPath, true
+}
+
+// Set sets keyPath to value in layer i, as [ast.AST.Add] does, and saves the
+// layer. The other layers are not touched. If saving fails, the layer is left
+// unchanged.
+func (ls *Layers) Set(i int, keyPath ast.Path, value ast.Value) error {
+	return ls.edit(i, func(tree *ast.AST) error {
+		tree.Add(keyPath, value)
+		return nil
+	})
+}
+
+// Unset removes keyPath from layer i and saves the layer. The other layers
+// are not touched, so the key may still have a value from another layer.
+// Unset returns an error wrapping [ast.ErrNotFound] if layer i doesn't have
+// keyPath.
+func (ls *Layers) Unset(i int, keyPath ast.Path) error {
+	return ls.edit(i, func(tree *ast.AST) error {
+		if _, outcome := tree.Remove(keyPath); outcome == ast.NotFound {
+			return fmt.Errorf("roundtrip_ini: %s: key %q: %w", ls.layers[i].Filename,
+				keyPath, ast.ErrNotFound)
+		}
+		return nil
+	})
+}
+
+// edit applies change to a copy of layer i and, if both change and saving
+// succeed, replaces the layer with the copy.
+func (ls *Layers) edit(i int, change func(*ast.AST) error) error {
+	if i < 0 || i >= len(ls.layers) {
+		return fmt.Errorf("roundtrip_ini: layer %d out of range [0, %d]", i, len(ls.layers)-1)
+	}
+	layer := ls.layers[i]
+	tree := layer.Tree.Clone()
+	if err := change(tree); err != nil {
+		return err
+	}
+	if err := writeLayer(layer.fsys, layer.Filename, tree); err != nil {
+		return err
+	}
+	layer.Tree = tree
+	return nil
+}
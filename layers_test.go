@@ -0,0 +1,172 @@
+// Copyright 2022 Marco Molteni and contributors. All rights reserved.
+// Use of this source code is governed by the MIT license; see file LICENSE.
+
+package roundtrip_ini_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+
+	"github.com/marco-m/roundtrip_ini"
+	"github.com/marco-m/roundtrip_ini/ast"
+)
+
+// writeFiles creates the files in dir, with path relative to dir as key and
+// content as value, and returns dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) string {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		qt.Assert(t, qt.IsNil(os.MkdirAll(filepath.Dir(path), 0o755)))
+		qt.Assert(t, qt.IsNil(os.WriteFile(path, []byte(content), 0o644)))
+	}
+	return dir
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	qt.Assert(t, qt.IsNil(err))
+	return string(data)
+}
+
+func loadTestLayers(t *testing.T) (*roundtrip_ini.Layers, []string) {
+	t.Helper()
+	dir := writeFiles(t, t.TempDir(), map[string]string{
+		"system": "[core]\neditor = \"vi\"\npager = \"less\"\n",
+		"global": "# my settings\n[core]\n\neditor = \"emacs\"\n[user]\nname = \"me\"\n",
+	})
+	filenames := []string{
+		filepath.Join(dir, "system"),
+		filepath.Join(dir, "global"),
+		filepath.Join(dir, "local"), // missing
+	}
+	layers, err := roundtrip_ini.LoadLayers(filenames)
+	qt.Assert(t, qt.IsNil(err))
+	return layers, filenames
+}
+
+func TestLayersGet(t *testing.T) {
+	layers, filenames := loadTestLayers(t)
+	qt.Assert(t, qt.Equals(layers.Len(), 3))
+
+	setting, ok := layers.Get("core/editor")
+	qt.Assert(t, qt.IsTrue(ok))
+	qt.Assert(t, qt.Equals(setting.Value, ast.Value(ast.String{Value: "emacs"})))
+	qt.Assert(t, qt.Equals(setting.Layer, 1))
+	qt.Assert(t, qt.Equals(setting.Filename, filenames[1]))
+	qt.Assert(t, qt.Equals(setting.Pos.Line, 4))
+	qt.Assert(t, qt.Equals(setting.String(), filenames[1]+`:4: "emacs"`))
+
+	setting, ok = layers.Get("core/pager")
+	qt.Assert(t, qt.IsTrue(ok))
+	qt.Assert(t, qt.Equals(setting.Layer, 0))
+	qt.Assert(t, qt.Equals(setting.Pos.Line, 3))
+
+	_, ok = layers.Get("core/missing")
+	qt.Assert(t, qt.IsFalse(ok))
+}
+
+func TestLayersShadowed(t *testing.T) {
+	layers, filenames := loadTestLayers(t)
+
+	shadowed := layers.Shadowed("core/editor")
+	qt.Assert(t, qt.HasLen(shadowed, 1))
+	qt.Assert(t, qt.Equals(shadowed[0].Value, ast.Value(ast.String{Value: "vi"})))
+	qt.Assert(t, qt.Equals(shadowed[0].Filename, filenames[0]))
+	qt.Assert(t, qt.Equals(shadowed[0].Pos.Line, 2))
+
+	qt.Assert(t, qt.HasLen(layers.Shadowed("core/pager"), 0))
+	qt.Assert(t, qt.HasLen(layers.Shadowed("core/missing"), 0))
+}
+
+func TestLayersDefaultsSection(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(), map[string]string{
+		"system": "[DEFAULT]\ntimeout = 10\n[server]\ntimeout = 30\n",
+		"local":  "[DEFAULT]\ntimeout = 20\nuser = \"me\"\n[client]\n",
+	})
+	filenames := []string{filepath.Join(dir, "system"), filepath.Join(dir, "local")}
+	layers, err := roundtrip_ini.LoadLayers(filenames, ast.WithDefaultsSection("DEFAULT"))
+	qt.Assert(t, qt.IsNil(err))
+
+	// The default of a higher layer doesn't override a key of a lower layer.
+	setting, ok := layers.Get("server/timeout")
+	qt.Assert(t, qt.IsTrue(ok))
+	qt.Assert(t, qt.Equals(setting.Layer, 0))
+	qt.Assert(t, qt.Equals(setting.Value, ast.Value(ast.Number{Value: 30})))
+	qt.Assert(t, qt.HasLen(layers.Shadowed("server/timeout"), 0))
+
+	// A key that no layer has falls back to the defaults, across the layers.
+	setting, _ = layers.Get("server/user")
+	qt.Assert(t, qt.Equals(setting.Layer, 1))
+	setting, _ = layers.Get("client/timeout")
+	qt.Assert(t, qt.Equals(setting.Layer, 1))
+	qt.Assert(t, qt.Equals(setting.Pos.Line, 2))
+	shadowed := layers.Shadowed("client/timeout")
+	qt.Assert(t, qt.HasLen(shadowed, 1))
+	qt.Assert(t, qt.Equals(shadowed[0].Layer, 0))
+
+	// Missing sections don't inherit.
+	_, ok = layers.Get("nope/timeout")
+	qt.Assert(t, qt.IsFalse(ok))
+}
+
+func TestLayersSet(t *testing.T) {
+	layers, filenames := loadTestLayers(t)
+	system := readFile(t, filenames[0])
+
+	qt.Assert(t, qt.IsNil(layers.Set(2, "core/editor", ast.String{Value: "nano"})))
+	qt.Assert(t, qt.IsNil(layers.Set(1, "user/email", ast.String{Value: "me@example.com"})))
+
+	setting, _ := layers.Get("core/editor")
+	qt.Assert(t, qt.Equals(setting.Layer, 2))
+	qt.Assert(t, qt.Equals(setting.Value, ast.Value(ast.String{Value: "nano"})))
+	qt.Assert(t, qt.HasLen(layers.Shadowed("core/editor"), 2))
+
+	qt.Assert(t, qt.Equals(readFile(t, filenames[0]), system))
+	qt.Assert(t, qt.Equals(readFile(t, filenames[1]),
+		"# my settings\n[core]\n\neditor = \"emacs\"\n[user]\nname = \"me\"\nemail = \"me@example.com\"\n"))
+	qt.Assert(t, qt.Equals(readFile(t, filenames[2]), "[core]\neditor = \"nano\"\n"))
+
+	reloaded, err := roundtrip_ini.LoadLayers(filenames)
+	qt.Assert(t, qt.IsNil(err))
+	setting, _ = reloaded.Get("core/editor")
+	qt.Assert(t, qt.Equals(setting.Pos.Line, 2))
+}
+
+func TestLayersUnset(t *testing.T) {
+	layers, filenames := loadTestLayers(t)
+
+	qt.Assert(t, qt.IsNil(layers.Unset(1, "core/editor")))
+
+	setting, _ := layers.Get("core/editor")
+	qt.Assert(t, qt.Equals(setting.Layer, 0))
+	qt.Assert(t, qt.Equals(readFile(t, filenames[1]),
+		"# my settings\n[core]\n\n[user]\nname = \"me\"\n"))
+
+	err := layers.Unset(1, "core/editor")
+	qt.Assert(t, qt.ErrorIs(err, ast.ErrNotFound))
+	qt.Assert(t, qt.Equals(err.Error(),
+		"roundtrip_ini: "+filenames[1]+`: key "core/editor": not found`))
+}
+
+func TestLayersErrors(t *testing.T) {
+	layers, filenames := loadTestLayers(t)
+
+	err := layers.Set(3, "a", ast.Number{Value: 1})
+	qt.Assert(t, qt.ErrorMatches(err, `roundtrip_ini: layer 3 out of range \[0, 2\]`))
+
+	// Saving fails: the layer is unchanged.
+	layer := layers.Layer(2)
+	layer.Filename = filepath.Join(filenames[2], "not-a-dir", "local")
+	err = layers.Set(2, "a", ast.Number{Value: 1})
+	qt.Assert(t, qt.IsNotNil(err))
+	qt.Assert(t, qt.IsNil(layer.Tree.Lookup("a")))
+
+	dir := writeFiles(t, t.TempDir(), map[string]string{"bad": "[unterminated\n"})
+	_, err = roundtrip_ini.LoadLayers([]string{filepath.Join(dir, "bad")})
+	qt.Assert(t, qt.ErrorMatches(err, `roundtrip_ini: .*bad:1:.*`))
+}